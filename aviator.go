@@ -2,8 +2,11 @@ package aviator
 
 import (
 	"context"
+	"crypto/sha256"
 	_ "embed"
 	"errors"
+	"fmt"
+	"io"
 	"text/template"
 
 	"github.com/mansoor-s/aviator/builder"
@@ -13,13 +16,20 @@ import (
 //go:embed embedded_assets/svelte_compiler.js
 var svelteCompilerCode string
 
+// svelteCompilerHash changes with the embedded compiler, invalidating the caches
+// of components compiled by a previous version
+var svelteCompilerHash = fmt.Sprintf("%x", sha256.Sum256([]byte(svelteCompilerCode)))
+
 //go:embed embedded_assets/defaultHTML.html
 var defaultHTMLTemplate string
 
 var defaultHTMLGenerator = template.Must(template.New("defaultHTML").Parse(defaultHTMLTemplate))
 
-//JSError provides a JS runtime agnostic error
-//type JSError = js.JSError
+// JSError is an error thrown while rendering a view, with its JS stack trace
+type JSError = js.JSError
+
+// JSStackFrame is a call in the stack of a JSError
+type JSStackFrame = js.StackFrame
 
 func NewAviator(configs ...Option) *Aviator {
 	a := &Aviator{
@@ -38,10 +48,14 @@ func NewAviator(configs ...Option) *Aviator {
 
 // configCheck checks to see if the provided configs are sufficient to start
 func (a *Aviator) configCheck() error {
-	if len(a.viewsPath) == 0 {
+	if len(a.viewsPath) == 0 && a.embeddedApp == nil && a.viewsFS == nil {
 		return errors.New("svelte views directory path not specified")
 	}
 
+	if !a.propsKeyCase.IsValid() {
+		return fmt.Errorf("unknown props key case %q", a.propsKeyCase)
+	}
+
 	return nil
 }
 
@@ -55,12 +69,28 @@ func (a *Aviator) Init() error {
 		return err
 	}
 
-	a.vm, err = js.NewGojaVMPool(a.numVMs)
+	a.vm, err = js.NewVMPool(a.jsEngine, a.numVMs, js.VMOptions{
+		Fetch:          a.fetch,
+		Polyfills:      a.polyfills,
+		Logger:         a.logger,
+		AcquireTimeout: a.vmAcquireTimeout,
+	})
 	//some vm instance initializations might have succeeded. Clean up if possible
 	if err != nil {
 		return err
 	}
 
+	for name, fn := range a.ssrBridges {
+		err = a.vm.SetGlobal(name, fn)
+		if err != nil {
+			return fmt.Errorf("failed to register SSR bridge %s: %w", name, err)
+		}
+	}
+
+	if a.embeddedApp != nil {
+		return a.initEmbeddedApp()
+	}
+
 	err = a.vm.InitializationScript(
 		"svelte_compiler_init.js",
 		svelteCompilerCode,
@@ -69,36 +99,152 @@ func (a *Aviator) Init() error {
 		return err
 	}
 
-	a.componentTree, err = builder.CreateComponentTree(a.viewsPath)
-	if err != nil {
-		return err
+	if a.viewsFS != nil && len(a.viewsPath) == 0 {
+		a.viewsPath = "."
 	}
 
-	a.viewManager, err = builder.NewViewManager(
-		a.logger,
-		a.vm,
-		a.componentTree,
-		a.htmlGenerator,
-		a.isDevMode,
-		a.cacheDir,
+	a.componentTree, err = builder.CreateComponentTree(
 		a.viewsPath,
-		a.staticAssetRoute,
-		a.htmlLang,
+		builder.WithMaxDepth(a.maxScanDepth),
+		builder.WithFS(a.viewsFS),
+		builder.WithIgnore(a.ignorePatterns...),
 	)
 	if err != nil {
 		return err
 	}
 
-	err = a.viewManager.StartWatch()
+	for _, warning := range a.componentTree.Warnings() {
+		a.logger.Error(warning)
+	}
+
+	a.viewManager, err = builder.NewViewManager(a.viewManagerConfig())
 	if err != nil {
 		return err
 	}
 
-	a.isInitialized = true
+	//views are built once and never change in production
+	if a.isDevMode {
+		err = a.viewManager.StartWatch()
+		if err != nil {
+			return err
+		}
+	}
+
+	a.setInitialized(true)
 
 	return nil
 }
 
+// initEmbeddedApp loads an app written by Export. Nothing is scanned, compiled
+// or watched
+func (a *Aviator) initEmbeddedApp() error {
+	var err error
+	a.viewManager, err = builder.NewViewManagerFromExport(a.viewManagerConfig(), a.embeddedApp)
+	if err != nil {
+		return err
+	}
+
+	a.setInitialized(true)
+
+	return nil
+}
+
+func (a *Aviator) viewManagerConfig() builder.ViewManagerConfig {
+	return builder.ViewManagerConfig{
+		Logger:               a.logger,
+		VM:                   a.vm,
+		Tree:                 a.componentTree,
+		HTMLGenerator:        a.htmlGenerator,
+		IsDevMode:            a.isDevMode,
+		CacheDir:             a.cacheDir,
+		CacheNamespace:       a.cacheNamespace,
+		CacheMode:            a.cacheMode,
+		CacheBackend:         a.cacheBackend,
+		CompilerHash:         svelteCompilerHash,
+		OutputPath:           a.outputPath,
+		ViewsDir:             a.viewsPath,
+		StaticAssetsRoute:    a.staticAssetRoute,
+		JSRoute:              a.jsRoute,
+		CSSRoute:             a.cssRoute,
+		HTMLLang:             a.htmlLang,
+		ServiceWorkerScope:   a.serviceWorkerScope,
+		UseImportMap:         a.useImportMap,
+		CacheKeyHash:         a.cacheKeyHash,
+		PropsEncoder:         a.propsEncoder,
+		PropsKeyCase:         a.propsKeyCase,
+		PropsKeyCaseNested:   a.propsKeyCaseNested,
+		TempFilePatterns:     a.tempFilePatterns,
+		InitBuildRetries:     a.initBuildRetries,
+		RenderableViews:      a.renderableViews,
+		CSSMedia:             a.cssMedia,
+		ViewConcurrency:      a.viewConcurrency,
+		CacheVary:            a.cacheVary,
+		SkipNodeModulesCache: a.skipNodeModulesCache,
+		StaticAssets:         a.staticAssets,
+		HTMLTemplateFile:     a.htmlTemplateFile,
+		ViewsFS:              a.viewsFS,
+		PrettyHTML:           a.prettyHTML,
+		CompactPropsScript:   a.compactPropsScript,
+		OmitNilPropsScript:   a.omitNilPropsScript,
+		IsolateLayoutProps:   a.isolateLayoutProps,
+		ExplicitEntrypoints:  a.explicitEntrypoints,
+		Entrypoints:          a.entrypoints,
+		DeterministicSSR:     a.deterministicSSR,
+		SSRSeed:              a.ssrSeed,
+		BuildOptions: builder.BuildOptions{
+			PropsReviver:       a.propsReviver,
+			StoreInitializer:   a.storeInitializer,
+			ExternalResolver:   a.externalResolver,
+			Hydratable:         a.hydratable,
+			BuildInfo:          a.buildInfo,
+			Immutable:          a.immutable,
+			FailOnA11yWarnings: a.failOnA11yWarnings,
+			AssetLayout:        a.assetLayout,
+			StylePreprocessor:  a.stylePreprocessor,
+			ImportAliases:      a.importAliases,
+		},
+	}
+}
+
+// setInitialized records whether the instance is ready to render
+func (a *Aviator) setInitialized(isInitialized bool) {
+	a.viewLock.Lock()
+	a.isInitialized = isInitialized
+	a.viewLock.Unlock()
+}
+
+// initialized reports whether the instance is ready to render
+func (a *Aviator) initialized() bool {
+	a.viewLock.RLock()
+	defer a.viewLock.RUnlock()
+
+	return a.isInitialized
+}
+
+// Close stops watching the views for changes and closes the JS VMs, waiting for
+// renders in progress to finish. The instance can't render until it's
+// initialized again
+func (a *Aviator) Close() error {
+	a.setInitialized(false)
+
+	var err error
+	if a.viewManager != nil {
+		err = a.viewManager.Close()
+	}
+
+	if closer, ok := a.vm.(interface{ Close() }); ok {
+		closer.Close()
+	}
+
+	return err
+}
+
+// Export writes the built app to dir so it can be embedded and loaded with
+// WithEmbeddedApp, without needing to compile or bundle anything at runtime
+func (a *Aviator) Export(dir string) error {
+	return a.viewManager.Export(dir)
+}
+
 type ssrData struct {
 	Head    string
 	Body    string
@@ -111,12 +257,281 @@ type ssrData struct {
 	Lang string
 }
 
+// ErrViewNotRenderable is returned when rendering a view not allowed by
+// WithRenderableViews
+var ErrViewNotRenderable = builder.ErrViewNotRenderable
+
+// ErrViewBusy is returned when a render waiting for its view's concurrency limit
+// gives up because its context is done
+var ErrViewBusy = builder.ErrViewBusy
+
+// ErrInvalidRenderOutput is returned when the SSR runtime returns something that
+// isn't a rendered view, i.e. a value returned by a component instead
+var ErrInvalidRenderOutput = builder.ErrInvalidRenderOutput
+
+// ErrVMPoolExhausted is returned when every JS VM stayed busy for the timeout
+// set with WithVMAcquireTimeout
+var ErrVMPoolExhausted = js.ErrVMPoolExhausted
+
+// ErrReservedViewName is returned when a view or directory name starts with the
+// prefix of the virtual files generated by Aviator
+var ErrReservedViewName = builder.ErrReservedViewName
+
+// PropsKeyCase is the casing prop keys are transformed to when serialized
+type PropsKeyCase = builder.PropsKeyCase
+
+const (
+	PropsKeyCaseAsIs  = builder.PropsKeyCaseAsIs
+	PropsKeyCaseCamel = builder.PropsKeyCaseCamel
+	PropsKeyCaseSnake = builder.PropsKeyCaseSnake
+)
+
+// AssetLayout sets the directory structure of the built JS and CSS assets
+type AssetLayout = builder.AssetLayout
+
+const (
+	AssetLayoutFlat       = builder.AssetLayoutFlat
+	AssetLayoutViewDir    = builder.AssetLayoutViewDir
+	AssetLayoutHashPrefix = builder.AssetLayoutHashPrefix
+)
+
+// StylePreprocessor transforms the <style> blocks of components into CSS
+type StylePreprocessor = builder.StylePreprocessor
+
+// ESBuildStylePreprocessor returns the built-in style preprocessor, which lowers
+// plain CSS for the browsers, i.e. "chrome58"
+var ESBuildStylePreprocessor = builder.ESBuildStylePreprocessor
+
+// CacheMode sets where compiled components are cached
+type CacheMode = builder.CacheMode
+
+const (
+	//CacheModeDisk persists the caches to the cache directory
+	CacheModeDisk = builder.CacheModeDisk
+	//CacheModeMemory keeps the caches in memory only
+	CacheModeMemory = builder.CacheModeMemory
+	//CacheModeDisabled compiles every component on every build
+	CacheModeDisabled = builder.CacheModeDisabled
+)
+
+// RenderOption configures a single call to Render
+type RenderOption = builder.RenderOption
+
+// OmitPropsScript renders the page without the __aviator_props script
+func OmitPropsScript() RenderOption {
+	return builder.OmitPropsScript()
+}
+
+// Render renders the view into an HTML page. Pass the HTTP request's context,
+// i.e. r.Context(), so the render is skipped when the request is cancelled
+// before it starts, and stopped when it's cancelled while the render is running
 func (a *Aviator) Render(
 	ctx context.Context,
 	viewPath string,
 	props interface{},
+	opts ...RenderOption,
 ) (string, error) {
-	return a.viewManager.Render(ctx, viewPath, props)
+	return a.viewManager.Render(ctx, viewPath, props, opts...)
+}
+
+// ClientProps sets the props shipped to the client for hydration. The props
+// passed to Render are then only used for SSR
+func ClientProps(props interface{}) RenderOption {
+	return builder.ClientProps(props)
+}
+
+// LayoutProps sets the props the layouts of the view are rendered with, instead
+// of the props of the page
+func LayoutProps(props interface{}) RenderOption {
+	return builder.LayoutProps(props)
+}
+
+// Locale renders the view with the locale specific variants of its assets when
+// they exist, i.e. Index.svelte.fr.js instead of Index.svelte.js for "fr"
+func Locale(locale string) RenderOption {
+	return builder.Locale(locale)
+}
+
+// RenderContext sets values available to components through svelte's
+// getContext and to the store initializer
+func RenderContext(values map[string]interface{}) RenderOption {
+	return builder.RenderContext(values)
+}
+
+// RenderResult is the rendered page along with the status, redirect and headers
+// the component requested
+type RenderResult = builder.RenderResult
+
+// RenderMeta is the status, redirect and headers a component requested
+type RenderMeta = builder.RenderMeta
+
+// JSONRender is the JSON RenderJSON returns
+type JSONRender = builder.JSONRender
+
+// CacheBackend stores compiled components shared between machines
+type CacheBackend = builder.CacheBackend
+
+// JSEngine is the JS engine server side rendering runs on
+type JSEngine = js.Engine
+
+const (
+	//JSEngineGoja is the default pure Go engine
+	JSEngineGoja = js.EngineGoja
+	//JSEngineV8 needs CGO and building with the v8 build tag
+	JSEngineV8 = js.EngineV8
+)
+
+// GojaPolyfill installs globals on a goja runtime, i.e. browser APIs that
+// components or libraries expect to exist during SSR
+type GojaPolyfill = js.GojaPolyfill
+
+// ErrorProps are the props a +error.svelte component is rendered with
+type ErrorProps = builder.ErrorProps
+
+// ComponentMeta is the per-view configuration read from a component's sibling
+// .meta.json file, i.e. Foo.meta.json for Foo.svelte
+type ComponentMeta = builder.ComponentMeta
+
+// PropInfo describes a prop a component declares
+type PropInfo = builder.PropInfo
+
+// RenderTo renders the view like Render, writing the HTML page directly to w,
+// i.e. an http.ResponseWriter
+func (a *Aviator) RenderTo(
+	ctx context.Context,
+	w io.Writer,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) error {
+	return a.viewManager.RenderTo(ctx, w, viewPath, props, opts...)
+}
+
+// RenderCacheKey returns a key identifying the output of rendering the view with
+// props and opts, for caching rendered pages. Render context values are only
+// part of it when they're declared with WithCacheVary for the view
+func (a *Aviator) RenderCacheKey(viewPath string, props interface{}, opts ...RenderOption) (string, error) {
+	return a.viewManager.RenderCacheKey(viewPath, props, opts...)
+}
+
+// RenderView renders the view like Render, but also returns the status, redirect
+// and headers the component set through the "__aviator_meta" svelte context
+func (a *Aviator) RenderView(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) (*RenderResult, error) {
+	return a.viewManager.RenderView(ctx, viewPath, props, opts...)
+}
+
+// RenderFirst renders the first of the candidate views that exists, in order
+func (a *Aviator) RenderFirst(
+	ctx context.Context,
+	candidates []string,
+	props interface{},
+	opts ...RenderOption,
+) (string, error) {
+	return a.viewManager.RenderFirst(ctx, candidates, props, opts...)
+}
+
+// RenderAll renders every entrypoint view with the props propsProvider returns
+// for it and returns the HTML of each one by view path. Useful for comparing
+// all pages against golden files in tests
+func (a *Aviator) RenderAll(propsProvider func(view string) interface{}) (map[string]string, error) {
+	return a.viewManager.RenderAll(context.Background(), propsProvider)
+}
+
+// RenderWithTags renders the view without assembling the HTML document. It returns
+// the body, the tags to place in <head> and the props script separately
+func (a *Aviator) RenderWithTags(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) (body, headTags, propsScript string, err error) {
+	return a.viewManager.RenderWithTags(ctx, viewPath, props, opts...)
+}
+
+// RenderJSON renders the view and returns its head, body, assets and props as
+// JSON instead of an HTML document, for frontends that assemble the page
+func (a *Aviator) RenderJSON(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) ([]byte, error) {
+	return a.viewManager.RenderJSON(ctx, viewPath, props, opts...)
+}
+
+// PropsScriptFor returns the props payload of the view's __aviator_props script,
+// for serving to a client that re-renders with new props. Pass the LayoutProps
+// the page was rendered with to keep its layouts' props
+func (a *Aviator) PropsScriptFor(viewPath string, props interface{}, opts ...RenderOption) (string, error) {
+	return a.viewManager.PropsScriptFor(viewPath, props, opts...)
+}
+
+// RenderStreamList renders the body of the view for every props received from
+// propsChan and writes each one to w as soon as it's rendered, flushing w if
+// possible. It stops when propsChan is closed or ctx is done
+func (a *Aviator) RenderStreamList(
+	ctx context.Context,
+	w io.Writer,
+	viewPath string,
+	propsChan <-chan interface{},
+) error {
+	return a.viewManager.RenderStreamList(ctx, w, viewPath, propsChan)
+}
+
+// RegisterEntrypoint makes the views at the paths, relative to the views
+// directory, entrypoints when WithExplicitEntrypoints is enabled. Views
+// registered after Init become entrypoints on the next build
+func (a *Aviator) RegisterEntrypoint(relPaths ...string) {
+	a.entrypoints = append(a.entrypoints, relPaths...)
+	if a.viewManager != nil {
+		a.viewManager.RegisterEntrypoint(relPaths...)
+	}
+}
+
+// FindViews returns the paths, relative to the views directory, of all views
+// named shortName, i.e. "Card" or "Card.svelte". Useful for finding out which
+// path to render when several components share a name
+func (a *Aviator) FindViews(shortName string) []string {
+	return a.viewManager.FindViews(shortName)
+}
+
+// PersistAssets writes the built static assets to the path set with
+// WithAssetOutputPath, removing files left over from a previous build. It
+// returns the path of each written file relative to the output path by asset name
+func (a *Aviator) PersistAssets() (map[string]string, error) {
+	return a.viewManager.PersistAssets()
+}
+
+// ComponentProps returns the props the view declares with `export let`, i.e. for
+// generating Go structs matching them
+func (a *Aviator) ComponentProps(viewPath string) ([]PropInfo, error) {
+	return a.viewManager.ComponentProps(viewPath)
+}
+
+// SvelteBuildOutput is the output of compiling a single svelte component
+type SvelteBuildOutput = builder.SvelteBuildOutput
+
+// CompileView compiles the svelte component of the view on its own for target,
+// "ssr" or "dom", i.e. for tooling that needs the output of a specific target
+func (a *Aviator) CompileView(viewPath, target string) (*SvelteBuildOutput, error) {
+	return a.viewManager.CompileView(viewPath, target)
+}
+
+// UnusedCSS renders the view and returns the CSS selectors of its stylesheets
+// that reference classes or ids missing from the rendered HTML. It's a
+// development diagnostic for trimming stylesheets
+func (a *Aviator) UnusedCSS(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) ([]string, error) {
+	return a.viewManager.UnusedCSS(ctx, viewPath, props, opts...)
 }
 
 // GetStaticAsset returns a byte array contents of the static asset and a boolean
@@ -126,3 +541,9 @@ func (a *Aviator) GetStaticAsset(name string) ([]byte, string, bool) {
 
 	return staticAsset.Content, staticAsset.MimeType, found
 }
+
+// StaticAssetNames returns the sorted names of all static assets that can be
+// served, i.e. for preloading them or checking expected assets were built
+func (a *Aviator) StaticAssetNames() []string {
+	return a.viewManager.StaticAssetNames()
+}
@@ -0,0 +1,129 @@
+package aviator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mansoor-s/aviator/js"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAviator_Close(t *testing.T) {
+	a := NewAviator(
+		WithViewsPath(t.TempDir()),
+		WithDevMode(true),
+		WithNumJsVMs(1),
+		WithCacheDir(t.TempDir()),
+	)
+	assert.NoError(t, a.Init())
+	assert.True(t, a.initialized())
+
+	assert.NoError(t, a.Close())
+	assert.False(t, a.initialized())
+
+	//the VMs are gone
+	_, err := a.vm.Eval(context.Background(), "closed.js", "1")
+	assert.Error(t, err)
+}
+
+func TestAviator_CacheDir(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "tenants", "a")
+	a := NewAviator(
+		WithViewsPath(t.TempDir()),
+		WithNumJsVMs(1),
+		WithCacheDir(cacheDir),
+	)
+	assert.NoError(t, a.Init())
+	defer a.Close()
+
+	//created when missing
+	assert.DirExists(t, filepath.Join(cacheDir, "ssr"))
+	assert.DirExists(t, filepath.Join(cacheDir, "browser"))
+}
+
+func TestAviator_JSEngine(t *testing.T) {
+	a := NewAviator(WithViewsPath(t.TempDir()), WithNumJsVMs(1), WithJSEngine("spidermonkey"))
+	assert.ErrorContains(t, a.Init(), "spidermonkey")
+
+	//the default build doesn't include V8
+	a = NewAviator(WithViewsPath(t.TempDir()), WithNumJsVMs(1), WithJSEngine(JSEngineV8))
+	assert.ErrorIs(t, a.Init(), js.ErrV8Unavailable)
+}
+
+func TestAviator_Render_Deadline(t *testing.T) {
+	app := fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"Views": [{"RelPath": "Index.svelte", "UniqueName": "Index", "WrappedUniqueName": "__AviatorWrapped_Index", "IsEntrypoint": true}],
+			"Assets": []
+		}`)},
+		"ssr.js": {Data: []byte(`var __aviator__ = {
+			render: function (name, props) {
+				while (props.runaway) {}
+				return JSON.stringify({ head: "", body: "<h1>Done</h1>" })
+			}
+		}`)},
+	}
+
+	a := NewAviator(WithEmbeddedApp(app), WithNumJsVMs(1))
+	assert.NoError(t, a.Init())
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := a.Render(ctx, "Index.svelte", map[string]bool{"runaway": true})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	//the interrupted VM is usable again
+	out, err := a.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<h1>Done</h1>")
+
+	//a render that's already cancelled doesn't wait for a VM
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.Render(cancelled, "Index.svelte", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAviator_SSRBridge(t *testing.T) {
+	app := fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"Views": [{"RelPath": "Index.svelte", "UniqueName": "Index", "WrappedUniqueName": "__AviatorWrapped_Index", "IsEntrypoint": true}],
+			"Assets": []
+		}`)},
+		"ssr.js": {Data: []byte(`var __aviator__ = {
+			render: function (name, props) {
+				return JSON.stringify({ head: "", body: "<h1>" + greet(props.name, 2) + "</h1>" })
+			}
+		}`)},
+	}
+
+	a := NewAviator(
+		WithEmbeddedApp(app),
+		WithNumJsVMs(2),
+		WithSSRBridge("greet", func(args ...interface{}) (interface{}, error) {
+			name, _ := args[0].(string)
+			if len(name) == 0 {
+				return nil, errors.New("greet needs a name")
+			}
+			return strings.Repeat("hello "+name+" ", int(args[1].(int64))), nil
+		}),
+	)
+	assert.NoError(t, a.Init())
+
+	//the bridge is set on every VM of the pool
+	for i := 0; i < 4; i++ {
+		out, err := a.Render(context.Background(), "Index.svelte", map[string]string{"name": "gopher"})
+		assert.NoError(t, err)
+		assert.Contains(t, out, "<h1>hello gopher hello gopher </h1>")
+	}
+
+	_, err := a.Render(context.Background(), "Index.svelte", map[string]string{"name": ""})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "greet needs a name")
+}
@@ -2,11 +2,17 @@ package builder
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 	"text/template"
 
@@ -33,6 +39,9 @@ type BrowserBuilder struct {
 	logger utils.Logger
 
 	workingDir string
+	//files reads the views from workingDir
+	files   viewsFS
+	options BuildOptions
 }
 
 func NewBrowserBuilder(
@@ -40,15 +49,43 @@ func NewBrowserBuilder(
 	vm js.VM,
 	cache Cache,
 	workingDir string,
+	fsys fs.FS,
+	options BuildOptions,
 ) *BrowserBuilder {
 	return &BrowserBuilder{
 		logger:     logger,
 		vm:         vm,
 		workingDir: workingDir,
+		files:      viewsFS{root: workingDir, fsys: fsys},
 		cache:      cache,
+		options:    options,
 	}
 }
 
+// browserTemplateData is the data browserHelperTemplate.gotext is executed with
+type browserTemplateData struct {
+	*View
+
+	PropsReviver        string
+	HasStoreInitializer bool
+
+	//Hydratable is false when the view is mounted without hydrating the SSR output
+	Hydratable bool
+}
+
+// isHydratable reports whether the component at relPath is compiled with
+// hydration support
+func (b *BrowserBuilder) isHydratable(relPath string) bool {
+	hydratable := true
+	for _, rule := range b.options.Hydratable {
+		if matched, _ := path.Match(rule.Glob, filepath.ToSlash(relPath)); matched {
+			hydratable = rule.Hydratable
+		}
+	}
+
+	return hydratable
+}
+
 // The entrypoints are the virtual files created for all Components in the
 // browserRuntimePlugin func. This plugin will reference those virtual files
 // and will bundle and persist the outputs
@@ -94,26 +131,38 @@ func (b *BrowserBuilder) BuildDev(allViews []*View) (map[string]StaticAsset, err
 		MinifySyntax:      true,
 		LegalComments:     esbuild.LegalCommentsNone,
 		Sourcemap:         esbuild.SourceMapInline,
+		Define:            buildInfoDefines(b.options.BuildInfo),
 		LogLevel:          esbuild.LogLevelInfo,
 		Plugins: []esbuild.Plugin{
 			b.browserRuntimePlugin(viewsByEntryPoint),
+			//must be registered before the resolvers of the aliased imports
+			importAliasPlugin(b.workingDir, b.options.ImportAliases),
 			wrappedComponentsPlugin(b.cache, b.workingDir, allViews, b.browserCompile),
-			svelteComponentsPlugin(b.cache, b.workingDir, cssCache, b.browserCompile),
-			npmJsPathPlugin(b.workingDir),
+			svelteComponentsPlugin(b.cache, b.workingDir, b.files, cssCache, b.options.StylePreprocessor, b.browserCompile),
+			//must be registered before js_path, which resolves every .js import
+			storeInitializerPlugin(b.workingDir, b.options.StoreInitializer),
+			externalResolverPlugin(b.options.ExternalResolver, false),
+			npmJsPathPlugin(b.workingDir, b.files),
 		},
 		Write: false,
 	})
 	if len(result.Errors) > 0 {
-		msgs := esbuild.FormatMessages(result.Errors, esbuild.FormatMessagesOptions{
-			Color:         true,
-			Kind:          esbuild.ErrorMessage,
-			TerminalWidth: 80,
-		})
-		return nil, fmt.Errorf(strings.Join(msgs, "\n"))
+		return nil, newBuildError(result.Errors)
 	}
 
 	b.cache.Finished()
 
+	return b.outputAssets(allViews, viewsByOutputName, result.OutputFiles), nil
+}
+
+// outputAssets sets the JS and CSS imports of the views and returns the built
+// files by asset name. The JS of static only views is left out, they are never
+// hydrated
+func (b *BrowserBuilder) outputAssets(
+	allViews []*View,
+	viewsByOutputName map[string]*View,
+	outputFiles []esbuild.OutputFile,
+) map[string]StaticAsset {
 	staticContent := map[string]StaticAsset{}
 
 	for _, view := range allViews {
@@ -121,29 +170,126 @@ func (b *BrowserBuilder) BuildDev(allViews []*View) (map[string]StaticAsset, err
 		view.CSSImports = []string{}
 	}
 
-	for _, file := range result.OutputFiles {
+	for _, file := range outputFiles {
 		fileName := filepath.Base(file.Path)
 		extension := utils.FileExtension(fileName)
 		viewRefName := fileName[:len(fileName)-len(extension)-1]
 
 		view := viewsByOutputName[viewRefName]
+		name := b.assetName(view, fileName)
 
 		if extension == "js" {
-			view.JSImports = append(view.JSImports, fileName)
-			staticContent[fileName] = StaticAsset{
+			if view.StaticOnly {
+				continue
+			}
+			view.JSImports = append(view.JSImports, name)
+			staticContent[name] = StaticAsset{
 				Content:  file.Contents,
 				MimeType: "text/javascript",
 			}
 		} else if extension == "css" {
-			view.CSSImports = append(view.CSSImports, fileName)
-			staticContent[fileName] = StaticAsset{
+			view.CSSImports = append(view.CSSImports, name)
+			staticContent[name] = StaticAsset{
 				Content:  file.Contents,
 				MimeType: "text/css",
 			}
 		}
 	}
 
-	return staticContent, nil
+	return staticContent
+}
+
+// AssetLayout sets the directory structure of the built JS and CSS assets. Asset
+// names, and so their URLs, include the directories
+type AssetLayout int
+
+const (
+	//AssetLayoutFlat names the assets of every view without directories
+	AssetLayoutFlat AssetLayout = iota
+	//AssetLayoutViewDir places the assets of a view in the directory of the view
+	//relative to the views directory, i.e. users/UsersList.svelte.js
+	AssetLayoutViewDir
+	//AssetLayoutHashPrefix places the assets of a view in a directory named by
+	//the first 2 characters of a hash of its path, spreading them over at most
+	//256 directories
+	AssetLayoutHashPrefix
+)
+
+// assetName returns the name of the asset fileName of view for the layout
+func (b *BrowserBuilder) assetName(view *View, fileName string) string {
+	switch b.options.AssetLayout {
+	case AssetLayoutViewDir:
+		return path.Join(path.Dir(filepath.ToSlash(view.RelPath)), fileName)
+	case AssetLayoutHashPrefix:
+		hash := sha256.Sum256([]byte(view.RelPath))
+		return path.Join(hex.EncodeToString(hash[:1]), fileName)
+	}
+
+	return fileName
+}
+
+// assetManifestName is the file PersistAssets records the files it wrote in
+const assetManifestName = "aviator-assets.json"
+
+// PersistAssets writes every asset to outputPath, creating it if missing, and
+// returns the path of each written file relative to outputPath by asset name.
+// Asset names containing slashes are written to subdirectories. Files written
+// by a previous call that are no longer part of assets are removed
+func (b *BrowserBuilder) PersistAssets(
+	outputPath string,
+	assets map[string]StaticAsset,
+) (map[string]string, error) {
+	err := os.MkdirAll(outputPath, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath := filepath.Join(outputPath, assetManifestName)
+	previousManifest := map[string]string{}
+	manifestJSON, err := os.ReadFile(manifestPath)
+	if err == nil {
+		//a corrupt manifest only means stale files are left behind
+		_ = json.Unmarshal(manifestJSON, &previousManifest)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	manifest := make(map[string]string, len(assets))
+	for name, asset := range assets {
+		assetPath := filepath.Join(outputPath, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(assetPath), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.WriteFile(assetPath, asset.Content, 0644)
+		if err != nil {
+			return nil, err
+		}
+		manifest[name] = path.Clean(name)
+	}
+
+	for name, fileName := range previousManifest {
+		if _, ok := manifest[name]; ok {
+			continue
+		}
+		err := os.Remove(filepath.Join(outputPath, filepath.FromSlash(fileName)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	manifestJSON, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(manifestPath, manifestJSON, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
 }
 
 //go:embed browserHelperTemplate.gotext
@@ -181,7 +327,15 @@ func (b *BrowserBuilder) browserRuntimePlugin(viewsByEntryPoint map[string]*View
 					view := viewsByEntryPoint[args.Path]
 
 					buf := bytes.Buffer{}
-					err = browserGenerator.Execute(&buf, view)
+					err = browserGenerator.Execute(&buf, browserTemplateData{
+						View:                view,
+						PropsReviver:        b.options.PropsReviver,
+						HasStoreInitializer: len(b.options.StoreInitializer) > 0,
+						Hydratable:          b.isHydratable(view.RelPath),
+					})
+					if err != nil {
+						return result, err
+					}
 
 					contents := buf.String()
 
@@ -196,16 +350,29 @@ func (b *BrowserBuilder) browserRuntimePlugin(viewsByEntryPoint map[string]*View
 
 }
 
-func (b *BrowserBuilder) browserCompile(path string, code []byte) (*SvelteBuildOutput, error) {
+// browserCompileFormat is the compiler call of the browser build
+const browserCompileFormat = `;__svelte__.compile({ "Path": %q, "code": %q, "target": "dom", "dev": %t, "css": false, "enableSourcemap": %t, "isHydratable": %t, "immutable": %t, "warnings": %t, "sourcemap": %s })`
+
+func (b *BrowserBuilder) browserCompile(path string, relPath string, code []byte) (*SvelteBuildOutput, error) {
+	code, sourceMap, err := preprocessTypeScript(relPath, code)
+	if err != nil {
+		return nil, err
+	}
+
 	expr := fmt.Sprintf(
-		`;__svelte__.compile({ "Path": %q, "code": %q, "target": "dom", "dev": %t, "css": false, "enableSourcemap": %t, "isHydratable": %t })`,
+		browserCompileFormat,
 		path,
 		code,
 		false,
 		false,
-		true,
+		b.isHydratable(relPath),
+		b.options.Immutable,
+		//warnings are collected from the SSR build, the same components compile
+		//to the same warnings here
+		b.options.FailOnA11yWarnings,
+		compilerSourceMap(sourceMap),
 	)
-	result, err := b.vm.Eval(path, expr)
+	result, err := b.vm.Eval(context.Background(), path, expr)
 	if err != nil {
 		return nil, err
 	}
@@ -214,5 +381,15 @@ func (b *BrowserBuilder) browserCompile(path string, code []byte) (*SvelteBuildO
 		return nil, err
 	}
 
+	if b.options.FailOnA11yWarnings {
+		for i := range out.Warnings {
+			out.Warnings[i].Filename = relPath
+		}
+		err = a11yWarningsError(out.Warnings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return out, nil
 }
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrowserBuilder_Hydratable(t *testing.T) {
+	var compileExpr string
+	vm := &fakeVM{
+		evalFn: func(_, expression string) (string, error) {
+			compileExpr = expression
+			return `{}`, nil
+		},
+	}
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+	b := NewBrowserBuilder(nopLogger{}, vm, cache, "/views", nil, BuildOptions{
+		Hydratable: []HydratableRule{
+			{Glob: "static/*", Hydratable: false},
+			{Glob: "static/Live.svelte", Hydratable: true},
+		},
+	})
+
+	_, err = b.browserCompile("StaticAbout.svelte", "static/About.svelte", []byte("<h1>About</h1>"))
+	assert.NoError(t, err)
+	assert.Contains(t, compileExpr, `"isHydratable": false`)
+
+	_, err = b.browserCompile("StaticLive.svelte", "static/Live.svelte", []byte("<h1>Live</h1>"))
+	assert.NoError(t, err)
+	assert.Contains(t, compileExpr, `"isHydratable": true`)
+
+	_, err = b.browserCompile("Index.svelte", "Index.svelte", []byte("<h1>Index</h1>"))
+	assert.NoError(t, err)
+	assert.Contains(t, compileExpr, `"isHydratable": true`)
+
+	//views that aren't hydratable are mounted without hydrating
+	buf := bytes.Buffer{}
+	err = browserGenerator.Execute(&buf, browserTemplateData{
+		View:       &View{WrappedUniqueName: "__AviatorWrapped_StaticAbout", RelPath: "static/About.svelte"},
+		Hydratable: b.isHydratable("static/About.svelte"),
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "document.getElementById(\"__aviator_root\"),\n    false,")
+}
+
+func TestBrowserBuilder_PersistAssets(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "public")
+	unrelatedFile := filepath.Join(outputPath, "robots.txt")
+	b := &BrowserBuilder{}
+
+	manifest, err := b.PersistAssets(outputPath, map[string]StaticAsset{
+		"Index.svelte.js":   {Content: []byte(`"index v1"`)},
+		"About.svelte.css":  {Content: []byte(`h1{}`)},
+		"fonts/Inter.woff2": {Content: []byte(`font`)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"Index.svelte.js":   "Index.svelte.js",
+		"About.svelte.css":  "About.svelte.css",
+		"fonts/Inter.woff2": "fonts/Inter.woff2",
+	}, manifest)
+	assert.FileExists(t, filepath.Join(outputPath, "fonts", "Inter.woff2"))
+	assert.NoError(t, os.WriteFile(unrelatedFile, []byte("User-agent: *"), 0644))
+
+	//the next build drops About, so its stylesheet is removed
+	manifest, err = b.PersistAssets(outputPath, map[string]StaticAsset{
+		"Index.svelte.js":   {Content: []byte(`"index v2"`)},
+		"fonts/Inter.woff2": {Content: []byte(`font`)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, manifest, 2)
+
+	content, err := os.ReadFile(filepath.Join(outputPath, "Index.svelte.js"))
+	assert.NoError(t, err)
+	assert.Equal(t, `"index v2"`, string(content))
+	assert.NoFileExists(t, filepath.Join(outputPath, "About.svelte.css"))
+	assert.FileExists(t, unrelatedFile)
+}
+
+func TestBrowserBuilder_AssetLayout(t *testing.T) {
+	view := &View{RelPath: "users/UsersList.svelte", UniqueName: "UsersUsersList"}
+	fileName := "UsersUsersList.svelte.js"
+
+	flat := NewBrowserBuilder(nopLogger{}, nil, nil, "", nil, BuildOptions{})
+	assert.Equal(t, fileName, flat.assetName(view, fileName))
+
+	viewDir := NewBrowserBuilder(nopLogger{}, nil, nil, "", nil, BuildOptions{AssetLayout: AssetLayoutViewDir})
+	assert.Equal(t, "users/"+fileName, viewDir.assetName(view, fileName))
+	assert.Equal(t, "Index.svelte.js", viewDir.assetName(&View{RelPath: "Index.svelte"}, "Index.svelte.js"))
+
+	hashPrefix := NewBrowserBuilder(nopLogger{}, nil, nil, "", nil, BuildOptions{AssetLayout: AssetLayoutHashPrefix})
+	name := hashPrefix.assetName(view, fileName)
+	assert.Regexp(t, `^[0-9a-f]{2}/`+fileName+`$`, name)
+	assert.Equal(t, name, hashPrefix.assetName(view, fileName))
+
+	//pages link to the namespaced assets
+	v, indexView := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Users</h1>"}`))
+	indexView.JSImports = []string{viewDir.assetName(view, fileName)}
+	indexView.CSSImports = []string{viewDir.assetName(view, "UsersUsersList.svelte.css")}
+	v.cacheStaticHeadTags()
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, out, `src="/static/users/UsersUsersList.svelte.js"`)
+	assert.Contains(t, out, `href="/static/users/UsersUsersList.svelte.css"`)
+}
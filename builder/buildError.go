@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/mansoor-s/aviator/utils"
+)
+
+// initBuildBackoff is the delay before the first retry of the initial build.
+// It doubles after every attempt
+const initBuildBackoff = 100 * time.Millisecond
+
+// buildError is an esbuild failure. It unwraps to the first error thrown by a
+// plugin so callers can tell file access errors apart from compile errors
+type buildError struct {
+	msg string
+	err error
+}
+
+func (e *buildError) Error() string {
+	return e.msg
+}
+
+func (e *buildError) Unwrap() error {
+	return e.err
+}
+
+func newBuildError(messages []esbuild.Message) error {
+	msgs := esbuild.FormatMessages(messages, esbuild.FormatMessagesOptions{
+		Color:         true,
+		Kind:          esbuild.ErrorMessage,
+		TerminalWidth: 80,
+	})
+
+	var pluginErr error
+	for _, msg := range messages {
+		if err, ok := msg.Detail.(error); ok {
+			pluginErr = err
+			break
+		}
+	}
+
+	return &buildError{
+		msg: strings.Join(msgs, "\n"),
+		err: pluginErr,
+	}
+}
+
+// isTransientBuildError reports whether err was caused by a failed file access,
+// e.g. a file that was being written or moved while it was read. Compile errors
+// are not transient
+func isTransientBuildError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
+// retryBuild calls build until it succeeds, fails with a non transient error or
+// has been retried retries times. The delay between attempts starts at backoff
+// and doubles after every attempt
+func retryBuild(logger utils.Logger, retries int, backoff time.Duration, build func() error) error {
+	err := build()
+	for attempt := 0; attempt < retries && err != nil && isTransientBuildError(err); attempt++ {
+		logger.Info("retrying build after transient error: " + err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+
+		err = build()
+	}
+
+	return err
+}
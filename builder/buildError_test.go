@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBuild_Transient(t *testing.T) {
+	readErr := &fs.PathError{Op: "open", Path: "Index.svelte", Err: fs.ErrNotExist}
+
+	attempts := 0
+	err := retryBuild(nopLogger{}, 3, 0, func() error {
+		attempts++
+		if attempts < 3 {
+			return newBuildError([]esbuild.Message{{Text: readErr.Error(), Detail: readErr}})
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestRetryBuild_Permanent(t *testing.T) {
+	attempts := 0
+	err := retryBuild(nopLogger{}, 3, 0, func() error {
+		attempts++
+		return newBuildError([]esbuild.Message{{Text: "Unexpected token"}})
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unexpected token")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryBuild_RetriesExhausted(t *testing.T) {
+	readErr := &fs.PathError{Op: "open", Path: "Index.svelte", Err: fs.ErrNotExist}
+
+	attempts := 0
+	err := retryBuild(nopLogger{}, 2, 0, func() error {
+		attempts++
+		return newBuildError([]esbuild.Message{{Text: readErr.Error(), Detail: readErr}})
+	})
+
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	assert.Equal(t, 3, attempts)
+}
@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// buildInfoContextKey is the render context key the build info is available
+// under during SSR, i.e. getContext("__aviator_build_info").version
+const buildInfoContextKey = "__aviator_build_info"
+
+// buildInfoDefines returns the esbuild defines replacing __BUILD_{KEY}__ with
+// the value of key in info. i.e. "version" is available as __BUILD_VERSION__
+func buildInfoDefines(info map[string]string) map[string]string {
+	if len(info) == 0 {
+		return nil
+	}
+
+	defines := make(map[string]string, len(info))
+	for key, value := range info {
+		identifier := strings.Map(func(r rune) rune {
+			if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return '_'
+			}
+			return unicode.ToUpper(r)
+		}, key)
+
+		//values are inserted as JS string literals
+		literal, _ := json.Marshal(value)
+		defines["__BUILD_"+identifier+"__"] = string(literal)
+	}
+
+	return defines
+}
@@ -0,0 +1,27 @@
+package builder
+
+import (
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfoDefines(t *testing.T) {
+	defines := buildInfoDefines(map[string]string{
+		"version":    "1.2.0",
+		"git-commit": `a1b2"c3`,
+	})
+	assert.Equal(t, map[string]string{
+		"__BUILD_VERSION__":    `"1.2.0"`,
+		"__BUILD_GIT_COMMIT__": `"a1b2\"c3"`,
+	}, defines)
+
+	result := esbuild.Transform(`console.log(__BUILD_VERSION__, __BUILD_GIT_COMMIT__)`, esbuild.TransformOptions{
+		Define: defines,
+	})
+	assert.Empty(t, result.Errors)
+	assert.Contains(t, string(result.Code), `console.log("1.2.0", 'a1b2"c3')`)
+
+	assert.Nil(t, buildInfoDefines(nil))
+}
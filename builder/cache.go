@@ -1,10 +1,11 @@
 package builder
 
 import (
-	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -28,6 +29,9 @@ It will load cached assets from FS on application start in dev mode
 
 it will persist cached content to FS when a new build is done
 
+Processes sharing a cache directory take turns reading and writing it by
+locking a "lock" file in it
+
 
 FS cache will create two directories, 1 for SSR and 1 for Browser
 
@@ -51,6 +55,30 @@ const (
 	CacheTypeBrowser
 )
 
+// CacheMode sets where compiled components are cached
+type CacheMode int
+
+const (
+	//CacheModeDisk persists the caches to the cache directory so they survive
+	//restarts
+	CacheModeDisk CacheMode = iota
+	//CacheModeMemory keeps the caches in memory only. Nothing is written to disk
+	CacheModeMemory
+	//CacheModeDisabled compiles every component on every build
+	CacheModeDisabled
+)
+
+// cacheFileNameLength is the length of a hex encoded SHA256
+const cacheFileNameLength = sha256.Size * 2
+
+// cacheFingerprintFileName is the file in the cache directory holding the
+// fingerprint of the compiler and options the caches were created with
+const cacheFingerprintFileName = "fingerprint"
+
+// tempCacheFileExt is the extension of the files cache files are written to
+// before they're renamed into place
+const tempCacheFileExt = ".tmp"
+
 type cacheItem struct {
 	cacheType int
 
@@ -63,6 +91,8 @@ type cacheItem struct {
 
 	//path refers to the original absolute path of the file we're holding a cache for
 	path string
+	//files reads the file at path
+	files viewsFS
 
 	cacheFilePath    string
 	metadataFilePath string
@@ -84,8 +114,9 @@ type cacheItemMetadata struct {
 	PathContentHash string
 }
 
-func newEmptyCacheItem(cacheFilePath, metadataFilePath string) *cacheItem {
+func newEmptyCacheItem(files viewsFS, cacheFilePath, metadataFilePath string) *cacheItem {
 	c := &cacheItem{
+		files:            files,
 		dependents:       map[string]*cacheItem{},
 		cacheFilePath:    cacheFilePath,
 		metadataFilePath: metadataFilePath,
@@ -94,21 +125,25 @@ func newEmptyCacheItem(cacheFilePath, metadataFilePath string) *cacheItem {
 	return c
 }
 
-func newCacheItem(cacheDir, path string, content *string) *cacheItem {
+func newCacheItem(files viewsFS, cacheDir, path string, content *string) *cacheItem {
 	c := &cacheItem{
 		cacheDir:     cacheDir,
 		path:         path,
+		files:        files,
 		content:      content,
 		dependents:   map[string]*cacheItem{},
 		pendingWrite: true,
 	}
 
-	h := sha1.New()
+	h := sha256.New()
 	io.WriteString(h, *content)
-	c.cachedContentHash = hex.EncodeToString(h.Sum(nil))[:20]
+	c.cachedContentHash = hex.EncodeToString(h.Sum(nil))
 
-	c.cacheFilePath = filepath.Join(c.cacheDir, c.cachedContentHash+".cache")
-	c.metadataFilePath = filepath.Join(c.cacheDir, c.cachedContentHash+".metadata")
+	//items without a cacheDir are kept in memory only
+	if len(c.cacheDir) > 0 {
+		c.cacheFilePath = filepath.Join(c.cacheDir, c.cachedContentHash+".cache")
+		c.metadataFilePath = filepath.Join(c.cacheDir, c.cachedContentHash+".metadata")
+	}
 	c.pathContentHash = c.pathFileHash()
 
 	return c
@@ -121,13 +156,13 @@ func (c *cacheItem) IsValid() bool {
 }
 
 func (c *cacheItem) pathFileHash() string {
-	fileContent, err := os.ReadFile(c.path)
+	fileContent, err := c.files.ReadFile(c.path)
 	//silently return on error if the file is a "virtual" file
 	if err != nil {
 		return ""
 	}
 
-	h := sha1.New()
+	h := sha256.New()
 	h.Write(fileContent)
 
 	return hex.EncodeToString(h.Sum(nil))
@@ -147,7 +182,8 @@ func (c *cacheItem) readMetadataFile() error {
 	}
 
 	c.path = metadata.Path
-	c.pathContentHash = c.pathFileHash()
+	//the file may have changed while the cache wasn't in use, i.e. by npm install
+	c.pathContentHash = metadata.PathContentHash
 
 	for _, dependentPath := range metadata.Dependents {
 		// for this stage, just create the record. cacheManger will handle adding the
@@ -164,6 +200,13 @@ func (c *cacheItem) readCacheFile() error {
 		return err
 	}
 
+	//cache files are named by the hash of their content
+	hash := sha256.Sum256(fileContent)
+	name := strings.TrimSuffix(filepath.Base(c.cacheFilePath), filepath.Ext(c.cacheFilePath))
+	if hex.EncodeToString(hash[:]) != name {
+		return fmt.Errorf("cache file %s is corrupt", c.cacheFilePath)
+	}
+
 	contentStr := string(fileContent)
 	c.content = &contentStr
 
@@ -181,27 +224,10 @@ func (c *cacheItem) ReadFS() error {
 }
 
 func (c *cacheItem) writeCacheFile() error {
-	cacheF, err := os.Create(c.cacheFilePath)
-	if err != nil {
-		return err
-	}
-	defer cacheF.Close()
-
-	_, err = cacheF.WriteString(*c.content)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return writeFileAtomic(c.cacheFilePath, []byte(*c.content))
 }
 
 func (c *cacheItem) writeMetadataFile() error {
-	metaF, err := os.Create(c.metadataFilePath)
-	if err != nil {
-		return err
-	}
-	defer metaF.Close()
-
 	var dependents []string
 	for _, dep := range c.dependents {
 		dependents = append(dependents, dep.path)
@@ -217,8 +243,29 @@ func (c *cacheItem) writeMetadataFile() error {
 		return err
 	}
 
-	_, err = metaF.Write(metadataJson)
+	return writeFileAtomic(c.metadataFilePath, metadataJson)
+}
+
+// writeFileAtomic writes content to a temp file next to path and renames it into
+// place, so a crash mid-write never leaves a partially written file at path
+func writeFileAtomic(path string, content []byte) error {
+	tempF, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+tempCacheFileExt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tempF.Write(content)
+	if closeErr := tempF.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		_ = os.Remove(tempF.Name())
+		return err
+	}
+
+	err = os.Rename(tempF.Name(), path)
+	if err != nil {
+		_ = os.Remove(tempF.Name())
 		return err
 	}
 
@@ -250,17 +297,22 @@ func (c *cacheItem) PersistToFS() error {
 func (c *cacheItem) Invalidate() error {
 	c.markedForDeletion = true
 
-	err := os.Remove(c.cacheFilePath)
-	if err != nil {
-		return err
-	}
-	err = os.Remove(c.metadataFilePath)
-	if err != nil {
-		return err
+	if len(c.cacheFilePath) > 0 {
+		//another process sharing the cache directory may have removed them already
+		for _, path := range []string{c.cacheFilePath, c.metadataFilePath} {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
 	}
 
 	for _, dependent := range c.dependents {
-		err = dependent.Invalidate()
+		//dependents read from the FS that weren't cached anymore
+		if dependent == nil {
+			continue
+		}
+		err := dependent.Invalidate()
 		if err != nil {
 			return err
 		}
@@ -269,6 +321,21 @@ func (c *cacheItem) Invalidate() error {
 	return nil
 }
 
+// collectDependents adds the path of c and of everything depending on it,
+// directly or not, to paths
+func (c *cacheItem) collectDependents(paths map[string]bool) {
+	if paths[c.path] {
+		return
+	}
+	paths[c.path] = true
+
+	for _, dependent := range c.dependents {
+		if dependent != nil {
+			dependent.collectDependents(paths)
+		}
+	}
+}
+
 func (c *cacheItem) RemoveDependent(dependant *cacheItem) {
 	delete(c.dependents, dependant.path)
 
@@ -287,7 +354,9 @@ func (c *cacheItem) AddDependent(dependant *cacheItem) {
 
 type cacheManager struct {
 	cacheType int
-	cacheDir  string
+	//cacheDir is empty when the caches are kept in memory only
+	cacheDir string
+	files    viewsFS
 
 	caches map[string]*cacheItem
 
@@ -296,7 +365,12 @@ type cacheManager struct {
 	sync.RWMutex
 }
 
-func newCacheManager(cacheType int, cacheDir string) (*cacheManager, error) {
+// newCacheManager creates a cache manager persisting to a subdirectory of cacheDir.
+// Instances sharing a cacheDir must use distinct namespaces. An empty namespace
+// keeps the caches directly under cacheDir. Existing caches are discarded when
+// they were created with a different fingerprint. files is used to check cached
+// files for changes
+func newCacheManager(cacheType int, cacheDir, namespace, fingerprint string, files viewsFS) (*cacheManager, error) {
 	cacheTypeStr := "ssr"
 	if cacheType == CacheTypeBrowser {
 		cacheTypeStr = "browser"
@@ -304,7 +378,8 @@ func newCacheManager(cacheType int, cacheDir string) (*cacheManager, error) {
 
 	c := &cacheManager{
 		cacheType:    cacheType,
-		cacheDir:     filepath.Join(cacheDir, cacheTypeStr),
+		cacheDir:     filepath.Join(cacheDir, namespace, cacheTypeStr),
+		files:        files,
 		caches:       map[string]*cacheItem{},
 		dependencies: map[string][]string{},
 	}
@@ -322,6 +397,32 @@ func newCacheManager(cacheType int, cacheDir string) (*cacheManager, error) {
 		return nil, err
 	}
 
+	unlock, err := lockCacheDir(c.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	fingerprintPath := filepath.Join(c.cacheDir, cacheFingerprintFileName)
+	if !skipReadingFromCache {
+		existingFingerprint, err := os.ReadFile(fingerprintPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		//cached with another compiler or options
+		if string(existingFingerprint) != fingerprint {
+			err = clearDir(c.cacheDir)
+			if err != nil {
+				return nil, err
+			}
+			skipReadingFromCache = true
+		}
+	}
+	err = writeFileAtomic(fingerprintPath, []byte(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
 	if !skipReadingFromCache {
 		err = c.readCacheDir()
 		if err != nil {
@@ -332,6 +433,17 @@ func newCacheManager(cacheType int, cacheDir string) (*cacheManager, error) {
 	return c, nil
 }
 
+// newMemoryCacheManager creates a cache manager that keeps the compiled content
+// and the dependency graph in memory without touching the filesystem
+func newMemoryCacheManager(cacheType int, files viewsFS) *cacheManager {
+	return &cacheManager{
+		cacheType:    cacheType,
+		files:        files,
+		caches:       map[string]*cacheItem{},
+		dependencies: map[string][]string{},
+	}
+}
+
 func (c *cacheManager) Finished() {
 	c.Lock()
 	defer c.Unlock()
@@ -340,7 +452,8 @@ func (c *cacheManager) Finished() {
 		for _, pathB := range c.dependencies[pathA] {
 			cacheB := c.caches[pathB]
 			cacheA := c.caches[pathA]
-			if cacheA == nil {
+			//either isn't cached, i.e. a virtual entrypoint or an uncached dependency
+			if cacheA == nil || cacheB == nil {
 				continue
 			}
 			cacheB.AddDependent(cacheA)
@@ -352,6 +465,17 @@ func (c *cacheManager) Finished() {
 func (c *cacheManager) Persist() error {
 	c.Lock()
 	defer c.Unlock()
+
+	if len(c.cacheDir) == 0 {
+		return nil
+	}
+
+	unlock, err := lockCacheDir(c.cacheDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	for _, cache := range c.caches {
 		if !cache.HasPendingWrite() {
 			continue
@@ -392,7 +516,7 @@ func (c *cacheManager) AddCache(path string, content *string) {
 	c.Lock()
 	defer c.Unlock()
 
-	cache := newCacheItem(c.cacheDir, path, content)
+	cache := newCacheItem(c.files, c.cacheDir, path, content)
 
 	//overwrite Path if it already exists
 	c.caches[path] = cache
@@ -408,12 +532,68 @@ func (c *cacheManager) Invalidate(path string) error {
 		return nil
 	}
 
+	if len(c.cacheDir) > 0 {
+		unlock, err := lockCacheDir(c.cacheDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	//dependents are invalidated with it, they must not be served from memory either
+	invalidated := map[string]bool{}
+	cache.collectDependents(invalidated)
+
 	err := cache.Invalidate()
 	if err != nil {
 		return err
 	}
 
-	delete(c.caches, path)
+	for invalidatedPath := range invalidated {
+		delete(c.caches, invalidatedPath)
+	}
+
+	return nil
+}
+
+// InvalidateChangedDependencies invalidates the cached node_modules components
+// whose files changed since they were cached, i.e. by a dependency upgrade, and
+// everything depending on them. node_modules isn't watched, so it's checked
+// before every build
+func (c *cacheManager) InvalidateChangedDependencies() error {
+	c.Lock()
+	defer c.Unlock()
+
+	var changed []*cacheItem
+	for path, cache := range c.caches {
+		if isNodeModulesPath(path) && !cache.IsValid() {
+			changed = append(changed, cache)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if len(c.cacheDir) > 0 {
+		unlock, err := lockCacheDir(c.cacheDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	invalidated := map[string]bool{}
+	for _, cache := range changed {
+		cache.collectDependents(invalidated)
+
+		err := cache.Invalidate()
+		if err != nil {
+			return err
+		}
+	}
+	for path := range invalidated {
+		delete(c.caches, path)
+	}
 
 	return nil
 }
@@ -429,7 +609,13 @@ func (c *cacheManager) readCacheDir() error {
 		if file.IsDir() {
 			continue
 		}
-		if filepath.Ext(file.Name()) != ".metadata" {
+
+		//left over by a crash mid-write
+		if filepath.Ext(file.Name()) == tempCacheFileExt {
+			err := os.Remove(filepath.Join(c.cacheDir, file.Name()))
+			if err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -438,13 +624,33 @@ func (c *cacheManager) readCacheDir() error {
 			continue
 		}
 
+		//files of older versions are named by a truncated SHA1
+		if isCacheFileExt(filepath.Ext(file.Name())) && len(nameParts[0]) != cacheFileNameLength {
+			err := os.Remove(filepath.Join(c.cacheDir, file.Name()))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if filepath.Ext(file.Name()) != ".metadata" {
+			continue
+		}
+
 		cachePath := filepath.Join(c.cacheDir, nameParts[0]) + ".cache"
 		metadataPath := filepath.Join(c.cacheDir, file.Name())
 
-		newCache := newEmptyCacheItem(cachePath, metadataPath)
+		newCache := newEmptyCacheItem(c.files, cachePath, metadataPath)
 		err := newCache.ReadFS()
 		if err != nil {
-			return err
+			//an unreadable entry is compiled again instead of failing the build
+			for _, path := range []string{cachePath, metadataPath} {
+				err := os.Remove(path)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			continue
 		}
 		c.caches[newCache.path] = newCache
 	}
@@ -459,26 +665,52 @@ func (c *cacheManager) readCacheDir() error {
 		}
 	}
 
-	var cachesPathsToRemove []string
+	cachesPathsToRemove := map[string]bool{}
 	//verify caches are not stale. if they are, invalidate it and its dependent tree
 	for _, cache := range c.caches {
 		if !cache.IsValid() {
+			cache.collectDependents(cachesPathsToRemove)
 			err := cache.Invalidate()
 			if err != nil {
 				return err
 			}
-			cachesPathsToRemove = append(cachesPathsToRemove, cache.path)
 		}
 	}
 
 	//remove stale caches
-	for _, path := range cachesPathsToRemove {
+	for path := range cachesPathsToRemove {
 		delete(c.caches, path)
 	}
 
 	return nil
 }
 
+// clearDir removes everything in dir but the lock file
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		//removing the lock file would let another process take a lock while
+		//this one holds it
+		if entry.Name() == cacheLockFileName {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isCacheFileExt(ext string) bool {
+	return ext == ".cache" || ext == ".metadata"
+}
+
 type nopCache struct {
 }
 
@@ -511,6 +743,10 @@ func (c *nopCache) Invalidate(path string) error {
 	return nil
 }
 
+func (c *nopCache) InvalidateChangedDependencies() error {
+	return nil
+}
+
 type Cache interface {
 	Finished()
 	Persist() error
@@ -518,10 +754,45 @@ type Cache interface {
 	DependsOn(pathA, pathB string) error
 	AddCache(path string, content *string)
 	Invalidate(path string) error
+	InvalidateChangedDependencies() error
 }
 
 var _ Cache = &nopCache{}
 var _ Cache = &cacheManager{}
+var _ Cache = &skipNodeModulesCache{}
+
+// skipNodeModulesCache passes everything but the files in node_modules through
+// to Cache. Dependency components rarely change, so not caching them trades
+// compiling them on startup for a smaller cache directory
+type skipNodeModulesCache struct {
+	Cache
+}
+
+func isNodeModulesPath(path string) bool {
+	return strings.Contains(filepath.ToSlash(path), "/"+npmDir+"/")
+}
+
+func (c *skipNodeModulesCache) GetContent(path string) *string {
+	if isNodeModulesPath(path) {
+		return nil
+	}
+	return c.Cache.GetContent(path)
+}
+
+func (c *skipNodeModulesCache) DependsOn(pathA, pathB string) error {
+	//dependencies can only be tracked between cached files
+	if isNodeModulesPath(pathA) || isNodeModulesPath(pathB) {
+		return nil
+	}
+	return c.Cache.DependsOn(pathA, pathB)
+}
+
+func (c *skipNodeModulesCache) AddCache(path string, content *string) {
+	if isNodeModulesPath(path) {
+		return
+	}
+	c.Cache.AddCache(path, content)
+}
 
 type serializedCacheContent struct {
 	Js  *string
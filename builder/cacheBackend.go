@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mansoor-s/aviator/utils"
+)
+
+// CacheBackend stores compiled components so machines can share them, i.e. CI
+// runners using a bucket or Redis. Keys are hashes of the compiler fingerprint,
+// the path of the component relative to the views directory and its source.
+// It's called concurrently during builds
+type CacheBackend interface {
+	//Get returns nil when nothing is stored under key
+	Get(key string) ([]byte, error)
+	Put(key string, content []byte) error
+}
+
+// backendCache looks up components Cache misses in a CacheBackend and stores
+// every component added to Cache in it. The backend is best effort, its errors
+// are logged and treated as misses
+type backendCache struct {
+	Cache
+	backend     CacheBackend
+	cacheType   int
+	fingerprint string
+	files       viewsFS
+	logger      utils.Logger
+}
+
+var _ Cache = &backendCache{}
+
+func newBackendCache(
+	cache Cache,
+	backend CacheBackend,
+	cacheType int,
+	fingerprint string,
+	files viewsFS,
+	logger utils.Logger,
+) *backendCache {
+	return &backendCache{
+		Cache:       cache,
+		backend:     backend,
+		cacheType:   cacheType,
+		fingerprint: fingerprint,
+		files:       files,
+		logger:      logger,
+	}
+}
+
+// key returns the backend key of the component at path. Virtual components
+// without a file have no key
+func (c *backendCache) key(path string) (string, bool) {
+	source, err := c.files.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	//absolute paths differ between machines
+	keyPath := path
+	absRoot, rootErr := filepath.Abs(c.files.root)
+	absPath, pathErr := filepath.Abs(path)
+	if rootErr == nil && pathErr == nil {
+		relPath, err := filepath.Rel(absRoot, absPath)
+		if err == nil && !strings.HasPrefix(relPath, "..") {
+			keyPath = relPath
+		}
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00", c.fingerprint, c.cacheType, filepath.ToSlash(keyPath))
+	h.Write(source)
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (c *backendCache) GetContent(path string) *string {
+	if content := c.Cache.GetContent(path); content != nil {
+		return content
+	}
+
+	key, ok := c.key(path)
+	if !ok {
+		return nil
+	}
+	stored, err := c.backend.Get(key)
+	if err != nil {
+		c.logger.Error("error reading from cache backend: " + err.Error())
+		return nil
+	}
+	if stored == nil {
+		return nil
+	}
+
+	content := string(stored)
+	//keep it locally for the next build
+	c.Cache.AddCache(path, &content)
+
+	return &content
+}
+
+func (c *backendCache) AddCache(path string, content *string) {
+	c.Cache.AddCache(path, content)
+
+	key, ok := c.key(path)
+	if !ok {
+		return
+	}
+	err := c.backend.Put(key, []byte(*content))
+	if err != nil {
+		c.logger.Error("error writing to cache backend: " + err.Error())
+	}
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryCacheBackend stands in for a remote backend
+type memoryCacheBackend struct {
+	items map[string][]byte
+	sync.Mutex
+}
+
+func (b *memoryCacheBackend) Get(key string) ([]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.items[key], nil
+}
+
+func (b *memoryCacheBackend) Put(key string, content []byte) error {
+	b.Lock()
+	defer b.Unlock()
+	b.items[key] = content
+	return nil
+}
+
+func TestBackendCache(t *testing.T) {
+	backend := &memoryCacheBackend{items: map[string][]byte{}}
+
+	//two machines checking out the views in different directories
+	newMachine := func(fingerprint string) (*backendCache, string) {
+		viewsDir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(viewsDir, "Index.svelte"), []byte(`<h1>Index</h1>`), 0644))
+		files := viewsFS{root: viewsDir}
+		cache := newBackendCache(newMemoryCacheManager(CacheTypeSSR, files), backend, CacheTypeSSR, fingerprint, files, nopLogger{})
+		return cache, filepath.Join(viewsDir, "Index.svelte")
+	}
+
+	first, firstPath := newMachine("compilerA")
+	compiled := "compiled index"
+	first.AddCache(firstPath, &compiled)
+	assert.Len(t, backend.items, 1)
+
+	second, secondPath := newMachine("compilerA")
+	if assert.NotNil(t, second.GetContent(secondPath)) {
+		assert.Equal(t, compiled, *second.GetContent(secondPath))
+	}
+	//kept in the local cache
+	assert.NotNil(t, second.Cache.GetContent(secondPath))
+
+	//compiled by another compiler
+	other, otherPath := newMachine("compilerB")
+	assert.Nil(t, other.GetContent(otherPath))
+
+	//the source changed
+	changed, changedPath := newMachine("compilerA")
+	assert.NoError(t, os.WriteFile(changedPath, []byte(`<h1>Changed</h1>`), 0644))
+	assert.Nil(t, changed.GetContent(changedPath))
+
+	//virtual components aren't shared
+	wrapped := "compiled wrapper"
+	first.AddCache("__AviatorWrapped_Index.svelte", &wrapped)
+	assert.Len(t, backend.items, 1)
+}
@@ -0,0 +1,111 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// CacheVaryRule declares the render context values the output of each view
+// whose path relative to the views directory matches Glob varies by
+type CacheVaryRule struct {
+	Glob       string
+	Dimensions []string
+}
+
+// cacheKeyInput is hashed into a render cache key. The JSON encoding sorts the
+// Vary keys, so the key doesn't depend on map order
+type cacheKeyInput struct {
+	View   string
+	Build  string
+	Locale string
+	Vary   map[string]interface{}
+	Props  json.RawMessage
+}
+
+// RenderCacheKey returns a key identifying the output of rendering the view with
+// props and opts, i.e. for caching rendered pages at the edge. It changes with
+// every build. Render context values are only part of the key when they're
+// dimensions of a CacheVaryRule matching the view, missing dimensions included
+func (v *ViewManager) RenderCacheKey(viewPath string, props interface{}, opts ...RenderOption) (string, error) {
+	view := v.ViewByRelPath(viewPath)
+	if view == nil {
+		return "", fmt.Errorf("view does not exist in path %s", viewPath)
+	}
+	options := newRenderOptions(opts)
+
+	input := cacheKeyInput{
+		View:   view.RelPath,
+		Locale: options.locale,
+		Vary:   map[string]interface{}{},
+		Props:  json.RawMessage("null"),
+	}
+	for _, dimension := range v.cacheVaryDimensions(view) {
+		input.Vary[dimension] = options.context[dimension]
+	}
+	if props != nil {
+		jsonProps, err := v.propsEncoder(props)
+		if err != nil {
+			return "", v.propsError(viewPath, props, err)
+		}
+		input.Props = jsonProps
+	}
+
+	v.viewsLock.RLock()
+	input.Build = v.buildVersion
+	v.viewsLock.RUnlock()
+
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to json serialize render context %w", err)
+	}
+
+	return v.hash(encoded), nil
+}
+
+// cacheVaryDimensions returns the sorted dimensions of all rules matching the
+// view and those of its metadata file
+func (v *ViewManager) cacheVaryDimensions(view *View) []string {
+	seen := map[string]bool{}
+	var dimensions []string
+	addDimensions := func(ruleDimensions []string) {
+		for _, dimension := range ruleDimensions {
+			if !seen[dimension] {
+				seen[dimension] = true
+				dimensions = append(dimensions, dimension)
+			}
+		}
+	}
+	for _, rule := range v.cacheVaryRules {
+		if matched, _ := path.Match(rule.Glob, view.RelPath); matched {
+			addDimensions(rule.Dimensions)
+		}
+	}
+	addDimensions(view.CacheVary)
+	sort.Strings(dimensions)
+
+	return dimensions
+}
+
+// computeBuildVersion hashes the SSR bundle and the static assets, which is
+// everything the output of a render depends on besides its input
+func (v *ViewManager) computeBuildVersion() string {
+	content := append([]byte{}, v.ssrBundle...)
+	for _, name := range sortedAssetNames(v.staticContent) {
+		content = append(content, name...)
+		content = append(content, v.staticContent[name].Content...)
+	}
+
+	return v.hash(content)
+}
+
+func sortedAssetNames(staticContent map[string]StaticAsset) []string {
+	names := make([]string, 0, len(staticContent))
+	for name := range staticContent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
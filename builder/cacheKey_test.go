@@ -0,0 +1,44 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewManager_RenderCacheKey(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	v.cacheVaryRules = []CacheVaryRule{
+		{Glob: "*.svelte", Dimensions: []string{"locale"}},
+		{Glob: "Index.svelte", Dimensions: []string{"theme", "locale"}},
+	}
+	props := map[string]string{"title": "Cars"}
+	key := func(context map[string]interface{}, opts ...RenderOption) string {
+		k, err := v.RenderCacheKey("Index.svelte", props, append(opts, RenderContext(context))...)
+		assert.NoError(t, err)
+		return k
+	}
+
+	en := key(map[string]interface{}{"locale": "en", "theme": "dark"})
+	assert.Equal(t, en, key(map[string]interface{}{"theme": "dark", "locale": "en"}))
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "fr", "theme": "dark"}))
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "en", "theme": "light"}))
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "en"}))
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "en", "theme": "dark"}, Locale("fr")))
+
+	//values that aren't dimensions don't matter
+	assert.Equal(t, en, key(map[string]interface{}{"locale": "en", "theme": "dark", "user": "ada"}))
+
+	//the view's metadata file adds dimensions
+	view.CacheVary = []string{"user"}
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "en", "theme": "dark", "user": "ada"}))
+	view.CacheVary = nil
+
+	//a new build changes every key
+	v.ssrBundle = []byte("var __aviator__ = {}")
+	v.buildVersion = v.computeBuildVersion()
+	assert.NotEqual(t, en, key(map[string]interface{}{"locale": "en", "theme": "dark"}))
+
+	_, err := v.RenderCacheKey("Missing.svelte", props)
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// cacheLockFileName is the file in a cache directory that's locked while its
+// caches are read or written. Processes sharing a cache directory, i.e. during
+// a rolling restart, take turns instead of corrupting each other's files
+const cacheLockFileName = "lock"
+
+// lockCacheDir takes an exclusive lock on dir, blocking until any other holder
+// releases it. The returned func releases the lock
+func lockCacheDir(dir string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(dir, cacheLockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		//closing releases the lock as well if unlocking failed
+		_ = f.Close()
+	}, nil
+}
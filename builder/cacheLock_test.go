@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockCacheDir(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockCacheDir(dir)
+	assert.NoError(t, err)
+
+	locked := make(chan struct{})
+	go func() {
+		unlockSecond, err := lockCacheDir(dir)
+		assert.NoError(t, err)
+		close(locked)
+		unlockSecond()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the lock was taken while it was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock wasn't taken after it was released")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package builder
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRangeLow and lockRangeHigh lock the whole file by locking its maximum range
+const lockRangeLow, lockRangeHigh = ^uint32(0), ^uint32(0)
+
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK,
+		0,
+		lockRangeLow,
+		lockRangeHigh,
+		&windows.Overlapped{},
+	)
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(
+		windows.Handle(f.Fd()),
+		0,
+		lockRangeLow,
+		lockRangeHigh,
+		&windows.Overlapped{},
+	)
+}
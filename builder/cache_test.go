@@ -5,18 +5,21 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 func TestCacheItem_Persistence(t *testing.T) {
 	cacheDir := t.TempDir()
 	testPath := "/views/catalog/cars.svelte"
 	testContent := `function(){console.log("my content is cool")}()`
-	item := newCacheItem(cacheDir, testPath, &testContent)
+	item := newCacheItem(viewsFS{}, cacheDir, testPath, &testContent)
 
 	testDependentPath := "/views/catalog/cats.svelte"
 	dependentContent := ""
 	testDependent := newCacheItem(
+		viewsFS{},
 		cacheDir,
 		testDependentPath,
 		&dependentContent,
@@ -56,11 +59,12 @@ func TestCacheItem_Invalidate(t *testing.T) {
 	cacheDir := t.TempDir()
 	testPath := "/views/catalog/cars.svelte"
 	testContent := `function(){console.log("my content is cool")}()`
-	item := newCacheItem(cacheDir, testPath, &testContent)
+	item := newCacheItem(viewsFS{}, cacheDir, testPath, &testContent)
 
 	testDependentPath := "/views/catalog/cats.svelte"
 	dependentContent := ""
 	testDependent := newCacheItem(
+		viewsFS{},
 		cacheDir,
 		testDependentPath,
 		&dependentContent,
@@ -92,22 +96,46 @@ func TestCacheItem_Invalidate(t *testing.T) {
 
 func TestCacheManager(t *testing.T) {
 	cacheDir := t.TempDir()
-	_, err := newCacheManager(CacheTypeSSR, cacheDir)
+	_, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
 	assert.NoError(t, err)
 
 	assert.DirExists(t, filepath.Join(cacheDir, "ssr"))
 	assert.NoDirExists(t, filepath.Join(cacheDir, "browser"))
 
-	_, err = newCacheManager(CacheTypeBrowser, cacheDir)
+	_, err = newCacheManager(CacheTypeBrowser, cacheDir, "", "", viewsFS{})
 	assert.NoError(t, err)
 
 	assert.DirExists(t, filepath.Join(cacheDir, "ssr"))
 	assert.DirExists(t, filepath.Join(cacheDir, "browser"))
 }
 
+func TestCacheManager_Namespace(t *testing.T) {
+	cacheDir := t.TempDir()
+	testPath := "/views/catalog/cars.svelte"
+	testContent := "foobar"
+
+	cacheA, err := newCacheManager(CacheTypeSSR, cacheDir, "appA", "", viewsFS{})
+	assert.NoError(t, err)
+	cacheA.AddCache(testPath, &testContent)
+	assert.NoError(t, cacheA.Persist())
+
+	cacheB, err := newCacheManager(CacheTypeSSR, cacheDir, "appB", "", viewsFS{})
+	assert.NoError(t, err)
+	assert.Nil(t, cacheB.GetContent(testPath))
+
+	assert.DirExists(t, filepath.Join(cacheDir, "appA", "ssr"))
+	assert.DirExists(t, filepath.Join(cacheDir, "appB", "ssr"))
+
+	reopenedA, err := newCacheManager(CacheTypeSSR, cacheDir, "appA", "", viewsFS{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, reopenedA.GetContent(testPath)) {
+		assert.Equal(t, testContent, *reopenedA.GetContent(testPath))
+	}
+}
+
 func TestCacheManager_DependsOn(t *testing.T) {
 	cacheDir := t.TempDir()
-	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir)
+	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
 	assert.NoError(t, err)
 
 	testPathA := "/views/catalog/cats.svelte"
@@ -138,3 +166,278 @@ func TestCacheManager_DependsOn(t *testing.T) {
 
 	*/
 }
+
+func TestCacheItem_ViewsFS(t *testing.T) {
+	views := fstest.MapFS{
+		"index.svelte": {Data: []byte(`<h1>Home</h1>`)},
+	}
+	root := filepath.Join(t.TempDir(), "views")
+	content := "compiled"
+
+	item := newCacheItem(viewsFS{root: root, fsys: views}, t.TempDir(), filepath.Join(root, "index.svelte"), &content)
+	assert.NotEmpty(t, item.pathContentHash)
+	assert.True(t, item.IsValid())
+
+	views["index.svelte"] = &fstest.MapFile{Data: []byte(`<h1>Changed</h1>`)}
+	assert.False(t, item.IsValid())
+}
+
+func TestSkipNodeModulesCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	manager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	testCache := &skipNodeModulesCache{manager}
+
+	npmPath := "/project/node_modules/ui-kit/Button.svelte"
+	viewPath := "/project/views/index.svelte"
+	content := "foobar"
+
+	testCache.AddCache(npmPath, &content)
+	testCache.AddCache(viewPath, &content)
+	assert.NoError(t, testCache.DependsOn(viewPath, npmPath))
+
+	assert.Nil(t, testCache.GetContent(npmPath))
+	assert.NotNil(t, testCache.GetContent(viewPath))
+	assert.NotContains(t, manager.caches, npmPath)
+	assert.Contains(t, manager.caches, viewPath)
+}
+
+func TestCacheManager_RemovesLegacyCacheFiles(t *testing.T) {
+	cacheDir := t.TempDir()
+	ssrDir := filepath.Join(cacheDir, "ssr")
+	assert.NoError(t, os.MkdirAll(ssrDir, os.ModePerm))
+
+	//named by a SHA1 truncated to 20 characters
+	legacyName := "0123456789abcdef0123"
+	legacyMetadata := fmt.Sprintf(`{"Path":%q}`, "/views/catalog/cars.svelte")
+	assert.NoError(t, os.WriteFile(filepath.Join(ssrDir, legacyName+".cache"), []byte("{}"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(ssrDir, legacyName+".metadata"), []byte(legacyMetadata), 0644))
+
+	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	assert.Empty(t, testCacheManager.caches)
+	assert.NoFileExists(t, filepath.Join(ssrDir, legacyName+".cache"))
+	assert.NoFileExists(t, filepath.Join(ssrDir, legacyName+".metadata"))
+
+	testContent := "foobar"
+	item := newCacheItem(viewsFS{}, ssrDir, "/views/catalog/cars.svelte", &testContent)
+	assert.Len(t, item.cachedContentHash, 64)
+}
+
+func TestCacheManager_PartialWrite(t *testing.T) {
+	cacheDir := t.TempDir()
+	ssrDir := filepath.Join(cacheDir, "ssr")
+	viewsDir := t.TempDir()
+
+	goodPath := filepath.Join(viewsDir, "good.svelte")
+	assert.NoError(t, os.WriteFile(goodPath, []byte(`<h1>Good</h1>`), 0644))
+	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	goodContent := "good"
+	testCacheManager.AddCache(goodPath, &goodContent)
+	assert.NoError(t, testCacheManager.Persist())
+
+	//simulate a crash while writing the entry of another view
+	badPath := filepath.Join(viewsDir, "bad.svelte")
+	assert.NoError(t, os.WriteFile(badPath, []byte(`<h1>Bad</h1>`), 0644))
+	badContent := "function render() { return 'bad' }"
+	badItem := newCacheItem(viewsFS{}, ssrDir, badPath, &badContent)
+	assert.NoError(t, badItem.PersistToFS())
+	assert.NoError(t, os.WriteFile(badItem.cacheFilePath, []byte(badContent[:10]), 0644))
+
+	truncatedContent := "truncated"
+	truncatedItem := newCacheItem(viewsFS{}, ssrDir, badPath, &truncatedContent)
+	assert.NoError(t, truncatedItem.writeCacheFile())
+	assert.NoError(t, os.WriteFile(truncatedItem.metadataFilePath, []byte(`{"Path":"/vi`), 0644))
+
+	tempFile := filepath.Join(ssrDir, truncatedItem.cachedContentHash+".cache.123"+tempCacheFileExt)
+	assert.NoError(t, os.WriteFile(tempFile, []byte("trunc"), 0644))
+
+	reopened, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, reopened.GetContent(goodPath)) {
+		assert.Equal(t, goodContent, *reopened.GetContent(goodPath))
+	}
+	assert.Nil(t, reopened.GetContent(badPath))
+
+	assert.NoFileExists(t, badItem.cacheFilePath)
+	assert.NoFileExists(t, badItem.metadataFilePath)
+	assert.NoFileExists(t, truncatedItem.metadataFilePath)
+	assert.NoFileExists(t, tempFile)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "item.cache")
+
+	assert.NoError(t, writeFileAtomic(path, []byte("first")))
+	assert.NoError(t, writeFileAtomic(path, []byte("second")))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	//no temp files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestCacheManager_Fingerprint(t *testing.T) {
+	cacheDir := t.TempDir()
+	viewsDir := t.TempDir()
+	testPath := filepath.Join(viewsDir, "cars.svelte")
+	assert.NoError(t, os.WriteFile(testPath, []byte(`<h1>Cars</h1>`), 0644))
+	testContent := "foobar"
+
+	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "compilerA", viewsFS{})
+	assert.NoError(t, err)
+	testCacheManager.AddCache(testPath, &testContent)
+	assert.NoError(t, testCacheManager.Persist())
+
+	sameFingerprint, err := newCacheManager(CacheTypeSSR, cacheDir, "", "compilerA", viewsFS{})
+	assert.NoError(t, err)
+	assert.NotNil(t, sameFingerprint.GetContent(testPath))
+
+	//i.e. the compiler was upgraded
+	newFingerprint, err := newCacheManager(CacheTypeSSR, cacheDir, "", "compilerB", viewsFS{})
+	assert.NoError(t, err)
+	assert.Nil(t, newFingerprint.GetContent(testPath))
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "ssr"))
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{cacheFingerprintFileName, cacheLockFileName}, names)
+}
+
+func TestMemoryCacheManager(t *testing.T) {
+	viewsDir := t.TempDir()
+	testPath := filepath.Join(viewsDir, "cars.svelte")
+	dependentPath := filepath.Join(viewsDir, "garage.svelte")
+	assert.NoError(t, os.WriteFile(testPath, []byte(`<h1>Cars</h1>`), 0644))
+	assert.NoError(t, os.WriteFile(dependentPath, []byte(`<h1>Garage</h1>`), 0644))
+	testContent := "foobar"
+
+	testCacheManager := newMemoryCacheManager(CacheTypeSSR, viewsFS{})
+	testCacheManager.AddCache(testPath, &testContent)
+	testCacheManager.AddCache(dependentPath, &testContent)
+	assert.NoError(t, testCacheManager.DependsOn(dependentPath, testPath))
+	testCacheManager.Finished()
+	assert.NoError(t, testCacheManager.Persist())
+
+	assert.NotNil(t, testCacheManager.GetContent(testPath))
+	assert.Contains(t, testCacheManager.caches[testPath].dependents, dependentPath)
+	assert.Empty(t, testCacheManager.caches[testPath].cacheFilePath)
+
+	dependent := testCacheManager.caches[dependentPath]
+	assert.NoError(t, testCacheManager.Invalidate(testPath))
+	assert.Nil(t, testCacheManager.GetContent(testPath))
+	assert.True(t, dependent.markedForDeletion)
+	//the dependent is compiled again instead of being served stale
+	assert.Nil(t, testCacheManager.GetContent(dependentPath))
+}
+
+func TestCacheManager_SharedCacheDir(t *testing.T) {
+	cacheDir := t.TempDir()
+	viewsDir := t.TempDir()
+
+	contents := map[string]string{}
+	for i := 0; i < 50; i++ {
+		viewPath := filepath.Join(viewsDir, fmt.Sprintf("View%d.svelte", i))
+		assert.NoError(t, os.WriteFile(viewPath, []byte(fmt.Sprintf("<h1>%d</h1>", i)), 0644))
+		contents[viewPath] = fmt.Sprintf("function render() { return %d }", i)
+	}
+
+	//two processes persisting the same caches at once, i.e. during a restart
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+			assert.NoError(t, err)
+			for round := 0; round < 5; round++ {
+				for viewPath, content := range contents {
+					content := content
+					manager.AddCache(viewPath, &content)
+				}
+				assert.NoError(t, manager.Persist())
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	for viewPath, content := range contents {
+		if assert.NotNil(t, reopened.GetContent(viewPath), viewPath) {
+			assert.Equal(t, content, *reopened.GetContent(viewPath))
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "ssr"))
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, tempCacheFileExt, filepath.Ext(entry.Name()))
+	}
+}
+
+func TestCacheManager_InvalidateChangedDependencies(t *testing.T) {
+	cacheDir := t.TempDir()
+	viewsDir := t.TempDir()
+	dependencyDir := filepath.Join(viewsDir, npmDir, "ui")
+	assert.NoError(t, os.MkdirAll(dependencyDir, os.ModePerm))
+
+	buttonPath := filepath.Join(dependencyDir, "Button.svelte")
+	pagePath := filepath.Join(viewsDir, "Page.svelte")
+	otherPath := filepath.Join(viewsDir, "Other.svelte")
+	assert.NoError(t, os.WriteFile(buttonPath, []byte(`<button>v1</button>`), 0644))
+	assert.NoError(t, os.WriteFile(pagePath, []byte(`<Button />`), 0644))
+	assert.NoError(t, os.WriteFile(otherPath, []byte(`<h1>Other</h1>`), 0644))
+
+	testCacheManager, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	for _, path := range []string{buttonPath, pagePath, otherPath} {
+		content := "compiled " + path
+		testCacheManager.AddCache(path, &content)
+	}
+	assert.NoError(t, testCacheManager.DependsOn(pagePath, buttonPath))
+	testCacheManager.Finished()
+	assert.NoError(t, testCacheManager.Persist())
+
+	//nothing changed
+	assert.NoError(t, testCacheManager.InvalidateChangedDependencies())
+	assert.NotNil(t, testCacheManager.GetContent(buttonPath))
+	assert.NotNil(t, testCacheManager.GetContent(pagePath))
+
+	//i.e. npm install upgraded the package
+	assert.NoError(t, os.WriteFile(buttonPath, []byte(`<button>v2</button>`), 0644))
+	assert.NoError(t, testCacheManager.InvalidateChangedDependencies())
+	assert.Nil(t, testCacheManager.GetContent(buttonPath))
+	assert.Nil(t, testCacheManager.GetContent(pagePath))
+	assert.NotNil(t, testCacheManager.GetContent(otherPath))
+
+	reopened, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	assert.Nil(t, reopened.GetContent(pagePath))
+	assert.NotNil(t, reopened.GetContent(otherPath))
+
+	for _, path := range []string{buttonPath, pagePath} {
+		content := "compiled " + path
+		reopened.AddCache(path, &content)
+	}
+	assert.NoError(t, reopened.DependsOn(pagePath, buttonPath))
+	reopened.Finished()
+	assert.NoError(t, reopened.Persist())
+
+	//i.e. npm install ran while the server was stopped
+	assert.NoError(t, os.WriteFile(buttonPath, []byte(`<button>v3</button>`), 0644))
+	restarted, err := newCacheManager(CacheTypeSSR, cacheDir, "", "", viewsFS{})
+	assert.NoError(t, err)
+	assert.Nil(t, restarted.GetContent(buttonPath))
+	assert.Nil(t, restarted.GetContent(pagePath))
+	assert.NotNil(t, restarted.GetContent(otherPath))
+}
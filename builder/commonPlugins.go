@@ -1,10 +1,14 @@
 package builder
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -14,9 +18,35 @@ import (
 
 const fakeCssFilter string = `^.*\.fake-svelte-css$`
 
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 const wrappedScriptFmt = "<script>\n%s\n</script>\n"
 const wrappedImportStatementFmt = "import %s from \"%s\""
 
+// layoutPropsKey is the props field holding the props of the layouts, set when
+// they're rendered with their own props instead of the page's
+const layoutPropsKey = "__aviator_layout_props"
+
+// wrappedPropsFuncs split the props of a layout wrapped component between the
+// layouts and the page. Without layout props, both get all of them
+const wrappedPropsFuncs = `const layoutPropsKey = "` + layoutPropsKey + `"
+function layoutProps(props) {
+	if (props && props[layoutPropsKey] !== undefined) {
+		return props[layoutPropsKey]
+	}
+	return props || {}
+}
+function pageProps(props) {
+	if (!props || props[layoutPropsKey] === undefined) {
+		return props || {}
+	}
+	const { [layoutPropsKey]: _, ...rest } = props
+	return rest
+}`
+
+// createLayoutWrappedView creates the source of the component wrapping view
+// with its layouts. The outermost layout wraps the others and the view is
+// nested in the nearest one
 func createLayoutWrappedView(view *View) string {
 	layouts := view.ApplicableLayoutViews
 
@@ -24,11 +54,13 @@ func createLayoutWrappedView(view *View) string {
 	var startTags []string
 	var endTags []string
 
-	for _, layout := range layouts {
+	//the nearest layout is first
+	for i := len(layouts) - 1; i >= 0; i-- {
+		layout := layouts[i]
 		importStatement := fmt.Sprintf(wrappedImportStatementFmt, layout.UniqueName, layout.RelPath)
 		importStatements = append(importStatements, importStatement)
 
-		startStr := `<svelte:component this={` + layout.UniqueName + `} {...($$props || {})}>`
+		startStr := `<svelte:component this={` + layout.UniqueName + `} {...layoutProps($$props)}>`
 		startTags = append(startTags, startStr)
 
 		endStr := `</svelte:component>`
@@ -37,19 +69,19 @@ func createLayoutWrappedView(view *View) string {
 	importStatement := fmt.Sprintf(wrappedImportStatementFmt, view.UniqueName, view.RelPath)
 	importStatements = append(importStatements, importStatement)
 
-	componentStr := `<svelte:component this={` + view.UniqueName + `} {...($$props || {})}/>`
+	componentStr := `<svelte:component this={` + view.UniqueName + `} {...pageProps($$props)}/>`
 
 	wrappedComponentStr := strings.Join(startTags, "") +
 		componentStr +
 		strings.Join(endTags, "")
 
 	allImportStatements := strings.Join(importStatements, "\n")
-	wrappedSvelteComponent := fmt.Sprintf(wrappedScriptFmt, allImportStatements)
+	wrappedSvelteComponent := fmt.Sprintf(wrappedScriptFmt, allImportStatements+"\n"+wrappedPropsFuncs)
 
 	return wrappedSvelteComponent + wrappedComponentStr
 }
 
-func npmJsPathPlugin(workingDir string) esbuild.Plugin {
+func npmJsPathPlugin(workingDir string, files viewsFS) esbuild.Plugin {
 	return esbuild.Plugin{
 		Name: "js_path",
 		Setup: func(epb esbuild.PluginBuild) {
@@ -87,6 +119,13 @@ func npmJsPathPlugin(workingDir string) esbuild.Plugin {
 				func(args esbuild.OnResolveArgs) (esbuild.OnResolveResult, error) {
 					var result esbuild.OnResolveResult
 
+					//aliased imports are resolved to absolute paths
+					if filepath.IsAbs(args.Path) {
+						result.Path = args.Path
+						result.Namespace = "js_path"
+						return result, nil
+					}
+
 					callerPath := filepath.Dir(args.Importer)
 					absPath, err := filepath.Abs(path.Join(callerPath, args.Path))
 					if err != nil {
@@ -103,7 +142,7 @@ func npmJsPathPlugin(workingDir string) esbuild.Plugin {
 			epb.OnLoad(
 				esbuild.OnLoadOptions{Filter: `.*`, Namespace: "js_path"},
 				func(args esbuild.OnLoadArgs) (result esbuild.OnLoadResult, err error) {
-					rawCode, err := os.ReadFile(args.Path)
+					rawCode, err := files.ReadFile(args.Path)
 					if err != nil {
 						return result, err
 					}
@@ -119,7 +158,161 @@ func npmJsPathPlugin(workingDir string) esbuild.Plugin {
 	}
 }
 
-type SvelteCompilerFunc func(string, []byte) (*SvelteBuildOutput, error)
+// storeInitializerPlugin serves the user provided store initializer source as the
+// virtual __aviator_store_initializer.js module. Imports inside it are resolved
+// relative to the views directory
+func storeInitializerPlugin(workingDir string, source string) esbuild.Plugin {
+	return esbuild.Plugin{
+		Name: "storeInitializer",
+		Setup: func(epb esbuild.PluginBuild) {
+			epb.OnResolve(
+				esbuild.OnResolveOptions{Filter: `^__aviator_store_initializer\.js$`},
+				func(args esbuild.OnResolveArgs) (result esbuild.OnResolveResult, err error) {
+					result.Namespace = "storeInitializer"
+					result.Path = args.Path
+					return result, nil
+				},
+			)
+			epb.OnLoad(
+				esbuild.OnLoadOptions{Filter: `.*`, Namespace: "storeInitializer"},
+				func(args esbuild.OnLoadArgs) (result esbuild.OnLoadResult, err error) {
+					contents := source
+					result.ResolveDir = workingDir
+					result.Contents = &contents
+					result.Loader = esbuild.LoaderJS
+					return result, nil
+				},
+			)
+		},
+	}
+}
+
+// importAliasPlugin rewrites imports starting with one of the aliases, i.e.
+// $lib/Button.svelte, to the path the alias points to and resolves them again
+// through the other plugins. Relative alias targets are relative to the views
+// directory
+func importAliasPlugin(workingDir string, aliases map[string]string) esbuild.Plugin {
+	prefixes := make([]string, 0, len(aliases))
+	for prefix := range aliases {
+		prefixes = append(prefixes, prefix)
+	}
+	//the longest alias wins when one is a prefix of another
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	quoted := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		quoted[i] = regexp.QuoteMeta(prefix)
+	}
+
+	return esbuild.Plugin{
+		Name: "importAlias",
+		Setup: func(epb esbuild.PluginBuild) {
+			if len(aliases) == 0 {
+				return
+			}
+
+			epb.OnResolve(
+				esbuild.OnResolveOptions{Filter: `^(` + strings.Join(quoted, "|") + `)(/|$)`},
+				func(args esbuild.OnResolveArgs) (result esbuild.OnResolveResult, err error) {
+					var target string
+					for _, prefix := range prefixes {
+						rest := strings.TrimPrefix(args.Path, prefix)
+						if rest == args.Path || (len(rest) > 0 && rest[0] != '/') {
+							continue
+						}
+						target = filepath.Join(aliases[prefix], filepath.FromSlash(rest))
+						break
+					}
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(workingDir, target)
+					}
+
+					resolved := epb.Resolve(target, esbuild.ResolveOptions{
+						Importer:   args.Importer,
+						Namespace:  args.Namespace,
+						ResolveDir: args.ResolveDir,
+						Kind:       args.Kind,
+						PluginData: args.PluginData,
+					})
+					if len(resolved.Errors) > 0 {
+						return result, fmt.Errorf(
+							"failed to resolve %s as %s: %s", args.Path, target, resolved.Errors[0].Text,
+						)
+					}
+
+					result.Path = resolved.Path
+					result.Namespace = resolved.Namespace
+					result.External = resolved.External
+					result.Suffix = resolved.Suffix
+					result.PluginData = resolved.PluginData
+					if !resolved.SideEffects {
+						result.SideEffects = esbuild.SideEffectsFalse
+					}
+					return result, nil
+				},
+			)
+		},
+	}
+}
+
+// externalStubModule replaces external imports in the SSR bundle. The SSR bundle
+// can't load modules at runtime, so external packages can only be used by code
+// that doesn't run during SSR, i.e. onMount
+const externalStubModule = "module.exports = {}"
+
+// externalResolverPlugin leaves the bare imports resolver marks as external out
+// of the bundle. In the SSR build they are replaced with an empty module
+func externalResolverPlugin(resolver ExternalResolver, isSSR bool) esbuild.Plugin {
+	return esbuild.Plugin{
+		Name: "externalResolver",
+		Setup: func(epb esbuild.PluginBuild) {
+			if resolver == nil {
+				return
+			}
+
+			epb.OnResolve(
+				//bare imports don't start with a "." or a "/"
+				esbuild.OnResolveOptions{Filter: `^[^./]`},
+				func(args esbuild.OnResolveArgs) (result esbuild.OnResolveResult, err error) {
+					url, external := resolver(args.Path)
+					if !external {
+						//let the other resolvers handle it
+						return result, nil
+					}
+
+					if isSSR {
+						result.Namespace = "externalStub"
+						result.Path = args.Path
+						return result, nil
+					}
+
+					result.Path = args.Path
+					if len(url) > 0 {
+						result.Path = url
+					}
+					result.External = true
+					return result, nil
+				},
+			)
+			epb.OnLoad(
+				esbuild.OnLoadOptions{Filter: `.*`, Namespace: "externalStub"},
+				func(args esbuild.OnLoadArgs) (result esbuild.OnLoadResult, err error) {
+					contents := externalStubModule
+					result.Contents = &contents
+					result.Loader = esbuild.LoaderJS
+					return result, nil
+				},
+			)
+		},
+	}
+}
+
+// SvelteCompilerFunc compiles a svelte component. name is the file name the
+// compiler sees and relPath is the path of the component relative to the views
+// directory
+type SvelteCompilerFunc func(name string, relPath string, code []byte) (*SvelteBuildOutput, error)
 
 // wrappedComponentsPlugin creates a new virtual svelte component that
 // composes all Svelte Components with all the layouts that apply to them
@@ -146,11 +339,12 @@ func wrappedComponentsPlugin(
 					result.Namespace = "wrappedComponents"
 					result.Path = args.Path
 
-					/*
+					if args.Importer != "" {
 						err = cache.DependsOn(args.Importer, args.Path)
 						if err != nil {
 							return result, err
-						}*/
+						}
+					}
 
 					return result, nil
 				},
@@ -175,15 +369,34 @@ func wrappedComponentsPlugin(
 						)
 					}
 
+					//changes to the component or any of its layouts invalidate the wrapper
+					for _, dependency := range append([]*View{view}, view.ApplicableLayoutViews...) {
+						err = cache.DependsOn(args.Path, dependency.Path)
+						if err != nil {
+							return result, err
+						}
+					}
+
 					rawVirtualCode := createLayoutWrappedView(view)
+					sourceHash := wrappedSourceHash(rawVirtualCode)
 
-					compiledCode, err := compilerFunc(args.Path, []byte(rawVirtualCode))
-					if err != nil {
-						return result, err
+					//the wrapper's source is its layout chain, a wrapper cached for
+					//another chain is stale
+					if cachedContent := cache.GetContent(args.Path); cachedContent != nil {
+						contents = wrappedCacheContentJS(*cachedContent, sourceHash)
 					}
 
-					contents = &compiledCode.JSCode
-					cache.AddCache(args.Path, contents)
+					//cache miss
+					if contents == nil {
+						compiledCode, err := compilerFunc(args.Path, view.RelPath, []byte(rawVirtualCode))
+						if err != nil {
+							return result, err
+						}
+
+						contents = &compiledCode.JSCode
+						cacheContent := wrappedCacheContentPrefix + sourceHash + "\n" + compiledCode.JSCode
+						cache.AddCache(args.Path, &cacheContent)
+					}
 
 					result.ResolveDir = workingDir
 					result.Contents = contents
@@ -195,11 +408,35 @@ func wrappedComponentsPlugin(
 	}
 }
 
+// wrappedCacheContentPrefix starts the cached content of wrapped components. It's
+// followed by the hash of the wrapper source and the compiled JS on the next line
+const wrappedCacheContentPrefix = "//aviator-wrapped:"
+
+// wrappedSourceHash identifies the layout chain of a wrapped component source
+func wrappedSourceHash(source string) string {
+	hash := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(hash[:])
+}
+
+// wrappedCacheContentJS returns the compiled JS of cached wrapped component
+// content. nil is returned if it was compiled from another source
+func wrappedCacheContentJS(content string, sourceHash string) *string {
+	header := wrappedCacheContentPrefix + sourceHash + "\n"
+	if !strings.HasPrefix(content, header) {
+		return nil
+	}
+
+	js := content[len(header):]
+	return &js
+}
+
 // svelteComponentsPlugin handles .svelte files both inside the project and node_modules
 func svelteComponentsPlugin(
 	cache Cache,
 	workingDir string,
+	files viewsFS,
 	cssCache *sync.Map,
+	stylePreprocessor StylePreprocessor,
 	compilerFunc SvelteCompilerFunc,
 ) esbuild.Plugin {
 	return esbuild.Plugin{
@@ -210,7 +447,10 @@ func svelteComponentsPlugin(
 				func(args esbuild.OnResolveArgs) (result esbuild.OnResolveResult, err error) {
 					callerPath := filepath.Dir(args.Importer)
 					var absPath string
-					if callerPath == "." {
+					//aliased imports are resolved to absolute paths
+					if filepath.IsAbs(args.Path) {
+						absPath = args.Path
+					} else if callerPath == "." {
 						absPath = path.Join(args.ResolveDir, args.Path)
 					} else {
 						absPath, err = filepath.Abs(path.Join(callerPath, args.Path))
@@ -239,14 +479,29 @@ func svelteComponentsPlugin(
 					cachedContent := cache.GetContent(args.Path)
 					//cache miss
 					if cachedContent == nil {
-						rawCode, err := os.ReadFile(args.Path)
+						rawCode, err := files.ReadFile(args.Path)
 						if err != nil {
 							return result, err
 						}
+						//editors on Windows often save files with a BOM, which the
+						//compiler would treat as markup
+						rawCode = bytes.TrimPrefix(rawCode, utf8BOM)
 
 						newPath := utils.PathPascalCase(filepath.Base(args.Path))
 
-						compiledCode, err := compilerFunc(newPath, rawCode)
+						relPath, err := filepath.Rel(workingDir, args.Path)
+						if err != nil {
+							return result, err
+						}
+
+						//the preprocessed CSS is what ends up in the .fake-svelte-css
+						//file below
+						rawCode, err = preprocessStyles(relPath, rawCode, stylePreprocessor)
+						if err != nil {
+							return result, err
+						}
+
+						compiledCode, err := compilerFunc(newPath, relPath, rawCode)
 						if err != nil {
 							return result, err
 						}
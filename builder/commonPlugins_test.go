@@ -0,0 +1,332 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dop251/goja"
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTestEntry bundles entry with the given plugins and returns the output JS
+func buildTestEntry(t *testing.T, format esbuild.Format, entry string, plugins ...esbuild.Plugin) string {
+	result := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   entry,
+			ResolveDir: t.TempDir(),
+			Loader:     esbuild.LoaderJS,
+		},
+		Format:   format,
+		Bundle:   true,
+		LogLevel: esbuild.LogLevelSilent,
+		Plugins:  plugins,
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(newBuildError(result.Errors))
+	}
+
+	return string(result.OutputFiles[0].Contents)
+}
+
+func TestStoreInitializerPlugin(t *testing.T) {
+	workingDir := t.TempDir()
+
+	out := buildTestEntry(
+		t,
+		esbuild.FormatESModule,
+		`import initStores from "__aviator_store_initializer.js"; initStores({})`,
+		storeInitializerPlugin(workingDir, `export default function initStores() { console.log("seeded") }`),
+		npmJsPathPlugin(workingDir, viewsFS{}),
+	)
+
+	assert.Contains(t, out, `"seeded"`)
+}
+
+func TestExternalResolverPlugin(t *testing.T) {
+	workingDir := t.TempDir()
+	resolver := func(importPath string) (string, bool) {
+		if importPath == "canvas-confetti" {
+			return "https://cdn.example.com/canvas-confetti.js", true
+		}
+		return "", false
+	}
+	entry := `import confetti from "canvas-confetti"; export function celebrate() { confetti() }`
+
+	out := buildTestEntry(
+		t,
+		esbuild.FormatESModule,
+		entry,
+		externalResolverPlugin(resolver, false),
+		npmJsPathPlugin(workingDir, viewsFS{}),
+	)
+	assert.Contains(t, out, `from "https://cdn.example.com/canvas-confetti.js"`)
+
+	//the SSR bundle can't import at runtime, so the package is stubbed
+	out = buildTestEntry(
+		t,
+		esbuild.FormatIIFE,
+		entry,
+		externalResolverPlugin(resolver, true),
+		npmJsPathPlugin(workingDir, viewsFS{}),
+	)
+	assert.NotContains(t, out, "cdn.example.com")
+	assert.Contains(t, out, externalStubModule)
+	_, err := goja.New().RunString(out)
+	assert.NoError(t, err)
+}
+
+func TestImportAliasPlugin(t *testing.T) {
+	workingDir := t.TempDir()
+	sharedDir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(workingDir, "lib", "components", "Button.svelte"): `<button>from lib</button>`,
+		filepath.Join(workingDir, "lib", "utils.js"):                    `export const label = "from lib utils"`,
+		filepath.Join(sharedDir, "icons.js"):                            `export const icon = "from shared icons"`,
+	}
+	for name, source := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, os.WriteFile(name, []byte(source), 0644))
+	}
+
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+	compile := func(name string, relPath string, code []byte) (*SvelteBuildOutput, error) {
+		return &SvelteBuildOutput{JSCode: "export default " + strconv.Quote(string(code))}, nil
+	}
+	aliases := map[string]string{
+		"$lib": "lib",
+		//longer aliases win
+		"$lib/icons": filepath.Join(sharedDir, "icons.js"),
+	}
+
+	out := buildTestEntry(
+		t,
+		esbuild.FormatESModule,
+		`import Button from "$lib/components/Button.svelte"
+		import { label } from "$lib/utils"
+		import { icon } from "$lib/icons"
+		console.log(Button, label, icon)`,
+		importAliasPlugin(workingDir, aliases),
+		svelteComponentsPlugin(cache, workingDir, viewsFS{}, &sync.Map{}, nil, compile),
+		npmJsPathPlugin(workingDir, viewsFS{}),
+	)
+	assert.Contains(t, out, "<button>from lib</button>")
+	assert.Contains(t, out, `"from lib utils"`)
+	assert.Contains(t, out, `"from shared icons"`)
+
+	//prefixes only match whole path segments
+	result := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   `import x from "$library/x.js"`,
+			ResolveDir: workingDir,
+			Loader:     esbuild.LoaderJS,
+		},
+		Bundle:   true,
+		LogLevel: esbuild.LogLevelSilent,
+		Plugins:  []esbuild.Plugin{importAliasPlugin(workingDir, aliases)},
+	})
+	assert.NotEmpty(t, result.Errors)
+	assert.NotContains(t, newBuildError(result.Errors).Error(), filepath.Join(workingDir, "lib"))
+}
+
+func TestNpmJsPathPlugin_ViewsFS(t *testing.T) {
+	//relative imports of the entry resolve against the working directory
+	files := viewsFS{
+		root: ".",
+		fsys: fstest.MapFS{
+			"lib/message.js": {Data: []byte(`export default "from the embedded views"`)},
+		},
+	}
+
+	out := buildTestEntry(
+		t,
+		esbuild.FormatESModule,
+		`import message from "./lib/message.js"; console.log(message)`,
+		npmJsPathPlugin(".", files),
+	)
+
+	assert.Contains(t, out, `"from the embedded views"`)
+}
+
+func TestSvelteComponentsPlugin_BOM(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+	s := NewSSRBuilder(nopLogger{}, vm, cache, "", nil, BuildOptions{})
+
+	workingDir := t.TempDir()
+	source := []byte("\ufeff<h1>Hello</h1>")
+	assert.NoError(t, os.WriteFile(filepath.Join(workingDir, "Hello.svelte"), source, 0644))
+
+	var compiledCode []byte
+	compile := func(name string, relPath string, code []byte) (*SvelteBuildOutput, error) {
+		compiledCode = code
+		return s.ssrCompile(name, relPath, code)
+	}
+
+	result := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   `import Hello from "./Hello.svelte"; console.log(Hello)`,
+			ResolveDir: workingDir,
+			Loader:     esbuild.LoaderJS,
+		},
+		Bundle:   true,
+		LogLevel: esbuild.LogLevelSilent,
+		External: []string{"svelte/internal"},
+		Plugins: []esbuild.Plugin{
+			svelteComponentsPlugin(cache, workingDir, viewsFS{}, &sync.Map{}, nil, compile),
+		},
+	})
+	assert.Empty(t, result.Errors)
+
+	assert.Equal(t, []byte(`<h1>Hello</h1>`), compiledCode)
+	out := string(result.OutputFiles[0].Contents)
+	assert.Contains(t, out, "<h1>Hello</h1>")
+	assert.NotContains(t, out, "\ufeff")
+}
+
+func TestWrappedComponentsPlugin_Cache(t *testing.T) {
+	viewsDir := t.TempDir()
+	layoutPath := filepath.Join(viewsDir, "+layout.svelte")
+	for _, file := range []string{"+layout.svelte", "+layout-admin.svelte", "Index.svelte"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(viewsDir, file), []byte(`<slot></slot>`), 0644))
+	}
+
+	layout := &View{UniqueName: "Layout", RelPath: "+layout.svelte", Path: layoutPath}
+	adminLayout := &View{
+		UniqueName: "LayoutAdmin",
+		RelPath:    "+layout-admin.svelte",
+		Path:       filepath.Join(viewsDir, "+layout-admin.svelte"),
+	}
+	index := &View{
+		UniqueName:            "Index",
+		WrappedUniqueName:     "__AviatorWrapped_Index",
+		RelPath:               "Index.svelte",
+		Path:                  filepath.Join(viewsDir, "Index.svelte"),
+		ApplicableLayoutViews: []*View{layout},
+	}
+
+	wrappedCompiles := 0
+	compile := func(name string, relPath string, code []byte) (*SvelteBuildOutput, error) {
+		source := string(code)
+		if !strings.HasPrefix(name, "__AviatorWrapped_") {
+			return &SvelteBuildOutput{JSCode: "export default 1"}, nil
+		}
+		wrappedCompiles++
+		//keep the imports of the layouts and the component. Unused imports would
+		//be dropped by the TSX loader
+		imports := strings.TrimPrefix(source[:strings.Index(source, "</script>")], "<script>")
+		var names []string
+		for _, match := range regexp.MustCompile(`import (\w+) from`).FindAllStringSubmatch(imports, -1) {
+			names = append(names, match[1])
+		}
+		return &SvelteBuildOutput{JSCode: imports + "\nexport default [" + strings.Join(names, ",") + "]"}, nil
+	}
+
+	cache := newMemoryCacheManager(CacheTypeSSR, viewsFS{})
+	build := func() {
+		result := esbuild.Build(esbuild.BuildOptions{
+			Stdin: &esbuild.StdinOptions{
+				Contents:   `import Index from "__AviatorWrapped_Index.svelte"; console.log(Index)`,
+				ResolveDir: viewsDir,
+				Loader:     esbuild.LoaderJS,
+			},
+			Bundle:   true,
+			LogLevel: esbuild.LogLevelSilent,
+			Plugins: []esbuild.Plugin{
+				wrappedComponentsPlugin(cache, viewsDir, []*View{index}, compile),
+				svelteComponentsPlugin(cache, viewsDir, viewsFS{}, &sync.Map{}, nil, compile),
+			},
+		})
+		assert.Empty(t, result.Errors)
+		cache.Finished()
+	}
+
+	build()
+	assert.Equal(t, 1, wrappedCompiles)
+
+	//unchanged wrappers aren't compiled again
+	build()
+	assert.Equal(t, 1, wrappedCompiles)
+
+	//a changed layout invalidates the wrapper
+	assert.NoError(t, os.WriteFile(layoutPath, []byte(`<main><slot></slot></main>`), 0644))
+	assert.NoError(t, cache.Invalidate(layoutPath))
+	build()
+	assert.Equal(t, 2, wrappedCompiles)
+
+	//so does a changed layout chain
+	index.ApplicableLayoutViews = []*View{adminLayout, layout}
+	build()
+	assert.Equal(t, 3, wrappedCompiles)
+	build()
+	assert.Equal(t, 3, wrappedCompiles)
+}
+
+func TestCreateLayoutWrappedView_LayoutProps(t *testing.T) {
+	view := &View{
+		UniqueName: "Index",
+		RelPath:    "Index.svelte",
+		ApplicableLayoutViews: []*View{
+			{UniqueName: "Layout", RelPath: "+layout.svelte"},
+		},
+	}
+	wrapped := createLayoutWrappedView(view)
+	assert.Contains(t, wrapped, `<svelte:component this={Layout} {...layoutProps($$props)}>`)
+	assert.Contains(t, wrapped, `<svelte:component this={Index} {...pageProps($$props)}/>`)
+
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	s := NewSSRBuilder(nopLogger{}, newGojaTestVM(t, string(compilerCode)), nil, "", nil, BuildOptions{})
+	_, err = s.ssrCompile("__AviatorWrapped_Index.svelte", "Index.svelte", []byte(wrapped))
+	assert.NoError(t, err)
+
+	//layouts only get the layout props when they're set, the page never does
+	runtime := goja.New()
+	_, err = runtime.RunString(wrappedPropsFuncs)
+	assert.NoError(t, err)
+	split, err := runtime.RunString(`JSON.stringify([
+		layoutProps({ title: "Page", __aviator_layout_props: { title: "Site" } }),
+		pageProps({ title: "Page", __aviator_layout_props: { title: "Site" } }),
+		layoutProps({ title: "Page" }),
+		pageProps({ title: "Page" }),
+	])`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"title":"Site"},{"title":"Page"},{"title":"Page"},{"title":"Page"}]`, split.String())
+}
+
+func TestCreateLayoutWrappedView_Nesting(t *testing.T) {
+	view := &View{
+		UniqueName: "AdminIndex",
+		RelPath:    filepath.Join("admin", "Index.svelte"),
+		//the nearest layout is first
+		ApplicableLayoutViews: []*View{
+			{UniqueName: "AdminLayout", RelPath: filepath.Join("admin", "+layout.svelte")},
+			{UniqueName: "Layout", RelPath: "+layout.svelte"},
+		},
+	}
+	wrapped := createLayoutWrappedView(view)
+
+	//the root layout is the outermost element, the page the innermost
+	markup := wrapped[strings.Index(wrapped, "</script>")+len("</script>"):]
+	assert.Equal(t, `<svelte:component this={Layout} {...layoutProps($$props)}>`+
+		`<svelte:component this={AdminLayout} {...layoutProps($$props)}>`+
+		`<svelte:component this={AdminIndex} {...pageProps($$props)}/>`+
+		`</svelte:component>`+
+		`</svelte:component>`, strings.TrimSpace(markup))
+
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	s := NewSSRBuilder(nopLogger{}, newGojaTestVM(t, string(compilerCode)), nil, "", nil, BuildOptions{})
+	_, err = s.ssrCompile("__AviatorWrapped_AdminIndex.svelte", view.RelPath, []byte(wrapped))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// componentMetaExtension is the extension of the metadata file next to a
+// component, i.e. Foo.meta.json for Foo.svelte
+const componentMetaExtension = ".meta.json"
+
+// ComponentMeta is the per-view configuration read from the optional
+// .meta.json file next to a component
+type ComponentMeta struct {
+	//Entrypoint overrides whether the view is an entrypoint, which otherwise
+	//depends on the first letter of its file name
+	Entrypoint *bool `json:"entrypoint,omitempty"`
+
+	//StaticOnly views are rendered without their JS and the props script, so
+	//the page is never hydrated
+	StaticOnly bool `json:"staticOnly,omitempty"`
+
+	//CacheVary adds render context keys to the dimensions RenderCacheKey
+	//varies on, on top of those of WithCacheVary
+	CacheVary []string `json:"cacheVary,omitempty"`
+}
+
+// componentMetaPath returns the path of the metadata file of the component at
+// componentPath
+func componentMetaPath(componentPath string) string {
+	return strings.TrimSuffix(componentPath, ".svelte") + componentMetaExtension
+}
+
+// isComponentMetaFile reports whether path is a component metadata file
+func isComponentMetaFile(path string) bool {
+	return strings.HasSuffix(path, componentMetaExtension)
+}
+
+// readComponentMeta reads the metadata file of the component at componentPath.
+// nil is returned if the component has none
+func readComponentMeta(files viewsFS, componentPath string) (*ComponentMeta, error) {
+	metaPath := componentMetaPath(componentPath)
+	content, err := files.ReadFile(metaPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &ComponentMeta{}
+	err = json.Unmarshal(content, meta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid component metadata file %s: %w", metaPath, err)
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComponentTree_ComponentMeta(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"About.svelte":      ``,
+		"About.meta.json":   `{"staticOnly": true, "cacheVary": ["theme"]}`,
+		"widget.svelte":     ``,
+		"widget.meta.json":  `{"entrypoint": true}`,
+		"Sidebar.svelte":    ``,
+		"Sidebar.meta.json": `{"entrypoint": false}`,
+		"Index.svelte":      ``,
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+	assert.Nil(t, tree.Components["Index"].Meta)
+
+	about := newViewFromComponent(tree.Components["About"])
+	assert.True(t, about.IsEntrypoint)
+	assert.True(t, about.StaticOnly)
+	assert.Equal(t, []string{"theme"}, about.CacheVary)
+
+	//the metadata overrides the casing of the file name
+	assert.True(t, newViewFromComponent(tree.Components["widget"]).IsEntrypoint)
+	assert.False(t, newViewFromComponent(tree.Components["Sidebar"]).IsEntrypoint)
+
+	//changes are picked up by rescans
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "About.meta.json"), []byte(`{}`), 0644))
+	assert.NoError(t, tree.ReScan())
+	assert.False(t, newViewFromComponent(tree.Components["About"]).StaticOnly)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "About.meta.json"), []byte(`{"staticOnly": `), 0644))
+	err = tree.ReScan()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "About.meta.json")
+}
+
+func TestViewManager_StaticOnlyView(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "Index.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "Index.meta.json"), []byte(`{"staticOnly": true}`), 0644))
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+	view := newViewFromComponent(tree.Components["Index"])
+
+	//the build leaves out the JS of the view but keeps its styles
+	b := NewBrowserBuilder(nopLogger{}, nil, nil, root, nil, BuildOptions{})
+	assets := b.outputAssets([]*View{view}, map[string]*View{"Index.svelte": view}, []esbuild.OutputFile{
+		{Path: "/Index.svelte.js", Contents: []byte(`"index"`)},
+		{Path: "/Index.svelte.css", Contents: []byte(`h1{}`)},
+	})
+	assert.Empty(t, view.JSImports)
+	assert.Equal(t, []string{"Index.svelte.css"}, view.CSSImports)
+	assert.NotContains(t, assets, "Index.svelte.js")
+	assert.Contains(t, assets, "Index.svelte.css")
+
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	v.views[view.RelPath] = view
+	v.staticContent = assets
+	v.cacheStaticHeadTags()
+
+	out, err := v.Render(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<h1>Hello</h1>")
+	assert.Contains(t, out, `<link href="/static/Index.svelte.css" rel="stylesheet">`)
+	assert.NotContains(t, out, "<script")
+}
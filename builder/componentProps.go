@@ -0,0 +1,175 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// PropInfo describes a prop a component declares with `export let`
+type PropInfo struct {
+	Name string
+	//Type is the TypeScript type annotation of the prop, if any
+	Type string
+	//Default is the source of the default value expression, if any
+	Default    string
+	HasDefault bool
+}
+
+// ComponentProps returns the props the view declares with `export let`, in
+// declaration order. Props are found by scanning the instance script, so
+// props declared through $$props or $$restProps aren't included
+func (v *ViewManager) ComponentProps(viewPath string) ([]PropInfo, error) {
+	view := v.ViewByRelPath(viewPath)
+	if view == nil {
+		return nil, fmt.Errorf("view does not exist in path %s", viewPath)
+	}
+
+	source, err := v.files.ReadFile(view.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source of view %s: %w", viewPath, err)
+	}
+
+	return parseComponentProps(string(source)), nil
+}
+
+var scriptTagRegexp = regexp.MustCompile(`(?s)<script([^>]*)>(.*?)</script>`)
+var moduleContextRegexp = regexp.MustCompile(`context\s*=\s*["']module["']`)
+var scriptCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/|(^|[^:])//[^\n]*`)
+var exportLetRegexp = regexp.MustCompile(`(^|[\s;{}])export\s+let\s`)
+
+// parseComponentProps finds the `export let` declarations of the instance script
+// in a svelte component's source
+func parseComponentProps(source string) []PropInfo {
+	var props []PropInfo
+	for _, script := range scriptTagRegexp.FindAllStringSubmatch(source, -1) {
+		if moduleContextRegexp.MatchString(script[1]) {
+			continue
+		}
+
+		code := scriptCommentRegexp.ReplaceAllString(script[2], "$1")
+		for _, loc := range exportLetRegexp.FindAllStringIndex(code, -1) {
+			props = append(props, parseDeclarators(code[loc[1]:])...)
+		}
+	}
+
+	return props
+}
+
+// parseDeclarators parses the comma separated declarators following `let`, i.e.
+// `a, b: string = "b";`
+func parseDeclarators(code string) []PropInfo {
+	var props []PropInfo
+	for {
+		code = strings.TrimLeftFunc(code, unicode.IsSpace)
+
+		nameEnd := strings.IndexFunc(code, func(r rune) bool {
+			return !(r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r))
+		})
+		if nameEnd == -1 {
+			nameEnd = len(code)
+		}
+		if nameEnd == 0 {
+			return props
+		}
+		prop := PropInfo{Name: code[:nameEnd]}
+		code = strings.TrimLeft(code[nameEnd:], " \t")
+
+		var end byte
+		if strings.HasPrefix(code, ":") {
+			prop.Type, end, code = scanExpression(code[1:], true)
+		} else {
+			end, code = peekEnd(code)
+		}
+		if end == '=' {
+			prop.Default, end, code = scanExpression(code[1:], false)
+			prop.HasDefault = true
+		}
+		props = append(props, prop)
+
+		if end != ',' {
+			return props
+		}
+		code = code[1:]
+	}
+}
+
+// peekEnd returns the character ending a declarator without an annotation
+func peekEnd(code string) (byte, string) {
+	if len(code) == 0 {
+		return 0, code
+	}
+	switch code[0] {
+	case '=', ',':
+		return code[0], code
+	}
+	return 0, code
+}
+
+// scanExpression reads an expression until a top level `,`, `;` or the end of a
+// statement. Type annotations also end at a top level `=`. It returns the
+// trimmed expression, the character that ended it and the code starting at it
+func scanExpression(code string, isType bool) (string, byte, string) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'', '`':
+			quote = c
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				return strings.TrimSpace(code[:i]), 0, code[i:]
+			}
+			depth--
+		case '<':
+			if isType {
+				depth++
+			}
+		case '>':
+			if isType && depth > 0 && code[i-1] != '=' {
+				depth--
+			}
+		case ',', ';':
+			if depth == 0 {
+				return strings.TrimSpace(code[:i]), c, code[i:]
+			}
+		case '=':
+			//=> is part of a function type
+			if isType && depth == 0 && (i+1 >= len(code) || code[i+1] != '>') {
+				return strings.TrimSpace(code[:i]), c, code[i:]
+			}
+		case '\n':
+			//without semicolons, a line that isn't continued ends the statement
+			expr := strings.TrimSpace(code[:i])
+			if depth == 0 && len(expr) > 0 && !continuesOnNextLine(expr, code[i:]) {
+				return expr, 0, code[i:]
+			}
+		}
+	}
+
+	return strings.TrimSpace(code), 0, ""
+}
+
+// continuesOnNextLine reports whether an expression ending with expr continues
+// on the next line of rest
+func continuesOnNextLine(expr string, rest string) bool {
+	if strings.ContainsRune("+-*/%&|^!~?:=<>,.(", rune(expr[len(expr)-1])) {
+		return true
+	}
+
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	return len(rest) > 0 && strings.ContainsRune("+-*/%&|^?:.=<>", rune(rest[0]))
+}
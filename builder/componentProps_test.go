@@ -0,0 +1,64 @@
+package builder
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const propsComponentSource = `<script context="module">
+    export let moduleValue = 1
+</script>
+
+<script lang="ts">
+    import { onMount } from "svelte"
+
+    export let title: string
+    export let count = 0;
+    // export let commented = true
+    export let items: Array<{ id: number }> = [{ id: 1 }, { id: 2 }]
+    export let onSelect = (item) => {
+        console.log(item)
+    }
+    export let a, b = "b, c";
+    export let url = "https://example.com"
+    let internal = 2
+</script>
+
+<h1>{title}</h1>
+`
+
+func TestParseComponentProps(t *testing.T) {
+	props := parseComponentProps(propsComponentSource)
+
+	assert.Equal(t, []PropInfo{
+		{Name: "title", Type: "string"},
+		{Name: "count", Default: "0", HasDefault: true},
+		{Name: "items", Type: "Array<{ id: number }>", Default: "[{ id: 1 }, { id: 2 }]", HasDefault: true},
+		{Name: "onSelect", Default: "(item) => {\n        console.log(item)\n    }", HasDefault: true},
+		{Name: "a"},
+		{Name: "b", Default: `"b, c"`, HasDefault: true},
+		{Name: "url", Default: `"https://example.com"`, HasDefault: true},
+	}, props)
+}
+
+func TestViewManager_ComponentProps(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	viewsDir := filepath.Join(t.TempDir(), "views")
+	view.Path = filepath.Join(viewsDir, view.RelPath)
+	v.files = viewsFS{
+		root: viewsDir,
+		fsys: fstest.MapFS{
+			"Index.svelte": {Data: []byte(`<script>export let name = "world"</script><h1>Hello {name}</h1>`)},
+		},
+	}
+
+	props, err := v.ComponentProps("Index.svelte")
+	assert.NoError(t, err)
+	assert.Equal(t, []PropInfo{{Name: "name", Default: `"world"`, HasDefault: true}}, props)
+
+	_, err = v.ComponentProps("Missing.svelte")
+	assert.Error(t, err)
+}
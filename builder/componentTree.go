@@ -1,13 +1,18 @@
 package builder
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/mansoor-s/aviator/utils"
+	"golang.org/x/sync/errgroup"
 )
 
 //for every component determine the appropriate __layout file
@@ -28,10 +33,27 @@ Determining the right __layout file:
 			if not found, walk up to all the component's parent directories to search for __layout
 
 	if no __layout files are found, the default layout file with <slot></slot> is used
+
+	+layout-reset.svelte is a directory's +layout that resets the hierarchy: its
+	directory becomes the root for layout lookups. Neither it nor the layouts and
+	components in its subdirectories are wrapped by layouts of parent directories,
+	including named ones. "reset" can't be used as a layout name
+
+	+error.svelte is rendered in place of a component that throws during SSR. The
+	nearest one is used, walking up the parent directories like layouts
 */
 
 const npmDir = "node_modules"
 
+// errorComponentName is the name of +error.svelte error boundary components
+const errorComponentName = "+error"
+
+// resetLayoutName is the name in +layout-reset.svelte, which is the directory's
+// +layout without any of the ancestor layouts
+const resetLayoutName = "reset"
+
+var errResetLayoutName = errors.New(`"reset" is reserved for +layout-reset.svelte and can't be used as a layout name`)
+
 type Layout struct {
 	Name string
 
@@ -41,7 +63,6 @@ type Layout struct {
 	// nil if no parent layout exists
 	ParentLayout *Layout
 
-	// TODO: implement layout resets
 	//if layout is a reset layout, don't inherit parent layout
 	isAResetLayout bool
 
@@ -88,6 +109,12 @@ type Component struct {
 	//used temporarily until the layout Path can be resolved
 	layoutName string
 
+	//isErrorComponent is true for +error.svelte
+	isErrorComponent bool
+
+	//Meta is read from the component's .meta.json file. nil if it has none
+	Meta *ComponentMeta
+
 	rootTree *componentTree
 }
 
@@ -97,6 +124,17 @@ func (c *Component) RelativePath() string {
 	return relPath
 }
 
+// ErrorComponent returns the +error.svelte component rendered when this one
+// throws: the one in its directory or else the nearest one in its ancestors.
+// Returns nil if there's none or the component is an error component itself
+func (c *Component) ErrorComponent() *Component {
+	if c.isErrorComponent {
+		return nil
+	}
+
+	return c.ParentTree.resolveErrorComponent()
+}
+
 // ApplicableLayouts returns a flattened layout hierarchy of layouts applicable
 // to this component. Layout index determines its proximity to the component
 // in the hierarchy
@@ -118,12 +156,82 @@ type ComponentTree interface {
 	GetAllComponents() []*Component
 	GetAllLayouts() []*Layout
 	GetAllDescendantPaths() []string
+	Warnings() []string
+}
+
+// TreeOption configures how a component tree scans the views directory
+type TreeOption func(*treeConfig)
+
+type treeConfig struct {
+	//maxDepth is the deepest directory level that is scanned. 0 means no limit
+	maxDepth int
+
+	fsys  fs.FS
+	files viewsFS
+
+	//ignorePatterns are matched along with the patterns of the .aviatorignore file
+	ignorePatterns []string
+	ignore         *ignoreMatcher
+
+	//scanSlots bounds how many directories of the whole tree are read and
+	//scanned at once
+	scanSlots chan struct{}
+}
+
+// WithMaxDepth stops scanning directories nested deeper than maxDepth levels
+// below the views directory. 0 means no limit
+func WithMaxDepth(maxDepth int) TreeOption {
+	return func(c *treeConfig) {
+		c.maxDepth = maxDepth
+	}
+}
+
+// WithFS scans fsys instead of the OS filesystem. fsys is treated as the
+// contents of the views directory
+func WithFS(fsys fs.FS) TreeOption {
+	return func(c *treeConfig) {
+		c.fsys = fsys
+	}
+}
+
+// wrappedNamePrefix prefixes the unique names of the generated components
+// wrapping views with their layouts
+const wrappedNamePrefix = "__AviatorWrapped_"
+
+// reservedNamePrefix is the prefix, in any case, of the virtual files the
+// builder plugins resolve by name, i.e. __aviator_ssr.js or
+// __AviatorWrapped_Index.svelte. Views can't start with it, their imports
+// would be resolved to the virtual files
+const reservedNamePrefix = "__aviator"
+
+// ErrReservedViewName is returned when scanning a view or directory whose name
+// starts with the prefix of the virtual files generated by Aviator
+var ErrReservedViewName = errors.New("names starting with " + reservedNamePrefix + " are reserved")
+
+// checkReservedName fails for names that could be mistaken for virtual files
+func checkReservedName(path string) error {
+	if strings.HasPrefix(strings.ToLower(filepath.Base(path)), reservedNamePrefix) {
+		return fmt.Errorf("%w, rename %s", ErrReservedViewName, path)
+	}
+	return nil
+}
+
+// WithIgnore excludes the files and directories matching gitignore style
+// patterns from scanning, i.e. "*.stories.svelte" or "__tests__/". Patterns
+// in a .aviatorignore file at the root of the views directory apply as well
+func WithIgnore(patterns ...string) TreeOption {
+	return func(c *treeConfig) {
+		c.ignorePatterns = append(c.ignorePatterns, patterns...)
+	}
 }
 
 type componentTree struct {
 	//absolute path
 	path string
 
+	//depth is the directory level relative to the root tree. The root tree is 0
+	depth int
+
 	Components map[string]*Component //[]*Component
 	Layouts    map[string]*Layout
 	Children   map[string]*componentTree //[]*componentTree
@@ -131,17 +239,39 @@ type componentTree struct {
 	Parent *componentTree
 
 	rootTree *componentTree
+
+	//config and warnings are only set on the root tree. Child trees are scanned
+	//concurrently, so warnings are guarded by warningsLock
+	config       *treeConfig
+	warnings     map[string]string
+	warningsLock sync.Mutex
 }
 
 // CreateComponentTree creates a componentTree based on the absolute Path
 // it performs a depth-first search through all subdirectories under
 // the specified Path
-func CreateComponentTree(path string) (*componentTree, error) {
-	return createComponentTree(nil, path)
+func CreateComponentTree(path string, opts ...TreeOption) (*componentTree, error) {
+	config := &treeConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	//tree paths are compared against filepath.Dir of changed files
+	path = filepath.Clean(path)
+	config.files = viewsFS{root: path, fsys: config.fsys}
+	config.scanSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
 
+	config.ignore = &ignoreMatcher{}
+	err := config.ignore.readIgnoreFile(config.files, path)
+	if err != nil {
+		return nil, err
+	}
+	config.ignore.addPatterns(config.ignorePatterns...)
+
+	return createComponentTree(nil, path, config)
 }
 
-func createComponentTree(parentTree *componentTree, path string) (*componentTree, error) {
+func createComponentTree(parentTree *componentTree, path string, config *treeConfig) (*componentTree, error) {
 	tree := &componentTree{
 		path:       path,
 		Parent:     parentTree,
@@ -152,8 +282,11 @@ func createComponentTree(parentTree *componentTree, path string) (*componentTree
 
 	if parentTree != nil {
 		tree.rootTree = tree.Parent.rootTree
+		tree.depth = parentTree.depth + 1
 	} else {
 		tree.rootTree = tree
+		tree.config = config
+		tree.warnings = map[string]string{}
 	}
 
 	err := tree.ReScan()
@@ -168,37 +301,83 @@ func createComponentTree(parentTree *componentTree, path string) (*componentTree
 // starting only at the directory depth associated with this tree
 // ReScan will NOT walk down to child trees
 func (c *componentTree) ReScan() error {
-	// first find all +layouts
-	err := c.findLayouts()
+	entries, err := c.scanDir()
+	if err != nil {
+		return err
+	}
+
+	//walk through child directories to find layouts and components. The scan
+	//slot is released by now, so waiting on the children can't starve them
+	err = c.findChildTrees(entries)
 	if err != nil {
 		return err
 	}
 
+	return nil
+}
+
+// scanDir finds the layouts and components of this tree level while holding
+// one of the scan slots of the root tree. It returns the entries of the
+// directory, which is read once for its layouts, components and child trees
+func (c *componentTree) scanDir() ([]fs.DirEntry, error) {
+	scanSlots := c.rootTree.config.scanSlots
+	scanSlots <- struct{}{}
+	defer func() {
+		<-scanSlots
+	}()
+
+	entries, err := c.rootTree.config.files.ReadDir(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// first find all +layouts
+	err = c.findLayouts(entries)
+	if err != nil {
+		return nil, err
+	}
+
 	//resolve +layout parents if any
 	c.resolveLayoutParents()
 
 	// find all component at current Path
-	err = c.findComponents()
+	err = c.findComponents(entries)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// resolve layouts for components
 	c.resolveComponentLayouts()
 
-	//walk through child directories to find layouts and components
-	err = c.findChildTrees()
+	return entries, nil
+}
+
+// isIgnored reports whether the entry at path matches the ignore patterns
+func (c *componentTree) isIgnored(path string, isDir bool) bool {
+	relPath, err := filepath.Rel(c.rootTree.path, path)
 	if err != nil {
-		return err
+		return false
 	}
-
-	return nil
+	return c.rootTree.config.ignore.isIgnored(relPath, isDir)
 }
 
 func (c *componentTree) Path() string {
 	return c.path
 }
 
+// Warnings returns non-fatal problems encountered while scanning
+func (c *componentTree) Warnings() []string {
+	c.rootTree.warningsLock.Lock()
+	var warnings []string
+	for _, warning := range c.rootTree.warnings {
+		warnings = append(warnings, warning)
+	}
+	c.rootTree.warningsLock.Unlock()
+	sort.Strings(warnings)
+
+	return warnings
+}
+
 // GetAllComponents returns all components at this tree level and child levels
 func (c *componentTree) GetAllComponents() []*Component {
 	var components []*Component
@@ -263,15 +442,16 @@ func (c *componentTree) GetAllDescendentTrees() map[string]*componentTree {
 }
 
 // findChildTrees walks through all child directories and recursively
-// creates a componentTree for each if one doesn't exist
-func (c *componentTree) findChildTrees() error {
-	dirs, err := os.ReadDir(c.path)
-	if err != nil {
-		return err
-	}
-
+// creates a componentTree for each if one doesn't exist. New child trees are
+// scanned concurrently, but across the whole tree at most one directory per
+// CPU is read and scanned at a time
+func (c *componentTree) findChildTrees(dirs []fs.DirEntry) error {
 	childDirsInPath := map[string]struct{}{}
 
+	var newChildren []*componentTree
+	var newChildrenLock sync.Mutex
+	group := errgroup.Group{}
+
 	for _, dir := range dirs {
 		if !dir.IsDir() {
 			continue
@@ -283,6 +463,26 @@ func (c *componentTree) findChildTrees() error {
 		}
 
 		childPath := filepath.Join(c.path, dir.Name())
+		if c.isIgnored(childPath, true) {
+			continue
+		}
+		err := checkReservedName(childPath)
+		if err != nil {
+			return err
+		}
+
+		maxDepth := c.rootTree.config.maxDepth
+		if maxDepth > 0 && c.depth+1 > maxDepth {
+			c.rootTree.warningsLock.Lock()
+			c.rootTree.warnings[childPath] = fmt.Sprintf(
+				`skipped scanning "%s" because it is deeper than the max scan depth of %d`,
+				childPath,
+				maxDepth,
+			)
+			c.rootTree.warningsLock.Unlock()
+			continue
+		}
+
 		childDirsInPath[childPath] = struct{}{}
 
 		//skip if child already exists
@@ -291,11 +491,26 @@ func (c *componentTree) findChildTrees() error {
 			continue
 		}
 
-		child, err := createComponentTree(c, childPath)
-		if err != nil {
-			return err
-		}
-		c.Children[childPath] = child
+		//a child only writes to its own maps while scanning, it's added to the
+		//children of c once all of them are done
+		group.Go(func() error {
+			child, err := createComponentTree(c, childPath, nil)
+			if err != nil {
+				return err
+			}
+			newChildrenLock.Lock()
+			newChildren = append(newChildren, child)
+			newChildrenLock.Unlock()
+			return nil
+		})
+	}
+
+	err := group.Wait()
+	if err != nil {
+		return err
+	}
+	for _, child := range newChildren {
+		c.Children[child.path] = child
 	}
 
 	//remove child trees that have been removed on the FS
@@ -324,12 +539,7 @@ var svelteFileRegexp = regexp.MustCompile(`.*\.svelte$`)
 var svelteLayoutRegexp = regexp.MustCompile(`\+layout.*\.svelte$`)
 
 // finds all component files in current tree level (aka directory depth)
-func (c *componentTree) findComponents() error {
-	files, err := os.ReadDir(c.path)
-	if err != nil {
-		return err
-	}
-
+func (c *componentTree) findComponents(files []fs.DirEntry) error {
 	componentsInDir := make(map[string]struct{})
 
 	for _, file := range files {
@@ -347,36 +557,58 @@ func (c *componentTree) findComponents() error {
 			continue
 		}
 
-		componentName, layoutName := getComponentWithLayoutName(file.Name())
-		//skip if it was already added
-		_, ok := c.Components[componentName]
-		if ok {
+		componentPath := filepath.Join(c.path, file.Name())
+		if c.isIgnored(componentPath, false) {
 			continue
 		}
+		err := checkReservedName(componentPath)
+		if err != nil {
+			return err
+		}
 
+		componentName, layoutName := getComponentWithLayoutName(file.Name())
+		if layoutName == resetLayoutName {
+			return fmt.Errorf("%s: %w", componentPath, errResetLayoutName)
+		}
 		componentsInDir[componentName] = struct{}{}
+
+		meta, err := readComponentMeta(c.rootTree.config.files, componentPath)
+		if err != nil {
+			return err
+		}
+
+		//skip if it was already added, the metadata file may have changed though
+		existing, ok := c.Components[componentName]
+		if ok && existing.Path == componentPath {
+			existing.Meta = meta
+			continue
+		}
+
 		c.Components[componentName] = &Component{
-			Name:       utils.PascalCase(componentName),
-			Path:       filepath.Join(c.path, file.Name()),
-			layoutName: layoutName,
-			ParentTree: c,
-			rootTree:   c.rootTree,
+			Name:             utils.PascalCase(componentName),
+			Path:             componentPath,
+			layoutName:       layoutName,
+			isErrorComponent: componentName == errorComponentName,
+			Meta:             meta,
+			ParentTree:       c,
+			rootTree:         c.rootTree,
 		}
 	}
 
-	//TODO: remove stale components that are no longer in the FS
+	//remove stale components that are no longer in the FS
+	for componentName := range c.Components {
+		if _, ok := componentsInDir[componentName]; !ok {
+			delete(c.Components, componentName)
+		}
+	}
 
 	return nil
 }
 
 // finds all +layout files in current tree level (aka directory depth)
-func (c *componentTree) findLayouts() error {
-	files, err := os.ReadDir(c.path)
-	if err != nil {
-		return err
-	}
-
-	layoutsInDir := make(map[string]struct{})
+func (c *componentTree) findLayouts(files []fs.DirEntry) error {
+	//file name of each layout in the dir, by layout name
+	layoutsInDir := make(map[string]string)
 
 	for _, file := range files {
 		if file.IsDir() {
@@ -386,19 +618,43 @@ func (c *componentTree) findLayouts() error {
 		if !isMatch {
 			continue
 		}
+		if c.isIgnored(filepath.Join(c.path, file.Name()), false) {
+			continue
+		}
+		err := checkReservedName(filepath.Join(c.path, file.Name()))
+		if err != nil {
+			return err
+		}
 
 		layoutName, layoutParent := getLayoutInfo(file.Name())
+		isReset := layoutName == resetLayoutName
+		if layoutParent == resetLayoutName || (isReset && len(layoutParent) > 0) {
+			return fmt.Errorf("%s: %w", filepath.Join(c.path, file.Name()), errResetLayoutName)
+		}
+		if isReset {
+			layoutName = "+layout"
+		}
+
+		//i.e. +layout.svelte and +layout@.svelte are both named +layout
+		if existingFile, ok := layoutsInDir[layoutName]; ok {
+			return fmt.Errorf(
+				"duplicate layout %q in %s: both %s and %s define it",
+				layoutName, c.path, existingFile, file.Name(),
+			)
+		}
+		layoutsInDir[layoutName] = file.Name()
+
+		layoutPath := filepath.Join(c.path, file.Name())
 		//if layout already exists, skip it
-		_, ok := c.Layouts[layoutName]
-		if ok {
+		existing, ok := c.Layouts[layoutName]
+		if ok && existing.Path == layoutPath {
 			continue
 		}
 
-		layoutsInDir[layoutName] = struct{}{}
-
 		c.Layouts[layoutName] = &Layout{
 			Name:             layoutName,
-			Path:             filepath.Join(c.path, file.Name()),
+			Path:             layoutPath,
+			isAResetLayout:   isReset,
 			parentLayoutName: layoutParent,
 			ParentTree:       c,
 			rootTree:         c.rootTree,
@@ -406,12 +662,11 @@ func (c *componentTree) findLayouts() error {
 	}
 
 	//remove stale layouts that are no longer in the FS
-	// TODO:
-	/*for _, layout := range c.Layouts {
-		if _, ok := layoutsInDir[layout.Name]; !ok {
-			delete(c.Layouts, layout.Name)
+	for layoutName := range c.Layouts {
+		if _, ok := layoutsInDir[layoutName]; !ok {
+			delete(c.Layouts, layoutName)
 		}
-	}*/
+	}
 
 	return nil
 }
@@ -461,13 +716,35 @@ func (c *componentTree) ResolveLayoutByName(name string) *Layout {
 		return layout
 	}
 
-	if c.Parent != nil {
+	//layouts above a reset layout don't apply
+	if c.Parent != nil && !c.isResetDir() {
 		return c.Parent.ResolveLayoutByName(name)
 	}
 
 	return nil
 }
 
+// isResetDir reports whether the +layout of this tree level is a reset layout
+func (c *componentTree) isResetDir() bool {
+	layout, ok := c.Layouts["+layout"]
+	return ok && layout.isAResetLayout
+}
+
+// resolveErrorComponent finds the +error.svelte of this tree level or of the
+// nearest ancestor that has one
+func (c *componentTree) resolveErrorComponent() *Component {
+	component, ok := c.Components[errorComponentName]
+	if ok {
+		return component
+	}
+
+	if c.Parent != nil {
+		return c.Parent.resolveErrorComponent()
+	}
+
+	return nil
+}
+
 func (c *componentTree) resolveLayoutParents() {
 	for _, layout := range c.Layouts {
 		if len(layout.parentLayoutName) == 0 {
@@ -491,5 +768,27 @@ func (c *componentTree) RescanDir(path string) error {
 		)
 	}
 
-	return parentTree.ReScan()
+	err := parentTree.ReScan()
+	if err != nil {
+		return err
+	}
+
+	//existing child trees aren't rescanned, but may use a layout of the
+	//rescanned directory that was removed or replaced
+	for _, child := range parentTree.Children {
+		child.resolveAllLayouts()
+	}
+
+	return nil
+}
+
+// resolveAllLayouts resolves the layout parents and component layouts of this
+// tree level and all child levels again
+func (c *componentTree) resolveAllLayouts() {
+	c.resolveLayoutParents()
+	c.resolveComponentLayouts()
+
+	for _, child := range c.Children {
+		child.resolveAllLayouts()
+	}
 }
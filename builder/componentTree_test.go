@@ -1,9 +1,16 @@
 package builder
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"runtime"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestGetLayoutName(t *testing.T) {
@@ -102,3 +109,354 @@ func TestComponent_RelativePath(t *testing.T) {
 	assert.Equal(t, testComponent2RelPath, testComponent2.RelativePath())
 	assert.Equal(t, testComponent3RelPath, testComponent3.RelativePath())
 }
+
+func TestCreateComponentTree_MaxDepth(t *testing.T) {
+	root := t.TempDir()
+	deepestDir := filepath.Join(root, "one", "two", "three")
+	assert.NoError(t, os.MkdirAll(deepestDir, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "one", "one.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(deepestDir, "three.svelte"), nil, 0644))
+
+	tree, err := CreateComponentTree(root, WithMaxDepth(2))
+	assert.NoError(t, err)
+
+	paths := tree.GetAllDescendantPaths()
+	assert.Contains(t, paths, filepath.Join(root, "one", "two"))
+	assert.NotContains(t, paths, deepestDir)
+	assert.Len(t, tree.GetAllComponents(), 1)
+
+	warnings := tree.Warnings()
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], deepestDir)
+
+	unlimitedTree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+	assert.Len(t, unlimitedTree.GetAllComponents(), 2)
+	assert.Empty(t, unlimitedTree.Warnings())
+}
+
+func TestCreateComponentTree_DuplicateLayouts(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "+layout.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "+layout@.svelte"), nil, 0644))
+
+	_, err := CreateComponentTree(root)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "+layout.svelte")
+	assert.Contains(t, err.Error(), "+layout@.svelte")
+}
+
+func TestCreateComponentTree_FS(t *testing.T) {
+	views := fstest.MapFS{
+		"+layout.svelte":        {Data: []byte(`<slot></slot>`)},
+		"index.svelte":          {Data: []byte(`<h1>Home</h1>`)},
+		"catalog/cats.svelte":   {Data: []byte(`<h1>Cats</h1>`)},
+		"node_modules/x.svelte": {Data: []byte(`<h1>skipped</h1>`)},
+	}
+
+	//the views path doesn't have to exist on disk
+	root := filepath.Join(t.TempDir(), "views")
+	tree, err := CreateComponentTree(root, WithFS(views))
+	assert.NoError(t, err)
+
+	var relPaths []string
+	for _, component := range tree.GetAllComponents() {
+		relPaths = append(relPaths, component.RelativePath())
+		assert.NotNil(t, component.Layout)
+	}
+	assert.ElementsMatch(t, []string{"index.svelte", filepath.Join("catalog", "cats.svelte")}, relPaths)
+	assert.Contains(t, tree.GetAllDescendantPaths(), filepath.Join(root, "catalog"))
+}
+
+func TestCreateComponentTree_ReservedNames(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "views")
+	for _, name := range []string{
+		"__AviatorWrapped_Index.svelte",
+		"blog/__aviator_ssr.svelte",
+		"__AVIATOR/Index.svelte",
+	} {
+		views := fstest.MapFS{
+			"Index.svelte": {Data: []byte(`<h1>Home</h1>`)},
+			name:           {Data: []byte(`<h1>Collides</h1>`)},
+		}
+		_, err := CreateComponentTree(root, WithFS(views))
+		assert.ErrorIs(t, err, ErrReservedViewName, name)
+	}
+
+	//ignored files don't collide
+	views := fstest.MapFS{
+		"Index.svelte":                  {Data: []byte(`<h1>Home</h1>`)},
+		"__AviatorWrapped_Index.svelte": {Data: []byte(`<h1>Collides</h1>`)},
+	}
+	_, err := CreateComponentTree(root, WithFS(views), WithIgnore("__AviatorWrapped_*"))
+	assert.NoError(t, err)
+}
+
+func TestComponentTree_ReScan_RemovedComponent(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "index.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "about.svelte"), nil, 0644))
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+	assert.Len(t, tree.GetAllComponents(), 2)
+
+	assert.NoError(t, os.Remove(filepath.Join(root, "about.svelte")))
+	assert.NoError(t, tree.ReScan())
+
+	components := tree.GetAllComponents()
+	assert.Len(t, components, 1)
+	assert.Equal(t, "index.svelte", components[0].RelativePath())
+	assert.NotContains(t, tree.Components, "about")
+
+	//a component whose layout changes keeps its name but gets the new path
+	assert.NoError(t, os.Rename(filepath.Join(root, "index.svelte"), filepath.Join(root, "index@root.svelte")))
+	assert.NoError(t, tree.ReScan())
+	assert.Equal(t, filepath.Join(root, "index@root.svelte"), tree.Components["index"].Path)
+}
+
+func TestComponentTree_ReScan_Layouts(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "+layout.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "+layout-admin.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "index.svelte"), nil, 0644))
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+
+	//layouts that still exist survive repeated rescans
+	assert.NoError(t, tree.ReScan())
+	assert.NoError(t, tree.ReScan())
+	assert.Len(t, tree.Layouts, 2)
+	assert.Equal(t, tree.Layouts["+layout"], tree.Components["index"].Layout)
+
+	assert.NoError(t, os.Remove(filepath.Join(root, "+layout-admin.svelte")))
+	assert.NoError(t, tree.ReScan())
+	assert.Len(t, tree.Layouts, 1)
+	assert.NotContains(t, tree.Layouts, "admin")
+	assert.Contains(t, tree.Layouts, "+layout")
+}
+
+func TestComponentTree_RescanDir_RemovedParentLayout(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"users", "admin"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(root, dir), os.ModePerm))
+	}
+	for _, file := range []string{
+		"+layout.svelte",
+		"+layout-admin.svelte",
+		"users/list.svelte",
+		"admin/+layout@admin.svelte",
+		"admin/page.svelte",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, file), nil, 0644))
+	}
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+	users := tree.Children[filepath.Join(root, "users")]
+	admin := tree.Children[filepath.Join(root, "admin")]
+	assert.Equal(t, tree.Layouts["+layout"], users.Components["list"].Layout)
+	assert.Equal(t, tree.Layouts["admin"], admin.Layouts["+layout"].ParentLayout)
+
+	//child directories stop using the removed layouts
+	for _, file := range []string{"+layout.svelte", "+layout-admin.svelte"} {
+		path := filepath.Join(root, file)
+		assert.NoError(t, os.Remove(path))
+		assert.NoError(t, tree.RescanDir(path))
+	}
+	assert.Nil(t, users.Components["list"].Layout)
+	assert.Nil(t, admin.Layouts["+layout"].ParentLayout)
+	assert.Equal(t, []*Layout{admin.Layouts["+layout"]}, admin.Components["page"].ApplicableLayouts())
+
+	//and use the layout that replaces them
+	resetPath := filepath.Join(root, "+layout-reset.svelte")
+	assert.NoError(t, os.WriteFile(resetPath, nil, 0644))
+	assert.NoError(t, tree.RescanDir(resetPath))
+	assert.NotNil(t, tree.Layouts["+layout"])
+	assert.Equal(t, tree.Layouts["+layout"], users.Components["list"].Layout)
+}
+
+func TestComponentTree_ResetLayout(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"site/settings", "app/settings", "app/reports", "users"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), os.ModePerm))
+	}
+	for _, file := range []string{
+		"+layout.svelte",
+		"+layout-admin.svelte",
+		"users/list.svelte",
+		"site/page@admin.svelte",
+		"site/settings/+layout@admin.svelte",
+		"site/settings/page.svelte",
+		"app/+layout-reset.svelte",
+		"app/page@admin.svelte",
+		"app/settings/+layout@admin.svelte",
+		"app/settings/page.svelte",
+		"app/reports/monthly.svelte",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, file), nil, 0644))
+	}
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+
+	layoutsByComponent := map[string][]string{}
+	for _, component := range tree.GetAllComponents() {
+		var layouts []string
+		for _, layout := range component.ApplicableLayouts() {
+			layouts = append(layouts, layout.RelativePath())
+		}
+		layoutsByComponent[component.RelativePath()] = layouts
+	}
+
+	//outside of the reset directory named layouts resolve from the root
+	assert.Equal(t, []string{"+layout.svelte"}, layoutsByComponent[filepath.Join("users", "list.svelte")])
+	assert.Equal(t, []string{"+layout-admin.svelte"}, layoutsByComponent[filepath.Join("site", "page@admin.svelte")])
+	assert.Equal(t, []string{
+		filepath.Join("site", "settings", "+layout@admin.svelte"),
+		"+layout-admin.svelte",
+	}, layoutsByComponent[filepath.Join("site", "settings", "page.svelte")])
+
+	//inside of it the root layouts are out of reach
+	assert.Equal(t, []string{filepath.Join("app", "+layout-reset.svelte")},
+		layoutsByComponent[filepath.Join("app", "reports", "monthly.svelte")])
+	assert.Empty(t, layoutsByComponent[filepath.Join("app", "page@admin.svelte")])
+	assert.Equal(t, []string{filepath.Join("app", "settings", "+layout@admin.svelte")},
+		layoutsByComponent[filepath.Join("app", "settings", "page.svelte")])
+
+	//it's the directory's +layout, so both can't exist
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "app", "+layout.svelte"), nil, 0644))
+	_, err = CreateComponentTree(root)
+	assert.Error(t, err)
+	assert.NoError(t, os.Remove(filepath.Join(root, "app", "+layout.svelte")))
+
+	//reset isn't a layout name
+	for _, file := range []string{"users/page@reset.svelte", "+layout-reset@admin.svelte", "users/+layout@reset.svelte"} {
+		path := filepath.Join(root, file)
+		assert.NoError(t, os.WriteFile(path, nil, 0644))
+		_, err = CreateComponentTree(root)
+		assert.ErrorIs(t, err, errResetLayoutName, file)
+		assert.NoError(t, os.Remove(path))
+	}
+}
+
+func TestComponent_ErrorComponent(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "admin", "reports"), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "users"), os.ModePerm))
+	for _, file := range []string{
+		"+error.svelte",
+		"users/list.svelte",
+		"admin/+error.svelte",
+		"admin/reports/monthly.svelte",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, file), nil, 0644))
+	}
+
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+
+	errorComponents := map[string]string{}
+	for _, component := range tree.GetAllComponents() {
+		if errorComponent := component.ErrorComponent(); errorComponent != nil {
+			errorComponents[component.RelativePath()] = errorComponent.RelativePath()
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		filepath.Join("users", "list.svelte"):               "+error.svelte",
+		filepath.Join("admin", "reports", "monthly.svelte"): filepath.Join("admin", "+error.svelte"),
+	}, errorComponents)
+}
+
+// createSyntheticViews writes a views tree dirs wide and depth deep, with a
+// layout and files components in every directory
+func createSyntheticViews(t testing.TB, dirs, depth, files int) (string, int) {
+	root := t.TempDir()
+	count := 0
+	var create func(dir string, level int)
+	create = func(dir string, level int) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "+layout.svelte"), []byte("<slot/>"), 0644))
+		for i := 0; i < files; i++ {
+			name := filepath.Join(dir, fmt.Sprintf("Page%d.svelte", i))
+			assert.NoError(t, os.WriteFile(name, []byte("<h1>Page</h1>"), 0644))
+			count++
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < dirs; i++ {
+			child := filepath.Join(dir, fmt.Sprintf("dir%d", i))
+			assert.NoError(t, os.Mkdir(child, 0755))
+			create(child, level+1)
+		}
+	}
+	create(root, 0)
+
+	return root, count
+}
+
+func TestCreateComponentTree_Concurrent(t *testing.T) {
+	root, count := createSyntheticViews(t, 4, 3, 5)
+
+	tree, err := CreateComponentTree(root, WithMaxDepth(2))
+	assert.NoError(t, err)
+	//the third level is skipped
+	assert.Len(t, tree.GetAllComponents(), 5+4*5+16*5)
+	assert.Len(t, tree.Warnings(), 64)
+	assert.Less(t, len(tree.GetAllComponents()), count)
+
+	for _, component := range tree.GetAllComponents() {
+		assert.Equal(t, filepath.Join(filepath.Dir(component.Path), "+layout.svelte"), component.Layout.Path)
+	}
+}
+
+// concurrencyFS records the most ReadDir calls that were in flight at once
+type concurrencyFS struct {
+	fs.FS
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (c *concurrencyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	inFlight := atomic.AddInt32(&c.inFlight, 1)
+	defer atomic.AddInt32(&c.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&c.maxInFlight)
+		if inFlight <= max || atomic.CompareAndSwapInt32(&c.maxInFlight, max, inFlight) {
+			break
+		}
+	}
+
+	//keep the call in flight long enough for others to overlap it
+	time.Sleep(time.Millisecond)
+	return fs.ReadDir(c.FS, name)
+}
+
+func TestCreateComponentTree_ConcurrencyLimit(t *testing.T) {
+	root, count := createSyntheticViews(t, 4, 3, 1)
+	fsys := &concurrencyFS{FS: os.DirFS(root)}
+
+	tree, err := CreateComponentTree(root, WithFS(fsys))
+	assert.NoError(t, err)
+	assert.Len(t, tree.GetAllComponents(), count)
+
+	//the limit is shared by all levels of the tree
+	assert.LessOrEqual(t, int(fsys.maxInFlight), runtime.GOMAXPROCS(0))
+}
+
+func BenchmarkCreateComponentTree(b *testing.B) {
+	root, count := createSyntheticViews(b, 6, 3, 10)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree, err := CreateComponentTree(root)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(tree.GetAllComponents()) != count {
+			b.Fatalf("found %d components instead of %d", len(tree.GetAllComponents()), count)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*
+An exported app is a directory containing everything needed to render views
+without scanning, compiling or bundling anything at runtime:
+
+	manifest.json	views and static assets
+	ssr.js			the SSR bundle, evaluated in every VM
+	assets/			one file per static asset
+*/
+
+// ErrExportedViews is returned when building views loaded from an export
+var ErrExportedViews = errors.New("views loaded from an export can't be built")
+
+const exportManifestName = "manifest.json"
+const exportSSRBundleName = "ssr.js"
+const exportAssetsDir = "assets"
+
+type exportManifest struct {
+	Views  []exportedView
+	Assets []exportedAsset
+}
+
+type exportedView struct {
+	RelPath           string
+	UniqueName        string
+	WrappedUniqueName string
+	ComponentName     string
+	IsLayout          bool
+	IsEntrypoint      bool
+	IsErrorView       bool
+	StaticOnly        bool
+	JSImports         []string
+	CSSImports        []string
+	CacheVary         []string `json:",omitempty"`
+
+	//ErrorView is the RelPath of the view's error view
+	ErrorView string `json:",omitempty"`
+	//Layouts are the RelPaths of the view's layouts, the nearest first
+	Layouts []string `json:",omitempty"`
+}
+
+type exportedAsset struct {
+	Name     string
+	MimeType string
+}
+
+// Export writes the SSR bundle, all static assets and a manifest describing the
+// views to dir. The output can be loaded with NewViewManagerFromExport
+func (v *ViewManager) Export(dir string) error {
+	if len(v.ssrBundle) == 0 {
+		return errors.New("nothing to export, views have not been built")
+	}
+
+	assetsDir := filepath.Join(dir, exportAssetsDir)
+	err := os.MkdirAll(assetsDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	manifest := exportManifest{}
+
+	for _, view := range v.views {
+		exported := exportedView{
+			RelPath:           view.RelPath,
+			UniqueName:        view.UniqueName,
+			WrappedUniqueName: view.WrappedUniqueName,
+			ComponentName:     view.ComponentName,
+			IsLayout:          view.IsLayout,
+			IsEntrypoint:      view.IsEntrypoint,
+			IsErrorView:       view.IsErrorView,
+			StaticOnly:        view.StaticOnly,
+			JSImports:         view.JSImports,
+			CSSImports:        view.CSSImports,
+			CacheVary:         view.CacheVary,
+		}
+		if view.ErrorView != nil {
+			exported.ErrorView = view.ErrorView.RelPath
+		}
+		for _, layout := range view.ApplicableLayoutViews {
+			exported.Layouts = append(exported.Layouts, layout.RelPath)
+		}
+		manifest.Views = append(manifest.Views, exported)
+	}
+
+	for name, asset := range v.staticContent {
+		assetPath := filepath.Join(assetsDir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(assetPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(assetPath, asset.Content, 0644)
+		if err != nil {
+			return err
+		}
+
+		manifest.Assets = append(manifest.Assets, exportedAsset{
+			Name:     name,
+			MimeType: asset.MimeType,
+		})
+	}
+
+	err = os.WriteFile(filepath.Join(dir, exportSSRBundleName), v.ssrBundle, 0644)
+	if err != nil {
+		return err
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, exportManifestName), manifestJSON, 0644)
+}
+
+// NewViewManagerFromExport creates a ViewManager from an app written by Export.
+// Nothing is compiled or bundled, the SSR bundle is only evaluated in every VM.
+// config.Tree, config.CacheDir and config.ViewsDir are ignored
+func NewViewManagerFromExport(config ViewManagerConfig, fsys fs.FS) (*ViewManager, error) {
+	manifestJSON, err := fs.ReadFile(fsys, exportManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exported app manifest: %w", err)
+	}
+
+	manifest := &exportManifest{}
+	err = json.Unmarshal(manifestJSON, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse exported app manifest: %w", err)
+	}
+
+	v := newViewManager(config)
+	v.isExported = true
+
+	for _, exported := range manifest.Views {
+		v.views[exported.RelPath] = &View{
+			RelPath:           exported.RelPath,
+			UniqueName:        exported.UniqueName,
+			WrappedUniqueName: exported.WrappedUniqueName,
+			ComponentName:     exported.ComponentName,
+			IsLayout:          exported.IsLayout,
+			IsEntrypoint:      exported.IsEntrypoint,
+			IsErrorView:       exported.IsErrorView,
+			StaticOnly:        exported.StaticOnly,
+			JSImports:         exported.JSImports,
+			CSSImports:        exported.CSSImports,
+			CacheVary:         exported.CacheVary,
+		}
+	}
+	//error views and layouts are linked once all views exist
+	for _, exported := range manifest.Views {
+		view := v.views[exported.RelPath]
+		if len(exported.ErrorView) > 0 {
+			view.ErrorView = v.views[exported.ErrorView]
+		}
+		for _, layout := range exported.Layouts {
+			layoutView, ok := v.views[layout]
+			if !ok {
+				return nil, fmt.Errorf("exported app manifest is missing layout %s of %s", layout, exported.RelPath)
+			}
+			view.ApplicableLayoutViews = append(view.ApplicableLayoutViews, layoutView)
+		}
+	}
+
+	for _, exported := range manifest.Assets {
+		content, err := fs.ReadFile(fsys, path.Join(exportAssetsDir, exported.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		v.staticContent[exported.Name] = StaticAsset{
+			Content:  content,
+			MimeType: exported.MimeType,
+		}
+	}
+
+	v.cacheStaticHeadTags()
+
+	err = v.initPropsReviver()
+	if err != nil {
+		return nil, err
+	}
+
+	v.ssrBundle, err = fs.ReadFile(fsys, exportSSRBundleName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.vm.InitializationScript("aviator_ssr_router.js", string(v.ssrBundle))
+	if err != nil {
+		return nil, fmt.Errorf("encoutered error while evaluating exported SSR bundle: %w", err)
+	}
+	v.buildVersion = v.computeBuildVersion()
+
+	return v, nil
+}
@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/mansoor-s/aviator/js"
+	"github.com/stretchr/testify/assert"
+)
+
+// svelteRuntimeStub is the part of svelte/internal the SSR bundle of the
+// exported test views runs. The browser bundle only needs it to resolve
+const svelteRuntimeStub = `
+exports.escape = function(value) {
+	return String(value).replace(/["'&<>]/g, function(c) { return "&#" + c.charCodeAt(0) + ";" })
+}
+exports.missing_component = { $$render: function() { return "" } }
+exports.validate_component = function(component) { return component }
+exports.create_ssr_component = function(fn) {
+	function $$render(result, props, bindings, slots) {
+		return fn(result, props, bindings, slots || {})
+	}
+	return {
+		render: function(props) {
+			var result = { title: "", head: "", css: new Set() }
+			var html = $$render(result, props || {}, {}, {})
+			return { html: html, head: result.head, css: { code: "", map: null } }
+		},
+		$$render: $$render
+	}
+}
+`
+
+// newExportTestVM creates a goja VM pool, with the svelte compiler loaded when
+// withCompiler is set
+func newExportTestVM(t *testing.T, withCompiler bool) js.VM {
+	vm, err := js.NewVMPool(js.EngineGoja, 1, js.VMOptions{Logger: nopLogger{}})
+	assert.NoError(t, err)
+	if withCompiler {
+		compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+		assert.NoError(t, err)
+		assert.NoError(t, vm.InitializationScript("svelte_compiler_init.js", string(compilerCode)))
+	}
+	return vm
+}
+
+func TestViewManager_Export(t *testing.T) {
+	viewsDir := t.TempDir()
+	files := map[string]string{
+		"+layout.svelte":                        "<main><slot></slot></main>",
+		"+error.svelte":                         "<h1>Error</h1>",
+		"Index.svelte":                          "<script>export let title</script>\n<h1>{title}</h1>\n<style>h1 { color: red }</style>",
+		"node_modules/svelte/package.json":      `{"name": "svelte"}`,
+		"node_modules/svelte/internal/index.js": svelteRuntimeStub,
+	}
+	for name, source := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(viewsDir, name)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(viewsDir, name), []byte(source), 0644))
+	}
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	htmlGenerator := template.Must(template.New("test").Parse(testHTMLTemplate))
+	unbuilt, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	assert.Error(t, unbuilt.Export(t.TempDir()), "exporting before building should fail")
+
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:        nopLogger{},
+		VM:            newExportTestVM(t, true),
+		Tree:          tree,
+		CacheDir:      t.TempDir(),
+		ViewsDir:      viewsDir,
+		HTMLGenerator: htmlGenerator,
+	})
+	assert.NoError(t, err)
+
+	exportDir := t.TempDir()
+	err = v.Export(exportDir)
+	assert.NoError(t, err)
+
+	assert.FileExists(t, exportDir+"/manifest.json")
+	assert.FileExists(t, exportDir+"/ssr.js")
+	assert.FileExists(t, exportDir+"/assets/Index.svelte.js")
+	assert.FileExists(t, exportDir+"/assets/Index.svelte.css")
+
+	//the loaded view manager only has the exported app to render from
+	loaded, err := NewViewManagerFromExport(ViewManagerConfig{
+		VM:                newExportTestVM(t, false),
+		Logger:            nopLogger{},
+		HTMLGenerator:     htmlGenerator,
+		StaticAssetsRoute: "/static",
+		HTMLLang:          "en",
+	}, os.DirFS(exportDir))
+	assert.NoError(t, err)
+
+	out, err := loaded.Render(context.Background(), "Index.svelte", map[string]string{"title": "Exported"})
+	assert.NoError(t, err)
+	//the page is nested in its layout
+	assert.Regexp(t, `<main><h1 class="svelte-\w+">Exported</h1></main>`, out)
+	assert.Contains(t, out, `<script type="module" src="/static/Index.svelte.js" defer></script>`)
+	assert.Contains(t, out, `<link href="/static/Index.svelte.css" rel="stylesheet">`)
+
+	assert.Equal(t, "+error.svelte", loaded.ViewByRelPath("Index.svelte").ErrorView.RelPath)
+	assert.True(t, loaded.ViewByRelPath("+error.svelte").IsErrorView)
+	assert.Equal(t, "+layout.svelte", loaded.ViewByRelPath("Index.svelte").ApplicableLayoutViews[0].RelPath)
+
+	built, _ := v.GetStaticAsset("Index.svelte.css")
+	asset, ok := loaded.GetStaticAsset("Index.svelte.css")
+	assert.True(t, ok)
+	assert.Equal(t, "text/css", asset.MimeType)
+	assert.Equal(t, built.Content, asset.Content)
+
+	//there are no sources to build from
+	assert.ErrorIs(t, loaded.Build(), ErrExportedViews)
+	assert.ErrorIs(t, loaded.BuildSSR(), ErrExportedViews)
+	assert.ErrorIs(t, loaded.BuildBrowser(), ErrExportedViews)
+}
+
+func TestViewManager_Export_LayoutProps(t *testing.T) {
+	ssrBundle := wrappedPropsFuncs + `
+		var __aviator__ = {
+			render: function(name, props) {
+				return JSON.stringify({
+					body: "<nav>" + layoutProps(props).title + "</nav><main>" + pageProps(props).title + "</main>"
+				})
+			}
+		};`
+
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	layout := &View{RelPath: "+layout.svelte", UniqueName: "Layout", IsLayout: true}
+	v.views[layout.RelPath] = layout
+	view.ApplicableLayoutViews = []*View{layout}
+	v.ssrBundle = []byte(ssrBundle)
+
+	exportDir := t.TempDir()
+	assert.NoError(t, v.Export(exportDir))
+
+	loaded, err := NewViewManagerFromExport(ViewManagerConfig{
+		VM:                 newGojaTestVM(t, ""),
+		Logger:             nopLogger{},
+		HTMLGenerator:      v.htmlGenerator,
+		StaticAssetsRoute:  "/static",
+		IsolateLayoutProps: true,
+	}, os.DirFS(exportDir))
+	assert.NoError(t, err)
+	assert.Equal(t, []*View{loaded.ViewByRelPath("+layout.svelte")}, loaded.ViewByRelPath("Index.svelte").ApplicableLayoutViews)
+
+	props := map[string]string{"title": "Page"}
+	out, err := loaded.Render(context.Background(), "Index.svelte", props, LayoutProps(map[string]string{"title": "Site"}))
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<nav>Site</nav><main>Page</main>")
+
+	//isolated layouts get nothing without layout props
+	out, err = loaded.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<nav>undefined</nav><main>Page</main>")
+}
@@ -0,0 +1,18 @@
+package builder
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// HashFunc creates a key from content. It's used for asset versions and other
+// cache keys that might need to interoperate with external cache layers
+type HashFunc func(content []byte) string
+
+// defaultHash returns the first 20 characters of the hex encoded sha1 digest
+func defaultHash(content []byte) string {
+	h := sha1.New()
+	h.Write(content)
+
+	return hex.EncodeToString(h.Sum(nil))[:20]
+}
@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is the file at the root of the views directory listing the
+// patterns of files and directories that aren't scanned, like WithIgnore
+const ignoreFileName = ".aviatorignore"
+
+// ignoreRule is a gitignore style pattern
+type ignoreRule struct {
+	//segments are the slash separated parts of the pattern
+	segments []string
+	//anchored rules match paths relative to the views directory, the others
+	//match the name of a file or directory at any depth
+	anchored bool
+	dirOnly  bool
+	negated  bool
+}
+
+// ignoreMatcher matches the paths of the views directory that are excluded
+// from scanning against gitignore style patterns. Like gitignore, the last
+// matching pattern wins and patterns starting with ! include paths again
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// addPatterns adds gitignore style patterns. Blank lines and lines starting
+// with # are skipped
+func (m *ignoreMatcher) addPatterns(patterns ...string) {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negated = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		//a slash anywhere but at the end anchors the pattern
+		if strings.Contains(pattern, "/") {
+			rule.anchored = true
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		if len(pattern) == 0 {
+			continue
+		}
+		rule.segments = strings.Split(pattern, "/")
+
+		m.rules = append(m.rules, rule)
+	}
+}
+
+// isIgnored reports whether the file or directory at relPath, relative to the
+// views directory, is ignored
+func (m *ignoreMatcher) isIgnored(relPath string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(segments) {
+			ignored = !rule.negated
+		}
+	}
+
+	return ignored
+}
+
+func (r ignoreRule) matches(segments []string) bool {
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], segments[len(segments)-1])
+		return matched
+	}
+	return matchSegments(r.segments, segments)
+}
+
+// matchSegments matches path segments against pattern segments, where **
+// matches any number of segments
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+// readIgnoreFile adds the patterns of the .aviatorignore file of the views
+// directory, if there is one
+func (m *ignoreMatcher) readIgnoreFile(files viewsFS, viewsDir string) error {
+	content, err := files.ReadFile(filepath.Join(viewsDir, ignoreFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.addPatterns(strings.Split(string(content), "\n")...)
+	return nil
+}
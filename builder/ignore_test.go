@@ -0,0 +1,95 @@
+package builder
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	m := &ignoreMatcher{}
+	m.addPatterns(
+		"# stories and tests",
+		"*.stories.svelte",
+		"__tests__/",
+		"/drafts",
+		"admin/**/Debug*.svelte",
+		"",
+		"fixtures/*",
+		"!fixtures/Keep.svelte",
+	)
+
+	tests := []struct {
+		relPath string
+		isDir   bool
+		ignored bool
+	}{
+		{"Button.stories.svelte", false, true},
+		{"nested/deep/Card.stories.svelte", false, true},
+		{"Button.svelte", false, false},
+		{"__tests__", true, true},
+		{"nested/__tests__", true, true},
+		//directory patterns don't match files
+		{"__tests__", false, false},
+		//anchored patterns only match from the views directory
+		{"drafts", true, true},
+		{"blog/drafts", true, false},
+		{"admin/DebugPanel.svelte", false, true},
+		{"admin/users/DebugUser.svelte", false, true},
+		{"admin/users/User.svelte", false, false},
+		{"fixtures/Fake.svelte", false, true},
+		{"fixtures/Keep.svelte", false, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.ignored, m.isIgnored(filepath.FromSlash(test.relPath), test.isDir), test.relPath)
+	}
+
+	var empty *ignoreMatcher
+	assert.False(t, empty.isIgnored("Button.svelte", false))
+}
+
+func TestCreateComponentTree_Ignore(t *testing.T) {
+	views := fstest.MapFS{
+		".aviatorignore":                {Data: []byte("# from the views directory\n__tests__/\n")},
+		"+layout.svelte":                {Data: []byte(`<slot></slot>`)},
+		"+layout-print.svelte":          {Data: []byte(`<slot></slot>`)},
+		"Index.svelte":                  {Data: []byte(`<h1>Home</h1>`)},
+		"Index.stories.svelte":          {Data: []byte(`<h1>Story</h1>`)},
+		"__tests__/Fixture.svelte":      {Data: []byte(`<h1>Fixture</h1>`)},
+		"blog/Post.svelte":              {Data: []byte(`<h1>Post</h1>`)},
+		"blog/__tests__/Post.svelte":    {Data: []byte(`<h1>Fixture</h1>`)},
+		"blog/Post.stories.svelte":      {Data: []byte(`<h1>Story</h1>`)},
+		"blog/+layout-print@.svelte":    {Data: []byte(`<slot></slot>`)},
+		"blog/nested/+layout.svelte":    {Data: []byte(`<slot></slot>`)},
+		"blog/nested/Comment.svelte":    {Data: []byte(`<h1>Comment</h1>`)},
+		"blog/nested/Ignored.svelte":    {Data: []byte(`<h1>Ignored</h1>`)},
+		"blog/nested/IgnoredToo.svelte": {Data: []byte(`<h1>Ignored</h1>`)},
+	}
+
+	root := filepath.Join(t.TempDir(), "views")
+	tree, err := CreateComponentTree(
+		root,
+		WithFS(views),
+		WithIgnore("*.stories.svelte", "+layout-print*.svelte", "blog/nested/Ignored*.svelte"),
+	)
+	assert.NoError(t, err)
+
+	var relPaths []string
+	for _, component := range tree.GetAllComponents() {
+		relPaths = append(relPaths, component.RelativePath())
+	}
+	assert.ElementsMatch(t, []string{
+		"Index.svelte",
+		filepath.Join("blog", "Post.svelte"),
+		filepath.Join("blog", "nested", "Comment.svelte"),
+	}, relPaths)
+
+	var layouts []string
+	for _, layout := range tree.GetAllLayouts() {
+		layouts = append(layouts, layout.RelativePath())
+	}
+	assert.ElementsMatch(t, []string{"+layout.svelte", filepath.Join("blog", "nested", "+layout.svelte")}, layouts)
+	assert.NotContains(t, tree.GetAllDescendantPaths(), filepath.Join(root, "__tests__"))
+}
@@ -5,14 +5,44 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mansoor-s/aviator/js"
 )
 
+// ErrViewNotRenderable is returned when rendering a view that isn't in the
+// renderable views allowlist
+var ErrViewNotRenderable = errors.New("view is not renderable")
+
+// ErrViewBusy is returned when a render waiting for its view's concurrency limit
+// gives up because its context is done
+var ErrViewBusy = errors.New("view is at its render concurrency limit")
+
+// ErrInvalidRenderOutput is returned when the SSR runtime returns something
+// that isn't the JSON of a rendered view
+var ErrInvalidRenderOutput = errors.New("render output is not valid JSON")
+
+// maxRenderOutputInError is the number of bytes of an invalid render output
+// included in its error
+const maxRenderOutputInError = 300
+
 type ssrData struct {
 	Head string
 	Body string
 
+	//Meta is set by components through the "__aviator_meta" svelte context
+	Meta RenderMeta `json:"meta"`
+
 	//this is created during bundling
 	BundledCSS string
 
@@ -20,89 +50,1050 @@ type ssrData struct {
 	Lang string
 }
 
+// ErrorProps are the props a +error.svelte component is rendered with when the
+// view it stands in for throws during SSR. They're shipped to the client, so
+// Message is only the error's message in dev mode and the status text otherwise
+type ErrorProps struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// RenderOption configures a single render
+type RenderOption func(*renderOptions)
+
+type renderOptions struct {
+	omitPropsScript bool
+	context         map[string]interface{}
+
+	clientProps    interface{}
+	hasClientProps bool
+
+	layoutProps    interface{}
+	hasLayoutProps bool
+
+	locale string
+}
+
+// OmitPropsScript leaves the __aviator_props script out of the rendered page.
+// Useful for pages whose client side code doesn't need the props
+func OmitPropsScript() RenderOption {
+	return func(o *renderOptions) {
+		o.omitPropsScript = true
+	}
+}
+
+// RenderContext sets the values passed to the render. Each value is available
+// to components through svelte's getContext and to the store initializer
+func RenderContext(values map[string]interface{}) RenderOption {
+	return func(o *renderOptions) {
+		o.context = values
+	}
+}
+
+// ClientProps sets the props embedded in the __aviator_props hydration script.
+// The props passed to Render are then only used for SSR. Useful when the SSR
+// needs richer data than what should be shipped to the client
+func ClientProps(props interface{}) RenderOption {
+	return func(o *renderOptions) {
+		o.clientProps = props
+		o.hasClientProps = true
+	}
+}
+
+// LayoutProps sets the props the layouts of the view are rendered with. Layouts
+// then don't get the props of the page, which only the page is rendered with
+func LayoutProps(props interface{}) RenderOption {
+	return func(o *renderOptions) {
+		o.layoutProps = props
+		o.hasLayoutProps = true
+	}
+}
+
+// Locale renders the view with the locale specific variants of its assets when
+// they exist. The variant of Index.svelte.js for "fr" is Index.svelte.fr.js.
+// A locale such as "fr-CA" falls back to "fr" and then to the default asset
+func Locale(locale string) RenderOption {
+	return func(o *renderOptions) {
+		o.locale = locale
+	}
+}
+
+// layoutPropsJSON returns the encoded props the layouts of the view are
+// rendered with and whether they get their own props instead of the page's.
+// Isolated layouts without layout props get none
+func (v *ViewManager) layoutPropsJSON(view *View, options *renderOptions) (string, bool, error) {
+	if len(view.ApplicableLayoutViews) == 0 || (!options.hasLayoutProps && !v.isolateLayoutProps) {
+		return "", false, nil
+	}
+	if options.layoutProps == nil {
+		return "{}", true, nil
+	}
+
+	encoded, err := v.propsEncoder(options.layoutProps)
+	if err != nil {
+		return "", false, err
+	}
+	return string(encoded), true, nil
+}
+
+// withLayoutProps adds the encoded layout props to the encoded props of a page,
+// under the key its layout wrapped component reads them from
+func withLayoutProps(jsonProps string, layoutProps string) (string, error) {
+	trimmed := strings.TrimSpace(jsonProps)
+	if !strings.HasPrefix(trimmed, "{") {
+		return "", errors.New("props must encode to a JSON object to be rendered with layout props")
+	}
+
+	field := `{"` + layoutPropsKey + `":` + strings.TrimSpace(layoutProps)
+	rest := strings.TrimSpace(trimmed[1:])
+	if rest == "}" {
+		return field + "}", nil
+	}
+	return field + "," + rest, nil
+}
+
+// hydrationProps returns the props the view is hydrated with
+func hydrationProps(props interface{}, options *renderOptions) interface{} {
+	if options.hasClientProps {
+		return options.clientProps
+	}
+	return props
+}
+
+func newRenderOptions(opts []RenderOption) *renderOptions {
+	o := &renderOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// RenderMeta lets a component influence the HTTP response. Components set it
+// during SSR through the "__aviator_meta" svelte context:
+//
+//	const meta = getContext("__aviator_meta")
+//	meta.status = 302
+//	meta.redirect = "/login"
+type RenderMeta struct {
+	//Status is 0 if the component didn't set one
+	Status   int               `json:"status"`
+	Redirect string            `json:"redirect"`
+	Headers  map[string]string `json:"headers"`
+}
+
+// RenderResult is the rendered page along with the response metadata the
+// component set while rendering
+type RenderResult struct {
+	HTML string
+	Meta RenderMeta
+
+	//the parts HTML is assembled from, for callers building their own document
+
+	//Body is the rendered component
+	Body string
+	//ComponentHead is the output of the component's <svelte:head>
+	ComponentHead string
+	//HeadTags are the asset tags Aviator adds to the head
+	HeadTags string
+	//PropsScript is empty if it was omitted
+	PropsScript string
+}
+
 func (v *ViewManager) Render(
-	_ context.Context,
+	ctx context.Context,
 	viewPath string,
 	props interface{},
+	opts ...RenderOption,
 ) (string, error) {
-	view := v.ViewByRelPath(viewPath)
+	var b strings.Builder
+	err := v.RenderTo(ctx, &b, viewPath, props, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// RenderTo renders the view like Render, executing the HTML template directly
+// into w. Nothing is written if rendering the view fails, but w may hold part of
+// the document if writing to it fails
+func (v *ViewManager) RenderTo(
+	ctx context.Context,
+	w io.Writer,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) error {
+	rendered, err := v.renderView(ctx, viewPath, props, opts...)
+	if err != nil {
+		return err
+	}
+
+	//formatting needs the whole document
+	if v.prettyHTML {
+		document, err := v.renderDocument(rendered)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, document)
+		return err
+	}
+
+	return v.htmlTemplate().Execute(w, v.documentData(rendered))
+}
+
+// RenderFirst renders the first of the candidate views that exists. Useful for
+// letting tenant or theme specific views override a default view
+func (v *ViewManager) RenderFirst(
+	ctx context.Context,
+	candidates []string,
+	props interface{},
+	opts ...RenderOption,
+) (string, error) {
+	for _, viewPath := range candidates {
+		if v.ViewByRelPath(viewPath) == nil {
+			continue
+		}
+		return v.Render(ctx, viewPath, props, opts...)
+	}
+
+	return "", fmt.Errorf("none of the views exist: %s", strings.Join(candidates, ", "))
+}
+
+// RenderAll renders every entrypoint view that can be rendered with the props
+// propsProvider returns for it. The returned map is keyed by the relative path
+// of the views. Useful for snapshot testing all pages
+func (v *ViewManager) RenderAll(
+	ctx context.Context,
+	propsProvider func(view string) interface{},
+) (map[string]string, error) {
+	output := map[string]string{}
+	for _, view := range v.AllViews() {
+		if !view.IsEntrypoint || view.IsErrorView || !v.isRenderable(view.RelPath) {
+			continue
+		}
+
+		html, err := v.Render(ctx, view.RelPath, propsProvider(view.RelPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", view.RelPath, err)
+		}
+		output[view.RelPath] = html
+	}
+
+	return output, nil
+}
 
+// RenderView renders the view like Render, but also returns the status, redirect
+// and headers the component requested
+func (v *ViewManager) RenderView(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) (*RenderResult, error) {
+	rendered, err := v.renderView(ctx, viewPath, props, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := v.renderDocument(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RenderResult{
+		HTML:          document,
+		Meta:          rendered.Meta,
+		Body:          rendered.Body,
+		ComponentHead: rendered.Head,
+		HeadTags:      rendered.headTags,
+		PropsScript:   rendered.propsScript,
+	}, nil
+}
+
+// documentData is the data the HTML template is executed with for rendered
+func (v *ViewManager) documentData(rendered *renderedView) ssrData {
+	ssrOutputData := *rendered.ssrData
+	ssrOutputData.Head = rendered.Head + "\n" + rendered.headTags + rendered.propsScript
+
+	ssrOutputData.Lang = v.htmlLang
+	//cssPath := path.Join(a.assetListenPath, a._compiledCSSFileName)
+	//ssrOutputData.BundledCSS = "<link href=\"" + cssPath + "\" rel=\"stylesheet\">"
+
+	return ssrOutputData
+}
+
+// renderDocument assembles the HTML document of rendered
+func (v *ViewManager) renderDocument(rendered *renderedView) (string, error) {
+	buf := new(bytes.Buffer)
+	err := v.htmlTemplate().Execute(buf, v.documentData(rendered))
+	if err != nil {
+		return "", err
+	}
+
+	document := buf.String()
+	if v.prettyHTML {
+		document = prettyHTML(document)
+	}
+
+	return document, nil
+}
+
+// RenderWithTags renders the view without assembling the HTML document, for
+// callers that own the <head>. headTags contains the component's <svelte:head>
+// output followed by the asset tags. propsScript is empty if it was omitted.
+// Placing headTags + propsScript in the head and body in the body results in the
+// same page Render returns
+func (v *ViewManager) RenderWithTags(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) (body, headTags, propsScript string, err error) {
+	rendered, err := v.renderView(ctx, viewPath, props, opts...)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return rendered.Body, rendered.Head + "\n" + rendered.headTags, rendered.propsScript, nil
+}
+
+// JSONRender is a rendered view as returned by RenderJSON, for frontends that
+// assemble the page themselves
+type JSONRender struct {
+	//Head is the output of the component's <svelte:head>
+	Head string `json:"head"`
+	Body string `json:"body"`
+	//CSS are the URLs of the view's stylesheets. Component styles aren't part
+	//of the SSR output, they're bundled into these
+	CSS []string `json:"css"`
+	//JS are the URLs of the view's module scripts
+	JS []string `json:"js"`
+	//Props is the JSON the client hydrates with, null if the props script was
+	//omitted
+	Props json.RawMessage `json:"props"`
+	Meta  RenderMeta      `json:"meta"`
+}
+
+// RenderJSON renders the view like RenderView, but returns its parts as the
+// JSON of a JSONRender instead of an HTML document
+func (v *ViewManager) RenderJSON(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) ([]byte, error) {
+	rendered, err := v.renderView(ctx, viewPath, props, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	output := JSONRender{
+		Head: rendered.Head,
+		Body: rendered.Body,
+		Meta: rendered.Meta,
+	}
+	if len(rendered.clientProps) > 0 {
+		output.Props = json.RawMessage(rendered.clientProps)
+	}
+	output.JS, output.CSS = v.assetURLs(rendered.view, rendered.locale)
+
+	return json.Marshal(output)
+}
+
+// assetURLs returns the URLs of the JS and CSS assets of the view, using the
+// variants of the locale if it has any. They're the assets of its head tags
+func (v *ViewManager) assetURLs(view *View, locale string) (jsURLs []string, cssURLs []string) {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	jsImports, cssImports := view.JSImports, viewCSSImports(view)
+	if len(locale) > 0 {
+		jsImports, _ = v.localeAssets(jsImports, locale)
+		cssImports, _ = v.localeAssets(cssImports, locale)
+	}
+
+	jsURLs = make([]string, 0, len(jsImports))
+	for _, name := range jsImports {
+		jsURLs = append(jsURLs, assetURL(v.jsRoute, name))
+	}
+
+	cssURLs = make([]string, 0, len(cssImports)+1)
+	if _, ok := v.staticContent[baseCSSStyleName]; ok {
+		cssURLs = append(cssURLs, assetURL(v.cssRoute, baseCSSStyleName))
+	}
+	for _, name := range cssImports {
+		cssURLs = append(cssURLs, assetURL(v.cssRoute, name))
+	}
+
+	return jsURLs, cssURLs
+}
+
+// PropsScriptFor returns the props payload the __aviator_props script of the
+// view would contain, without rendering it. ssr-only fields are left out and it's
+// escaped and compacted the same way as the script, so it's safe to inline. It's
+// meant to be served as a fetch response so the client can swap its props and
+// re-render without a full page load. Only the LayoutProps option applies
+func (v *ViewManager) PropsScriptFor(viewPath string, props interface{}, opts ...RenderOption) (string, error) {
+	view := v.ViewByRelPath(viewPath)
 	if view == nil {
 		return "", fmt.Errorf("view does not exist in path %s", viewPath)
 	}
+	if !v.isRenderable(view.RelPath) {
+		return "", fmt.Errorf("%w: %s", ErrViewNotRenderable, viewPath)
+	}
+
+	options := newRenderOptions(opts)
+	layoutProps, hasLayoutProps, err := v.layoutPropsJSON(view, options)
+	if err != nil {
+		return "", v.propsError(viewPath, options.layoutProps, err)
+	}
+
+	jsonValue := "{}"
+	filteredProps, _ := clientProps(props)
+	if filteredProps != nil {
+		jsonProps, err := v.propsEncoder(filteredProps)
+		if err != nil {
+			return "", v.propsError(viewPath, filteredProps, err)
+		}
+		jsonValue = string(jsonProps)
+	}
+	if hasLayoutProps {
+		jsonValue, err = withLayoutProps(jsonValue, layoutProps)
+		if err != nil {
+			return "", v.propsError(viewPath, props, err)
+		}
+	}
+
+	return v.propsScriptContent(jsonValue), nil
+}
+
+// RenderStreamList renders the body of the view once for every props received
+// from propsChan and writes it to w as soon as it's rendered. w is flushed after
+// every item if it supports flushing. Rendering stops when propsChan is closed or
+// ctx is done, in which case ctx.Err() is returned
+func (v *ViewManager) RenderStreamList(
+	ctx context.Context,
+	w io.Writer,
+	viewPath string,
+	propsChan <-chan interface{},
+) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case props, ok := <-propsChan:
+			if !ok {
+				return nil
+			}
+			//select picks randomly when both are ready
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			rendered, err := v.renderView(ctx, viewPath, props, OmitPropsScript())
+			if err != nil {
+				return err
+			}
+
+			_, err = io.WriteString(w, rendered.Body)
+			if err != nil {
+				return err
+			}
+
+			err = flush(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flush flushes w if it's an http.Flusher or a buffered writer
+func flush(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() }:
+		f.Flush()
+	case interface{ Flush() error }:
+		return f.Flush()
+	}
+	return nil
+}
+
+// propsError wraps a props serialization error with the view path and, in dev
+// mode, the path of the value that couldn't be serialized
+func (v *ViewManager) propsError(viewPath string, props interface{}, err error) error {
+	if v.isDevMode {
+		if fieldPath := unsupportedPropPath(props); len(fieldPath) > 0 {
+			return fmt.Errorf("failed to json serialize props of view %s, %s can't be serialized: %w", viewPath, fieldPath, err)
+		}
+	}
+
+	return fmt.Errorf("failed to json serialize props of view %s: %w", viewPath, err)
+}
+
+// renderOutputError wraps the error parsing the render output of a view with
+// the start of the output, which is usually an error message or a value a
+// component returned instead of the rendered view
+func renderOutputError(viewPath string, output string, err error) error {
+	if len(strings.TrimSpace(output)) == 0 {
+		return fmt.Errorf("%w: view %s rendered nothing", ErrInvalidRenderOutput, viewPath)
+	}
+
+	if len(output) > maxRenderOutputInError {
+		end := maxRenderOutputInError
+		//don't cut a multi byte character in half
+		for end > 0 && !utf8.RuneStart(output[end]) {
+			end--
+		}
+		output = output[:end] + "..."
+	}
+
+	return fmt.Errorf("%w: view %s returned %q: %s", ErrInvalidRenderOutput, viewPath, output, err.Error())
+}
+
+// jsErrorNamespace matches the esbuild namespace source map paths of files
+// loaded by the builder plugins start with, i.e. "svelte:/views/Index.svelte"
+var jsErrorNamespace = regexp.MustCompile(`^(svelte|wrappedComponents|js_path|storeInitializer|externalStub):`)
+
+// relativeJSError makes the files in the stack of a JS error relative to the
+// views directory, so they name the components the error was thrown from
+func relativeJSError(err error, viewsDir string) error {
+	var jsErr *js.JSError
+	if !errors.As(err, &jsErr) {
+		return err
+	}
+
+	absViewsDir, absErr := filepath.Abs(viewsDir)
+	for i, frame := range jsErr.Stack {
+		file := jsErrorNamespace.ReplaceAllString(frame.File, "")
+		if filepath.IsAbs(file) && absErr == nil {
+			if relPath, relErr := filepath.Rel(absViewsDir, file); relErr == nil {
+				file = relPath
+			}
+		}
+		jsErr.Stack[i].File = file
+	}
+
+	return err
+}
+
+// the globals renderProgram reads the view to render and its JSON encoded props
+// and context from
+const (
+	renderViewGlobal    = "__aviator_render_view"
+	renderPropsGlobal   = "__aviator_render_props"
+	renderContextGlobal = "__aviator_render_context"
+)
+
+// renderProgram returns the script rendering the view set in the render
+// globals. It's the same for every render, so VMs only parse it once
+func (v *ViewManager) renderProgram() string {
+	//revive props the same way the browser does before hydrating so both sides
+	//render with the same prop types
+	props := "JSON.parse(" + renderPropsGlobal + ")"
+	if len(v.propsReviver) > 0 {
+		props = "JSON.parse(" + renderPropsGlobal + ", __aviator_props_reviver)"
+	}
+
+	program := fmt.Sprintf(
+		"; __aviator__.render(%s, %s, JSON.parse(%s))",
+		renderViewGlobal,
+		props,
+		renderContextGlobal,
+	)
+	//every render starts from the same seed, whichever VM it runs on
+	if v.deterministicSSR {
+		program = fmt.Sprintf(seededRandomScript, uint32(v.ssrSeed)) + program
+	}
+
+	return program
+}
+
+// seededRandomScript replaces Math.random with a mulberry32 PRNG seeded with
+// the formatted uint32
+const seededRandomScript = `; Math.random = (function (a) {
+	return function () {
+		a = a + 0x6D2B79F5 | 0
+		var t = Math.imul(a ^ a >>> 15, 1 | a)
+		t = t + Math.imul(t ^ t >>> 7, 61 | t) ^ t
+		return ((t ^ t >>> 14) >>> 0) / 4294967296
+	}
+})(%d)`
+
+// renderedView holds the pieces of a rendered view before they are assembled
+// into an HTML document
+type renderedView struct {
+	*ssrData
+
+	//view is the rendered view, the error view if it replaced the requested one
+	view   *View
+	locale string
+	//clientProps is the JSON of the props script, empty if it was omitted
+	clientProps string
+
+	//headTags are the tags Aviator adds to the head, excluding the props script
+	headTags    string
+	propsScript string
+}
+
+func (v *ViewManager) renderView(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) (*renderedView, error) {
+	options := newRenderOptions(opts)
+
+	view := v.ViewByRelPath(viewPath)
+
+	if view == nil {
+		return nil, fmt.Errorf("view does not exist in path %s", viewPath)
+	}
+	if !v.isRenderable(view.RelPath) {
+		return nil, fmt.Errorf("%w: %s", ErrViewNotRenderable, viewPath)
+	}
+	//there's no client side code to read the props
+	if view.StaticOnly {
+		options.omitPropsScript = true
+	}
+	layoutProps, hasLayoutProps, err := v.layoutPropsJSON(view, options)
+	if err != nil {
+		return nil, v.propsError(viewPath, options.layoutProps, err)
+	}
+	//prop-less views are hydrated with undefined props
+	if v.omitNilPropsScript && hydrationProps(props, options) == nil && !hasLayoutProps {
+		options.omitPropsScript = true
+	}
+
+	release, err := v.acquireViewSlot(ctx, view.RelPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	//the request may have been cancelled while waiting for a slot, skip the
+	//render entirely. Cancelling ctx later interrupts the running render
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	//TODO: Create a sanitized copy of the props object where
-	// string objects are escaped to avoid script injections on the front end
-	// Should users be able to bypass escaping using tags?
 	jsonValue := "{}"
 	if props != nil {
-		jsonProps, err := json.Marshal(props)
+		jsonProps, err := v.propsEncoder(props)
 		if err != nil {
-			return "", fmt.Errorf("failed to json serialize props %w", err)
+			return nil, v.propsError(viewPath, props, err)
 		}
 		jsonValue = string(jsonProps)
 	}
+	if hasLayoutProps {
+		jsonValue, err = withLayoutProps(jsonValue, layoutProps)
+		if err != nil {
+			return nil, v.propsError(viewPath, props, err)
+		}
+	}
+
+	//ssr-only props are rendered on the server but never shipped to the client
+	clientJSONValue := jsonValue
+	filteredProps, hasSSROnly := clientProps(props)
+	//explicit client props can't ship ssr-only fields either
+	if options.hasClientProps {
+		filteredProps, _ = clientProps(options.clientProps)
+	}
+	if (hasSSROnly || options.hasClientProps) && !options.omitPropsScript {
+		clientJSONValue = "{}"
+		if filteredProps != nil {
+			jsonProps, err := v.propsEncoder(filteredProps)
+			if err != nil {
+				return nil, v.propsError(viewPath, filteredProps, err)
+			}
+			clientJSONValue = string(jsonProps)
+		}
+		if hasLayoutProps {
+			clientJSONValue, err = withLayoutProps(clientJSONValue, layoutProps)
+			if err != nil {
+				return nil, v.propsError(viewPath, filteredProps, err)
+			}
+		}
+	}
+
+	contextValues := options.context
+	if len(v.buildInfo) > 0 {
+		contextValues = make(map[string]interface{}, len(options.context)+1)
+		for key, value := range options.context {
+			contextValues[key] = value
+		}
+		contextValues[buildInfoContextKey] = v.buildInfo
+	}
 
-	expr := fmt.Sprintf(
-		"; __aviator__.render(%q, %s, {})",
-		view.WrappedUniqueName,
-		jsonValue,
+	contextValue := "{}"
+	if contextValues != nil {
+		jsonContext, err := json.Marshal(contextValues)
+		if err != nil {
+			return nil, fmt.Errorf("failed to json serialize render context %w", err)
+		}
+		contextValue = string(jsonContext)
+	}
+
+	renderOutputStr, err := v.vm.EvalProgram(
+		js.WithConsoleLabel(ctx, view.RelPath),
+		"runtime_renderer",
+		v.renderProgram(),
+		map[string]interface{}{
+			renderViewGlobal:    view.WrappedUniqueName,
+			renderPropsGlobal:   jsonValue,
+			renderContextGlobal: contextValue,
+		},
 	)
-	renderOutputStr, err := v.vm.Eval("runtime_renderer", expr)
 	if err != nil {
-		return renderOutputStr, err
+		err = relativeJSError(err, v.viewsDir)
+		//an aborted render isn't an error of the view
+		if view.ErrorView != nil && ctx.Err() == nil {
+			return v.renderErrorView(ctx, view, err, options)
+		}
+		return nil, err
 	}
 
 	ssrOutputData := &ssrData{}
 	err = json.Unmarshal([]byte(renderOutputStr), ssrOutputData)
 	if err != nil {
-		return "", err
+		return nil, renderOutputError(viewPath, renderOutputStr, err)
 	}
 
-	ssrOutputData.Head = ssrOutputData.Head + "\n" +
-		v.createJSImportTags(view.JSImports)
+	v.viewsLock.RLock()
+	headTags := v.localeHeadTags(view, options.locale)
+	v.viewsLock.RUnlock()
 
-	_, baseStyleFound := v.staticContent[baseCSSStyleName]
-	if baseStyleFound {
-		ssrOutputData.Head += v.createCSSImportTag(baseCSSStyleName)
+	rendered := &renderedView{
+		ssrData:  ssrOutputData,
+		view:     view,
+		locale:   options.locale,
+		headTags: headTags,
+	}
+	//the browser seeds its stores from the same context the SSR used
+	if v.hasStoreInitializer {
+		rendered.headTags += v.createContextScriptElem(contextValue)
+	}
+	if !options.omitPropsScript {
+		rendered.clientProps = clientJSONValue
+		rendered.propsScript = v.createPropsScriptElem(clientJSONValue)
 	}
 
-	ssrOutputData.Head +=
-		v.createCSSImportTags(view.CSSImports) +
-			v.createPropsScriptElem(jsonValue)
+	return rendered, nil
+}
 
-	ssrOutputData.Lang = v.htmlLang
-	//cssPath := path.Join(a.assetListenPath, a._compiledCSSFileName)
-	//ssrOutputData.BundledCSS = "<link href=\"" + cssPath + "\" rel=\"stylesheet\">"
+// renderErrorView renders the error view of view in its place after it threw
+// renderErr. renderErr is returned if the error view can't be rendered either
+func (v *ViewManager) renderErrorView(
+	ctx context.Context,
+	view *View,
+	renderErr error,
+	options *renderOptions,
+) (*renderedView, error) {
+	v.logger.Error(fmt.Sprintf(
+		"error rendering %s, rendering %s instead: %s",
+		view.RelPath,
+		view.ErrorView.RelPath,
+		renderErr.Error(),
+	))
 
-	buf := new(bytes.Buffer)
-	err = v.htmlGenerator.Execute(buf, ssrOutputData)
+	opts := []RenderOption{RenderContext(options.context), Locale(options.locale)}
+	if options.omitPropsScript {
+		opts = append(opts, OmitPropsScript())
+	}
+	props := ErrorProps{
+		Status:  http.StatusInternalServerError,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}
+	//the error may contain paths and internal details
+	if v.isDevMode {
+		props.Message = renderErr.Error()
+	}
+	rendered, err := v.renderView(ctx, view.ErrorView.RelPath, props, opts...)
 	if err != nil {
-		return "", err
+		return nil, renderErr
+	}
+
+	//the error view may set a more specific status
+	if rendered.Meta.Status == 0 {
+		rendered.Meta.Status = http.StatusInternalServerError
 	}
 
-	return buf.String(), nil
+	return rendered, nil
 }
 
 func (v *ViewManager) GetStaticAsset(name string) (StaticAsset, bool) {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
 	staticAsset, ok := v.staticContent[name]
 	return staticAsset, ok
 }
 
+// StaticAssetNames returns the sorted names of all static assets of the current
+// build, including the base CSS and the user's static assets
+func (v *ViewManager) StaticAssetNames() []string {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	names := make([]string, 0, len(v.staticContent))
+	for name := range v.staticContent {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// cacheStaticHeadTags precomputes the JS and CSS import tags of every view.
+// Only the props script differs between renders of the same view
+func (v *ViewManager) cacheStaticHeadTags() {
+	for _, view := range v.views {
+		view.staticHeadTags = v.createHeadTags(view.JSImports, viewCSSImports(view))
+	}
+}
+
+// viewCSSImports returns the CSS assets of the view ordered by layout
+// proximity: the outermost layout's first and the view's own last, so page
+// styles win over layout styles of equal specificity
+func viewCSSImports(view *View) []string {
+	layouts := view.ApplicableLayoutViews
+	if len(layouts) == 0 {
+		return view.CSSImports
+	}
+
+	var cssImports []string
+	seen := map[string]bool{}
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				cssImports = append(cssImports, name)
+			}
+		}
+	}
+	//the nearest layout is first
+	for i := len(layouts) - 1; i >= 0; i-- {
+		add(layouts[i].CSSImports)
+	}
+	add(view.CSSImports)
+
+	return cssImports
+}
+
+func (v *ViewManager) createHeadTags(jsImports []string, cssImports []string) string {
+	_, baseStyleFound := v.staticContent[baseCSSStyleName]
+
+	var head string
+	if v.useImportMap {
+		head = v.createImportMapTags(jsImports)
+	} else {
+		head = v.createJSImportTags(jsImports)
+	}
+	if baseStyleFound {
+		head += v.createCSSImportTag(baseCSSStyleName)
+	}
+	head += v.createCSSImportTags(cssImports)
+	if _, ok := v.staticContent[serviceWorkerName]; ok {
+		head += v.createServiceWorkerRegistration()
+	}
+
+	return head
+}
+
+// localeHeadTags returns the head tags of the view using the locale variants of
+// its assets. The cached tags are returned if the view has no variants
+func (v *ViewManager) localeHeadTags(view *View, locale string) string {
+	if len(locale) == 0 {
+		return view.staticHeadTags
+	}
+
+	jsImports, hasJSVariant := v.localeAssets(view.JSImports, locale)
+	cssImports, hasCSSVariant := v.localeAssets(viewCSSImports(view), locale)
+	if !hasJSVariant && !hasCSSVariant {
+		return view.staticHeadTags
+	}
+
+	return v.createHeadTags(jsImports, cssImports)
+}
+
+// localeAssets replaces each asset name with its locale variant if one exists,
+// trying the full locale first and then only its language
+func (v *ViewManager) localeAssets(names []string, locale string) ([]string, bool) {
+	candidates := []string{locale}
+	if language := strings.SplitN(locale, "-", 2)[0]; language != locale {
+		candidates = append(candidates, language)
+	}
+
+	hasVariant := false
+	variants := make([]string, len(names))
+	for i, name := range names {
+		variants[i] = name
+		ext := path.Ext(name)
+		for _, candidate := range candidates {
+			variant := strings.TrimSuffix(name, ext) + "." + candidate + ext
+			if _, ok := v.staticContent[variant]; ok {
+				variants[i] = variant
+				hasVariant = true
+				break
+			}
+		}
+	}
+
+	return variants, hasVariant
+}
+
+const propsScriptOpenTag = "<script id=\"__aviator_props\" type=\"text/template\" defer>"
+const propsScriptCloseTag = "</script>\n"
+
+// scriptJSONEscaper escapes the characters that could end a script element or
+// open a comment in it. They can only appear in strings in valid JSON, where the
+// unicode escapes decode to the same value. It makes embedding safe regardless of
+// whether the props encoder escapes HTML
+var scriptJSONEscaper = strings.NewReplacer(
+	"<", "\\u003c",
+	">", "\\u003e",
+	"&", "\\u0026",
+)
+
+// propsScriptContent returns the encoded props as the props script contains them
+func (v *ViewManager) propsScriptContent(props string) string {
+	if v.compactPropsScript {
+		props = compactJSON(props)
+	}
+	return scriptJSONEscaper.Replace(props)
+}
+
 func (v *ViewManager) createPropsScriptElem(props string) string {
-	format := "<script id=\"__aviator_props\" type=\"text/template\" defer>%s</script>\n"
-	return fmt.Sprintf(format, props)
+	if v.compactPropsScript {
+		return propsScriptOpenTag + v.propsScriptContent(props) + strings.TrimSuffix(propsScriptCloseTag, "\n")
+	}
+	return propsScriptOpenTag + v.propsScriptContent(props) + propsScriptCloseTag
+}
+
+func (v *ViewManager) createContextScriptElem(context string) string {
+	elem := "<script id=\"__aviator_context\" type=\"text/template\" defer>" + scriptJSONEscaper.Replace(context) + "</script>"
+	if v.compactPropsScript {
+		return elem
+	}
+	return elem + "\n"
+}
+
+// compactJSON removes the insignificant whitespace custom props encoders may
+// emit, i.e. indentation or the trailing newline of json.Encoder
+func compactJSON(value string) string {
+	buf := new(bytes.Buffer)
+	err := json.Compact(buf, []byte(value))
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return buf.String()
+}
+
+// assetURL joins an asset route and an asset name. Routes may be URLs, i.e. of
+// a CDN, whose scheme separator must survive the join
+func assetURL(route string, name string) string {
+	if strings.Contains(route, "://") {
+		return strings.TrimSuffix(route, "/") + "/" + name
+	}
+	return path.Join(route, name)
 }
 
 func (v *ViewManager) createJSImportTags(assetImports []string) string {
 	output := ""
 	format := "<script type=\"module\" src=\"%s\" defer></script>\n"
 	for _, rawPath := range assetImports {
-		output += fmt.Sprintf(format, filepath.Join(v.staticAssetsRoute, rawPath))
+		output += fmt.Sprintf(format, assetURL(v.jsRoute, rawPath))
 	}
 
 	return output
 }
+
+// importMapSpecifierPrefix is prepended to JS asset names to create the bare
+// import specifiers used in the import map
+const importMapSpecifierPrefix = "@aviator/"
+
+// createImportMapTags creates an import map that maps a stable bare specifier for
+// each JS asset to its URL, followed by a single module script importing them.
+// URLs contain a hash of the asset contents so browsers can cache them across pages
+func (v *ViewManager) createImportMapTags(assetImports []string) string {
+	if len(assetImports) == 0 {
+		return ""
+	}
+
+	imports := map[string]string{}
+	bootstrap := ""
+	for _, rawPath := range assetImports {
+		specifier := importMapSpecifierPrefix + rawPath
+
+		hash := v.hash(v.staticContent[rawPath].Content)
+
+		imports[specifier] = assetURL(v.jsRoute, rawPath) + "?v=" + hash
+		bootstrap += fmt.Sprintf("import %q;", specifier)
+	}
+
+	importMap, _ := json.Marshal(map[string]interface{}{
+		"imports": imports,
+	})
+
+	return "<script type=\"importmap\">" + string(importMap) + "</script>\n" +
+		"<script type=\"module\">" + bootstrap + "</script>\n"
+}
+
+// acquireViewSlot waits for the view to be under its concurrency limit. The
+// returned func releases the slot. Waiting ends with ErrViewBusy when ctx is done
+func (v *ViewManager) acquireViewSlot(ctx context.Context, relPath string) (func(), error) {
+	slots := v.viewSlotsFor(relPath)
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %s: %s", ErrViewBusy, relPath, ctx.Err())
+	}
+}
+
+// viewSlotsFor returns the semaphore of the view, or nil if its renders aren't
+// limited
+func (v *ViewManager) viewSlotsFor(relPath string) chan struct{} {
+	limit := 0
+	for _, rule := range v.concurrencyRules {
+		if matched, _ := path.Match(rule.Glob, relPath); matched {
+			limit = rule.Limit
+		}
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	v.viewSlotsLock.Lock()
+	defer v.viewSlotsLock.Unlock()
+
+	slots, ok := v.viewSlots[relPath]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		v.viewSlots[relPath] = slots
+	}
+
+	return slots
+}
+
+// cssMedia returns the media attribute of the CSS asset or an empty string if
+// none applies. The last matching rule wins
+func (v *ViewManager) cssMedia(name string) string {
+	media := ""
+	for _, rule := range v.cssMediaRules {
+		if matched, _ := path.Match(rule.Glob, name); matched {
+			media = rule.Media
+		}
+	}
+
+	return media
+}
+
 func (v *ViewManager) createCSSImportTags(assetImports []string) string {
 	output := ""
 	for _, rawPath := range assetImports {
@@ -112,7 +1103,12 @@ func (v *ViewManager) createCSSImportTags(assetImports []string) string {
 }
 
 func (v *ViewManager) createCSSImportTag(path string) string {
+	if media := v.cssMedia(path); len(media) > 0 {
+		format := "<link href=\"%s\" rel=\"stylesheet\" media=\"%s\">\n"
+		return fmt.Sprintf(format, assetURL(v.cssRoute, path), html.EscapeString(media))
+	}
+
 	format := "<link href=\"%s\" rel=\"stylesheet\">\n"
-	return fmt.Sprintf(format, filepath.Join(v.staticAssetsRoute, path))
+	return fmt.Sprintf(format, assetURL(v.cssRoute, path))
 
 }
@@ -0,0 +1,1041 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/mansoor-s/aviator/js"
+	"github.com/stretchr/testify/assert"
+)
+
+const testHTMLTemplate = `<html lang="{{.Lang}}"><head>{{.Head}}</head><body>{{.Body}}</body></html>`
+
+// fakeVM returns canned render output so pages can be rendered without
+// compiling any svelte components
+type fakeVM struct {
+	evalFn    func(path, expression string) (string, error)
+	initFn    func(path, source string) error
+	programFn func(path, source string, globals map[string]interface{}) (string, error)
+}
+
+func (f *fakeVM) RunScript(_ string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeVM) SetGlobal(_ string, _ interface{}) error {
+	return nil
+}
+
+func (f *fakeVM) InitializationScript(path, source string) error {
+	if f.initFn == nil {
+		return nil
+	}
+	return f.initFn(path, source)
+}
+
+func (f *fakeVM) Eval(_ context.Context, path, expression string) (string, error) {
+	return f.evalFn(path, expression)
+}
+
+// EvalProgram passes the program to evalFn with the globals declared before it
+// when programFn isn't set
+func (f *fakeVM) EvalProgram(_ context.Context, path, source string, globals map[string]interface{}) (string, error) {
+	if f.programFn != nil {
+		return f.programFn(path, source, globals)
+	}
+
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expression := ""
+	for _, name := range names {
+		value, err := json.Marshal(globals[name])
+		if err != nil {
+			return "", err
+		}
+		expression += fmt.Sprintf("var %s = %s;\n", name, value)
+	}
+
+	return f.evalFn(path, expression+source)
+}
+
+// newGojaTestVM returns a VM backed by a single goja runtime that has
+// evaluated script
+func newGojaTestVM(t testing.TB, script string) *fakeVM {
+	runtime := goja.New()
+	_, err := runtime.RunString(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeVM{
+		evalFn: func(_, expression string) (string, error) {
+			val, err := runtime.RunString(expression)
+			if err != nil {
+				return "", err
+			}
+			return val.String(), nil
+		},
+		programFn: func(_, source string, globals map[string]interface{}) (string, error) {
+			for name, value := range globals {
+				err := runtime.Set(name, value)
+				if err != nil {
+					return "", err
+				}
+			}
+			val, err := runtime.RunString(source)
+			if err != nil {
+				return "", err
+			}
+			return val.String(), nil
+		},
+		initFn: func(_, source string) error {
+			_, err := runtime.RunString(source)
+			return err
+		},
+	}
+}
+
+func newStaticRenderVM(output string) *fakeVM {
+	return &fakeVM{
+		evalFn: func(_, _ string) (string, error) {
+			return output, nil
+		},
+	}
+}
+
+// newTestViewManager creates a ViewManager with a single entrypoint view
+// named Index.svelte that has one JS and one CSS import
+func newTestViewManager(vm *fakeVM) (*ViewManager, *View) {
+	view := &View{
+		UniqueName:        "Index",
+		WrappedUniqueName: "__AviatorWrapped_Index",
+		RelPath:           "Index.svelte",
+		IsEntrypoint:      true,
+		JSImports:         []string{"Index.svelte.js"},
+		CSSImports:        []string{"Index.svelte.css"},
+	}
+
+	v := newViewManager(ViewManagerConfig{
+		VM:                vm,
+		Logger:            nopLogger{},
+		HTMLGenerator:     template.Must(template.New("test").Parse(testHTMLTemplate)),
+		StaticAssetsRoute: "/static",
+		HTMLLang:          "en",
+	})
+	v.views[view.RelPath] = view
+	v.cacheStaticHeadTags()
+
+	return v, view
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(_ string)  {}
+func (nopLogger) Error(_ string) {}
+
+func TestViewManager_Render(t *testing.T) {
+	vm := newStaticRenderVM(`{"head":"<title>Index</title>","body":"<h1>Hello</h1>"}`)
+	v, _ := newTestViewManager(vm)
+
+	out, err := v.Render(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, "<title>Index</title>")
+	assert.Contains(t, out, "<h1>Hello</h1>")
+	assert.Contains(t, out, `<script type="module" src="/static/Index.svelte.js" defer></script>`)
+	assert.Contains(t, out, `<link href="/static/Index.svelte.css" rel="stylesheet">`)
+	assert.Contains(t, out, propsScriptOpenTag+`{"name":"world"}`+propsScriptCloseTag)
+
+	_, err = v.Render(context.Background(), "Missing.svelte", nil)
+	assert.Error(t, err)
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestViewManager_RenderTo(t *testing.T) {
+	vm := newStaticRenderVM(`{"head":"<title>Index</title>","body":"<h1>Hello</h1>"}`)
+	v, _ := newTestViewManager(vm)
+
+	expected, err := v.Render(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = v.RenderTo(context.Background(), buf, "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, buf.String())
+
+	err = v.RenderTo(context.Background(), errWriter{}, "Index.svelte", nil)
+	assert.ErrorContains(t, err, "connection reset")
+
+	buf.Reset()
+	err = v.RenderTo(context.Background(), buf, "Missing.svelte", nil)
+	assert.Error(t, err)
+	assert.Zero(t, buf.Len())
+}
+
+func TestViewManager_RenderFirst(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+
+	out, err := v.RenderFirst(context.Background(), []string{"tenants/acme/Index.svelte", "Index.svelte"}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<h1>Hello</h1>")
+	assert.Contains(t, out, "Index.svelte.js")
+
+	_, err = v.RenderFirst(context.Background(), []string{"tenants/acme/Index.svelte", "Missing.svelte"}, nil)
+	assert.Error(t, err)
+}
+
+func TestViewManager_RenderAll(t *testing.T) {
+	vm := newGojaTestVM(t, `
+		var __aviator__ = {
+			render: function(name, props) {
+				return JSON.stringify({ body: "<h1>" + name + " " + props.title + "</h1>" })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+	v.views["About.svelte"] = &View{
+		UniqueName:        "About",
+		WrappedUniqueName: "__AviatorWrapped_About",
+		RelPath:           "About.svelte",
+		IsEntrypoint:      true,
+	}
+	v.views["+layout.svelte"] = &View{RelPath: "+layout.svelte"}
+
+	pages, err := v.RenderAll(context.Background(), func(view string) interface{} {
+		return map[string]string{"title": strings.TrimSuffix(view, ".svelte")}
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, pages, 2)
+	assert.Contains(t, pages["Index.svelte"], "<h1>__AviatorWrapped_Index Index</h1>")
+	assert.Contains(t, pages["About.svelte"], "<h1>__AviatorWrapped_About About</h1>")
+}
+
+func TestViewManager_Render_Locale(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Bonjour</h1>"}`))
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte(`"hello"`)}
+	v.staticContent["Index.svelte.fr.js"] = StaticAsset{Content: []byte(`"bonjour"`)}
+	v.cacheStaticHeadTags()
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil, Locale("fr"))
+	assert.NoError(t, err)
+	assert.Contains(t, out, `src="/static/Index.svelte.fr.js"`)
+	assert.NotContains(t, out, `src="/static/Index.svelte.js"`)
+	//no CSS variant exists
+	assert.Contains(t, out, `href="/static/Index.svelte.css"`)
+
+	out, err = v.Render(context.Background(), "Index.svelte", nil, Locale("fr-CA"))
+	assert.NoError(t, err)
+	assert.Contains(t, out, `src="/static/Index.svelte.fr.js"`)
+
+	out, err = v.Render(context.Background(), "Index.svelte", nil, Locale("de"))
+	assert.NoError(t, err)
+	assert.Contains(t, out, `src="/static/Index.svelte.js"`)
+}
+
+func TestViewManager_CacheStaticHeadTags(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+
+	v.staticContent[baseCSSStyleName] = StaticAsset{MimeType: "text/css"}
+	v.cacheStaticHeadTags()
+
+	jsIdx := strings.Index(view.staticHeadTags, "Index.svelte.js")
+	baseIdx := strings.Index(view.staticHeadTags, baseCSSStyleName)
+	cssIdx := strings.Index(view.staticHeadTags, "Index.svelte.css")
+
+	assert.True(t, jsIdx >= 0 && jsIdx < baseIdx && baseIdx < cssIdx)
+}
+
+func TestViewManager_CacheStaticHeadTags_LayoutCSSOrder(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	rootLayout := &View{RelPath: "+layout.svelte", CSSImports: []string{"Layout.svelte.css"}}
+	adminLayout := &View{RelPath: "admin/+layout.svelte", CSSImports: []string{"AdminLayout.svelte.css"}}
+	//the nearest layout is first
+	view.ApplicableLayoutViews = []*View{adminLayout, rootLayout}
+	v.staticContent[baseCSSStyleName] = StaticAsset{MimeType: "text/css"}
+	v.cacheStaticHeadTags()
+
+	//outermost layout first, page last
+	baseIdx := strings.Index(view.staticHeadTags, baseCSSStyleName)
+	rootIdx := strings.Index(view.staticHeadTags, `href="/static/Layout.svelte.css"`)
+	adminIdx := strings.Index(view.staticHeadTags, `href="/static/AdminLayout.svelte.css"`)
+	pageIdx := strings.Index(view.staticHeadTags, `href="/static/Index.svelte.css"`)
+	assert.True(t, baseIdx >= 0 && baseIdx < rootIdx && rootIdx < adminIdx && adminIdx < pageIdx, view.staticHeadTags)
+
+	_, cssURLs := v.assetURLs(view, "")
+	assert.Equal(t, []string{
+		"/static/" + baseCSSStyleName,
+		"/static/Layout.svelte.css",
+		"/static/AdminLayout.svelte.css",
+		"/static/Index.svelte.css",
+	}, cssURLs)
+}
+
+func TestViewManager_CacheStaticHeadTags_CSSMedia(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	view.CSSImports = append(view.CSSImports, "Print.svelte.css")
+	v.cssMediaRules = []CSSMediaRule{{Glob: "Print*.css", Media: "print"}}
+	v.cacheStaticHeadTags()
+
+	assert.Contains(t, view.staticHeadTags, `<link href="/static/Print.svelte.css" rel="stylesheet" media="print">`)
+	assert.Contains(t, view.staticHeadTags, `<link href="/static/Index.svelte.css" rel="stylesheet">`)
+}
+
+func BenchmarkViewManager_Render(b *testing.B) {
+	vm := newStaticRenderVM(`{"head":"<title>Index</title>","body":"<h1>Hello</h1>"}`)
+	v, _ := newTestViewManager(vm)
+	props := map[string]string{"name": "world"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := v.Render(context.Background(), "Index.svelte", props)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestViewManager_Render_SSROnlyProps(t *testing.T) {
+	type pageProps struct {
+		Title   string   `json:"title"`
+		Dataset []string `json:"dataset" aviator:"ssr-only"`
+	}
+
+	var renderExpr string
+	vm := &fakeVM{
+		evalFn: func(_, expression string) (string, error) {
+			renderExpr = expression
+			return `{"body":"<h1>Hello</h1>"}`, nil
+		},
+	}
+	v, _ := newTestViewManager(vm)
+
+	props := pageProps{Title: "Cars", Dataset: []string{"server-only-row"}}
+	out, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	assert.Contains(t, renderExpr, "server-only-row")
+	assert.Contains(t, out, propsScriptOpenTag+`{"title":"Cars"}`+propsScriptCloseTag)
+	assert.NotContains(t, out, "server-only-row")
+}
+
+func TestViewManager_Render_SSROnlyPropsInMap(t *testing.T) {
+	type dataset struct {
+		Name string   `json:"name"`
+		Rows []string `json:"rows" aviator:"ssr-only"`
+	}
+
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	props := map[string]interface{}{"title": "Cars", "dataset": dataset{Name: "cars", Rows: []string{"server-only-row"}}}
+	out, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, propsScriptOpenTag+`{"dataset":{"name":"cars"},"title":"Cars"}`+propsScriptCloseTag)
+	assert.NotContains(t, out, "server-only-row")
+}
+
+func TestViewManager_Render_PropsScriptInjection(t *testing.T) {
+	vm := newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`)
+	v, _ := newTestViewManager(vm)
+	//an encoder that doesn't escape HTML
+	v.propsEncoder = func(props interface{}) ([]byte, error) {
+		buf := new(bytes.Buffer)
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(props)
+		return bytes.TrimSpace(buf.Bytes()), err
+	}
+
+	payload := "</script><script>alert(1)</script><!-- & "
+	out, err := v.Render(context.Background(), "Index.svelte", map[string]string{"name": payload})
+	assert.NoError(t, err)
+	assert.NotContains(t, out, "<script>alert(1)")
+	assert.NotContains(t, out, "<!-- &")
+
+	start := strings.Index(out, propsScriptOpenTag) + len(propsScriptOpenTag)
+	end := strings.Index(out[start:], propsScriptCloseTag)
+	if assert.True(t, end >= 0) {
+		props := map[string]string{}
+		assert.NoError(t, json.Unmarshal([]byte(out[start:start+end]), &props))
+		assert.Equal(t, payload, props["name"])
+	}
+}
+
+func TestViewManager_Render_CompactPropsScript(t *testing.T) {
+	vm := newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`)
+	v, _ := newTestViewManager(vm)
+	v.compactPropsScript = true
+	v.hasStoreInitializer = true
+	v.propsEncoder = func(props interface{}) ([]byte, error) {
+		encoded, err := json.MarshalIndent(props, "", "  ")
+		return append(encoded, '\n'), err
+	}
+
+	result, err := v.RenderView(
+		context.Background(),
+		"Index.svelte",
+		map[string]string{"name": "world"},
+		RenderContext(map[string]interface{}{"user": "ada"}),
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, propsScriptOpenTag+`{"name":"world"}</script>`, result.PropsScript)
+	assert.True(t, strings.HasSuffix(result.HeadTags, `{"user":"ada"}</script>`))
+}
+
+func TestViewManager_Render_OmitNilPropsScript(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+
+	//the props script is kept for nil props unless enabled
+	result, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, propsScriptOpenTag+`{}`+propsScriptCloseTag, result.PropsScript)
+
+	v.omitNilPropsScript = true
+	result, err = v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, result.PropsScript)
+
+	result, err = v.RenderView(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+	assert.Equal(t, propsScriptOpenTag+`{"name":"world"}`+propsScriptCloseTag, result.PropsScript)
+
+	//the client props decide when they're set
+	result, err = v.RenderView(context.Background(), "Index.svelte", map[string]string{"name": "world"}, ClientProps(nil))
+	assert.NoError(t, err)
+	assert.Empty(t, result.PropsScript)
+}
+
+func TestViewManager_Render_DeterministicSSR(t *testing.T) {
+	vm := newGojaTestVM(t, `
+		var __aviator__ = {
+			render: function(name, props) {
+				return JSON.stringify({ body: "<p>" + Math.random() + " " + Math.random() + "</p>" })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+	render := func() string {
+		result, err := v.RenderView(context.Background(), "Index.svelte", nil)
+		assert.NoError(t, err)
+		return result.Body
+	}
+
+	v.deterministicSSR = true
+	v.ssrSeed = 42
+	first := render()
+	assert.Equal(t, first, render())
+
+	v.ssrSeed = 7
+	assert.NotEqual(t, first, render())
+}
+
+func TestViewManager_Render_UnsupportedProps(t *testing.T) {
+	type pageProps struct {
+		Title   string   `json:"title"`
+		Updates chan int `json:"updates"`
+	}
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	props := pageProps{Title: "Cars", Updates: make(chan int)}
+
+	_, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Index.svelte")
+	assert.NotContains(t, err.Error(), "props.updates")
+
+	v.isDevMode = true
+	_, err = v.Render(context.Background(), "Index.svelte", props)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Index.svelte")
+	assert.Contains(t, err.Error(), "props.updates can't be serialized")
+}
+
+func TestViewManager_Render_InvalidOutput(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`[object Object]`))
+	_, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.ErrorIs(t, err, ErrInvalidRenderOutput)
+	assert.Contains(t, err.Error(), "Index.svelte")
+	assert.Contains(t, err.Error(), `returned "[object Object]"`)
+
+	v, _ = newTestViewManager(newStaticRenderVM(``))
+	_, err = v.Render(context.Background(), "Index.svelte", nil)
+	assert.ErrorIs(t, err, ErrInvalidRenderOutput)
+	assert.Contains(t, err.Error(), "rendered nothing")
+
+	//long outputs are truncated without splitting characters
+	v, _ = newTestViewManager(newStaticRenderVM("Error: " + strings.Repeat("é", maxRenderOutputInError)))
+	_, err = v.Render(context.Background(), "Index.svelte", nil)
+	assert.ErrorIs(t, err, ErrInvalidRenderOutput)
+	assert.Contains(t, err.Error(), `é..."`)
+	assert.Less(t, len(err.Error()), maxRenderOutputInError+200)
+}
+
+func TestViewManager_Render_JSError(t *testing.T) {
+	viewsDir := t.TempDir()
+	v, _ := newTestViewManager(&fakeVM{
+		evalFn: func(_, _ string) (string, error) {
+			return "", &js.JSError{
+				Message: "TypeError: Cannot read property 'name' of undefined",
+				Stack: []js.StackFrame{
+					{Function: "render", File: "svelte:" + filepath.Join(viewsDir, "users", "Profile.svelte"), Line: 3, Column: 16},
+					{File: "runtime_renderer", Line: 1, Column: 15},
+				},
+			}
+		},
+	})
+	v.viewsDir = viewsDir
+
+	//frames name the components relative to the views directory
+	_, err := v.Render(context.Background(), "Index.svelte", nil)
+	var jsErr *js.JSError
+	assert.True(t, errors.As(err, &jsErr))
+	assert.Equal(t, filepath.Join("users", "Profile.svelte"), jsErr.Stack[0].File)
+	assert.Equal(t, "runtime_renderer", jsErr.Stack[1].File)
+	assert.Contains(t, err.Error(), "at render ("+filepath.Join("users", "Profile.svelte")+":3:16)")
+}
+
+func TestViewManager_Render_LayoutProps(t *testing.T) {
+	//renders the layout and the page with the props the layout wrapper gives them
+	vm := newGojaTestVM(t, wrappedPropsFuncs+`
+		var __aviator__ = {
+			render: function(name, props) {
+				return JSON.stringify({
+					body: "<nav>" + layoutProps(props).title + "</nav><main>" + pageProps(props).title + "</main>"
+				})
+			}
+		};
+	`)
+	v, view := newTestViewManager(vm)
+	view.ApplicableLayoutViews = []*View{{UniqueName: "Layout", RelPath: "+layout.svelte"}}
+	props := map[string]string{"title": "Page"}
+
+	//layouts get the page props by default
+	out, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<nav>Page</nav><main>Page</main>")
+
+	result, err := v.RenderView(context.Background(), "Index.svelte", props, LayoutProps(map[string]string{"title": "Site"}))
+	assert.NoError(t, err)
+	assert.Contains(t, result.Body, "<nav>Site</nav><main>Page</main>")
+	assert.Contains(t, result.PropsScript, `{"__aviator_layout_props":{"title":"Site"},"title":"Page"}`)
+
+	payload, err := v.PropsScriptFor("Index.svelte", props, LayoutProps(map[string]string{"title": "Site"}))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"__aviator_layout_props":{"title":"Site"},"title":"Page"}`, payload)
+
+	//isolated layouts get nothing without layout props
+	v.isolateLayoutProps = true
+	out, err = v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<nav>undefined</nav><main>Page</main>")
+
+	_, err = v.Render(context.Background(), "Index.svelte", []string{"a"}, LayoutProps(nil))
+	assert.ErrorContains(t, err, "JSON object")
+}
+
+func TestViewManager_Render_OmitPropsScript(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+
+	out, err := v.Render(context.Background(), "Index.svelte", map[string]string{"name": "world"}, OmitPropsScript())
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, "<h1>Hello</h1>")
+	assert.Contains(t, out, "Index.svelte.js")
+	assert.NotContains(t, out, "__aviator_props")
+}
+
+func TestViewManager_Render_AssetRoutes(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	v.jsRoute = "https://js.example.com/assets/"
+	v.cssRoute = "/css"
+	v.cacheStaticHeadTags()
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, out, `<script type="module" src="https://js.example.com/assets/Index.svelte.js" defer></script>`)
+	assert.Contains(t, out, `<link href="/css/Index.svelte.css" rel="stylesheet">`)
+}
+
+func TestViewManager_Render_ImportMap(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	v.useImportMap = true
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte("console.log(1)")}
+	v.cacheStaticHeadTags()
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+
+	assert.Regexp(t, `<script type="importmap">\{"imports":\{"@aviator/Index.svelte.js":"/static/Index.svelte.js\?v=[0-9a-f]{20}"\}\}</script>`, out)
+	assert.Contains(t, out, `<script type="module">import "@aviator/Index.svelte.js";</script>`)
+	assert.NotContains(t, out, `src="/static/Index.svelte.js"`)
+	assert.Equal(t, 1, strings.Count(out, `<script type="module"`))
+}
+
+func TestViewManager_RenderView_Meta(t *testing.T) {
+	vm := newStaticRenderVM(`{"body":"","meta":{"status":302,"redirect":"/login","headers":{"Cache-Control":"no-store"}}}`)
+	v, _ := newTestViewManager(vm)
+
+	result, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 302, result.Meta.Status)
+	assert.Equal(t, "/login", result.Meta.Redirect)
+	assert.Equal(t, map[string]string{"Cache-Control": "no-store"}, result.Meta.Headers)
+	assert.Contains(t, result.HTML, "<html")
+}
+
+func TestViewManager_RenderView_ErrorView(t *testing.T) {
+	vm := newGojaTestVM(t, `
+		var __aviator__ = {
+			render: function(name, props) {
+				if (name === "__AviatorWrapped_Index") {
+					throw new Error("boom")
+				}
+				return JSON.stringify({ body: "<h1>" + props.status + " " + props.message + "</h1>" })
+			}
+		};
+	`)
+	v, view := newTestViewManager(vm)
+	errorView := &View{
+		UniqueName:        "Error",
+		WrappedUniqueName: "__AviatorWrapped_Error",
+		RelPath:           "+error.svelte",
+		IsEntrypoint:      true,
+		IsErrorView:       true,
+	}
+	v.views[errorView.RelPath] = errorView
+
+	//without an error view the render error is returned
+	_, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.ErrorContains(t, err, "boom")
+
+	view.ErrorView = errorView
+	result, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 500, result.Meta.Status)
+	//the error isn't exposed outside of dev mode
+	assert.Contains(t, result.Body, "<h1>500 Internal Server Error</h1>")
+	assert.NotContains(t, result.HTML, "boom")
+
+	v.isDevMode = true
+	result, err = v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, result.Body, "<h1>500 Error: boom")
+	assert.Contains(t, result.PropsScript, "boom")
+
+	//error views aren't pages of their own
+	pages, err := v.RenderAll(context.Background(), func(string) interface{} { return nil })
+	assert.NoError(t, err)
+	assert.Len(t, pages, 1)
+	assert.Contains(t, pages, "Index.svelte")
+}
+
+func TestViewManager_RenderView_ComponentHead(t *testing.T) {
+	//emulates a component using <svelte:head>
+	vm := newStaticRenderVM(`{"head":"<title>Cars</title><meta name=\"description\" content=\"All cars\">","body":"<h1>Cars</h1>"}`)
+	v, _ := newTestViewManager(vm)
+
+	result, err := v.RenderView(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `<title>Cars</title><meta name="description" content="All cars">`, result.ComponentHead)
+	assert.NotContains(t, result.HeadTags, "<title>")
+	assert.Contains(t, result.HeadTags, "Index.svelte.js")
+	assert.NotContains(t, result.ComponentHead, "Index.svelte.js")
+	assert.Equal(t, "<h1>Cars</h1>", result.Body)
+	assert.Equal(t, propsScriptOpenTag+`{"name":"world"}`+propsScriptCloseTag, result.PropsScript)
+	assert.Contains(t, result.HTML, result.ComponentHead+"\n"+result.HeadTags+result.PropsScript)
+}
+
+func TestViewManager_Render_PropsReviver(t *testing.T) {
+	reviver := `function(key, value) {
+		if (typeof value === "string" && /^\d{4}-\d{2}-\d{2}T/.test(value)) {
+			return new Date(value)
+		}
+		return value
+	}`
+
+	//the fake render outputs the type of the props it received
+	vm := newGojaTestVM(t, `
+		var __aviator_props_reviver = (`+reviver+`);
+		var __aviator__ = {
+			render: function(name, props, context) {
+				var created = props.created
+				return JSON.stringify({ body: String(created instanceof Date) + ":" + created.getTime() })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+	v.propsReviver = reviver
+
+	created := time.Date(2022, 11, 1, 10, 30, 0, 0, time.UTC)
+	out, err := v.Render(context.Background(), "Index.svelte", map[string]time.Time{"created": created})
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, fmt.Sprintf("true:%d", created.UnixMilli()))
+
+	//the browser parses the embedded props script with the same reviver
+	startIdx := strings.Index(out, propsScriptOpenTag) + len(propsScriptOpenTag)
+	endIdx := strings.Index(out[startIdx:], propsScriptCloseTag) + startIdx
+	val, err := vm.Eval(context.Background(), "", fmt.Sprintf("JSON.parse(%q, __aviator_props_reviver).created.getTime()", out[startIdx:endIdx]))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(created.UnixMilli()), val)
+}
+
+func TestViewManager_Render_StoreInitializer(t *testing.T) {
+	//emulates the SSR bundle: stores are seeded from the context before rendering
+	vm := newGojaTestVM(t, `
+		var userStore = { value: "" };
+		function initStores(context) { userStore.value = context.user }
+		var __aviator__ = {
+			render: function(name, props, context) {
+				initStores(context)
+				return JSON.stringify({ body: "<p>" + userStore.value + "</p>" })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+	v.hasStoreInitializer = true
+
+	out, err := v.Render(
+		context.Background(),
+		"Index.svelte",
+		nil,
+		RenderContext(map[string]interface{}{"user": "ada"}),
+	)
+	assert.NoError(t, err)
+
+	assert.Contains(t, out, "<p>ada</p>")
+	assert.Contains(t, out, `<script id="__aviator_context" type="text/template" defer>{"user":"ada"}</script>`)
+}
+
+func TestViewManager_Render_BuildInfo(t *testing.T) {
+	vm := newGojaTestVM(t, `
+		var __aviator__ = {
+			render: function(name, props, context) {
+				var buildInfo = new Map(Object.entries(context)).get("__aviator_build_info")
+				return JSON.stringify({ body: "<footer>v" + buildInfo.version + " " + context.user + "</footer>" })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+	v.buildInfo = map[string]string{"version": "1.2.0"}
+
+	out, err := v.Render(
+		context.Background(),
+		"Index.svelte",
+		nil,
+		RenderContext(map[string]interface{}{"user": "ada"}),
+	)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<footer>v1.2.0 ada</footer>")
+}
+
+func TestViewManager_Render_ClientProps(t *testing.T) {
+	var renderProps interface{}
+	vm := &fakeVM{
+		programFn: func(_, _ string, globals map[string]interface{}) (string, error) {
+			renderProps = globals[renderPropsGlobal]
+			return `{"body":"<h1>Hello</h1>"}`, nil
+		},
+	}
+	v, _ := newTestViewManager(vm)
+
+	ssrProps := map[string]interface{}{"title": "Cars", "rows": []string{"a", "b"}}
+	out, err := v.Render(
+		context.Background(),
+		"Index.svelte",
+		ssrProps,
+		ClientProps(map[string]interface{}{"title": "Cars"}),
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"rows":["a","b"],"title":"Cars"}`, renderProps)
+	assert.Contains(t, out, propsScriptOpenTag+`{"title":"Cars"}`+propsScriptCloseTag)
+
+	out, err = v.Render(context.Background(), "Index.svelte", ssrProps, ClientProps(nil))
+	assert.NoError(t, err)
+	assert.Contains(t, out, propsScriptOpenTag+`{}`+propsScriptCloseTag)
+
+	//ssr-only fields are left out of the client props too
+	type user struct {
+		Name  string `json:"name"`
+		Token string `json:"token" aviator:"ssr-only"`
+	}
+	out, err = v.Render(
+		context.Background(),
+		"Index.svelte",
+		ssrProps,
+		ClientProps(map[string]interface{}{"user": user{Name: "ada", Token: "secret"}}),
+	)
+	assert.NoError(t, err)
+	assert.Contains(t, out, propsScriptOpenTag+`{"user":{"name":"ada"}}`+propsScriptCloseTag)
+	assert.NotContains(t, out, "secret")
+}
+
+func TestViewManager_RenderWithTags(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"head":"<title>Index</title>","body":"<h1>Hello</h1>"}`))
+	props := map[string]string{"name": "world"}
+
+	body, headTags, propsScript, err := v.RenderWithTags(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "<h1>Hello</h1>", body)
+	assert.Contains(t, headTags, "<title>Index</title>")
+	assert.Contains(t, headTags, `<script type="module" src="/static/Index.svelte.js" defer></script>`)
+	assert.Equal(t, propsScriptOpenTag+`{"name":"world"}`+propsScriptCloseTag, propsScript)
+
+	out, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	buf := new(strings.Builder)
+	err = v.htmlGenerator.Execute(buf, ssrData{Head: headTags + propsScript, Body: body, Lang: "en"})
+	assert.NoError(t, err)
+	assert.Equal(t, out, buf.String())
+}
+
+func TestViewManager_RenderJSON(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(
+		`{"head":"<title>Index</title>","body":"<h1>Hello</h1>","meta":{"status":201}}`,
+	))
+	v.staticContent[baseCSSStyleName] = StaticAsset{}
+
+	output, err := v.RenderJSON(context.Background(), "Index.svelte", map[string]string{"name": "world"})
+	assert.NoError(t, err)
+
+	rendered := JSONRender{}
+	assert.NoError(t, json.Unmarshal(output, &rendered))
+	assert.Equal(t, "<title>Index</title>", rendered.Head)
+	assert.Equal(t, "<h1>Hello</h1>", rendered.Body)
+	assert.Equal(t, []string{"/static/Index.svelte.js"}, rendered.JS)
+	assert.Equal(t, []string{"/static/" + baseCSSStyleName, "/static/Index.svelte.css"}, rendered.CSS)
+	assert.JSONEq(t, `{"name":"world"}`, string(rendered.Props))
+	assert.Equal(t, 201, rendered.Meta.Status)
+
+	output, err = v.RenderJSON(context.Background(), "Index.svelte", nil, OmitPropsScript())
+	assert.NoError(t, err)
+	assert.Contains(t, string(output), `"props":null`)
+
+	_, err = v.RenderJSON(context.Background(), "Missing.svelte", nil)
+	assert.Error(t, err)
+}
+
+func TestViewManager_PropsScriptFor(t *testing.T) {
+	type pageProps struct {
+		Title   string   `json:"title"`
+		Dataset []string `json:"dataset" aviator:"ssr-only"`
+	}
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+
+	props := pageProps{Title: "</script><script>alert(1)</script>", Dataset: []string{"server-only-row"}}
+	_, _, propsScript, err := v.RenderWithTags(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	payload, err := v.PropsScriptFor("Index.svelte", props)
+	assert.NoError(t, err)
+	assert.Equal(t, propsScriptOpenTag+payload+propsScriptCloseTag, propsScript)
+	assert.NotContains(t, payload, "</script>")
+	assert.NotContains(t, payload, "server-only-row")
+
+	payload, err = v.PropsScriptFor("Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", payload)
+
+	_, err = v.PropsScriptFor("Missing.svelte", props)
+	assert.Error(t, err)
+
+	//an indenting encoder that doesn't escape HTML, with compaction
+	v.propsEncoder = func(props interface{}) ([]byte, error) {
+		buf := new(bytes.Buffer)
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(props)
+		return buf.Bytes(), err
+	}
+	v.compactPropsScript = true
+	_, _, propsScript, err = v.RenderWithTags(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	payload, err = v.PropsScriptFor("Index.svelte", props)
+	assert.NoError(t, err)
+	assert.Equal(t, propsScriptOpenTag+payload+"</script>", propsScript)
+	assert.NotContains(t, payload, "</script>")
+	assert.NotContains(t, payload, "\n")
+}
+
+func TestViewManager_Render_RenderableViews(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	v.views["partials/Nav.svelte"] = &View{
+		UniqueName:        "PartialsNav",
+		WrappedUniqueName: "__AviatorWrapped_PartialsNav",
+		RelPath:           "partials/Nav.svelte",
+	}
+	v.renderableViews = []string{"*.svelte"}
+
+	_, err := v.Render(context.Background(), "partials/Nav.svelte", nil)
+	assert.True(t, errors.Is(err, ErrViewNotRenderable))
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "<h1>Hello</h1>")
+}
+
+// cancelWriter cancels the render after n writes
+type cancelWriter struct {
+	buf     strings.Builder
+	n       int
+	cancel  context.CancelFunc
+	flushes int
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.n--
+	if w.n == 0 {
+		w.cancel()
+	}
+	return w.buf.Write(p)
+}
+
+func (w *cancelWriter) Flush() {
+	w.flushes++
+}
+
+func TestViewManager_RenderStreamList(t *testing.T) {
+	vm := newGojaTestVM(t, `
+		var __aviator__ = {
+			render: function(name, props) {
+				return JSON.stringify({ body: "<li>" + props.item + "</li>" })
+			}
+		};
+	`)
+	v, _ := newTestViewManager(vm)
+
+	propsChan := make(chan interface{}, 5)
+	for i := 1; i <= 5; i++ {
+		propsChan <- map[string]int{"item": i}
+	}
+	close(propsChan)
+
+	buf := new(strings.Builder)
+	err := v.RenderStreamList(context.Background(), buf, "Index.svelte", propsChan)
+	assert.NoError(t, err)
+	assert.Equal(t, "<li>1</li><li>2</li><li>3</li><li>4</li><li>5</li>", buf.String())
+
+	//cancelled after the second item
+	propsChan = make(chan interface{}, 5)
+	for i := 1; i <= 5; i++ {
+		propsChan <- map[string]int{"item": i}
+	}
+	close(propsChan)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelWriter{n: 2, cancel: cancel}
+
+	err = v.RenderStreamList(ctx, w, "Index.svelte", propsChan)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, "<li>1</li><li>2</li>", w.buf.String())
+	assert.Equal(t, 2, w.flushes)
+}
+
+func TestViewManager_Render_ViewConcurrencyLimit(t *testing.T) {
+	started := make(chan string, 2)
+	unblock := make(chan struct{})
+	vm := &fakeVM{
+		evalFn: func(_, expression string) (string, error) {
+			if strings.Contains(expression, "__AviatorWrapped_Heavy") {
+				started <- "Heavy"
+				<-unblock
+			}
+			return `{"body":"<h1>Hello</h1>"}`, nil
+		},
+	}
+	v, _ := newTestViewManager(vm)
+	v.views["Heavy.svelte"] = &View{
+		UniqueName:        "Heavy",
+		WrappedUniqueName: "__AviatorWrapped_Heavy",
+		RelPath:           "Heavy.svelte",
+		IsEntrypoint:      true,
+	}
+	v.concurrencyRules = []ViewConcurrencyRule{{Glob: "Heavy.svelte", Limit: 1}}
+
+	firstDone := make(chan error)
+	go func() {
+		_, err := v.Render(context.Background(), "Heavy.svelte", nil)
+		firstDone <- err
+	}()
+	<-started
+
+	//the heavy view is at its limit
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := v.Render(ctx, "Heavy.svelte", nil)
+	assert.ErrorIs(t, err, ErrViewBusy)
+
+	//other views aren't limited by it
+	_, err = v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+
+	//a waiting render continues once the slot is released
+	secondDone := make(chan error)
+	go func() {
+		_, err := v.Render(context.Background(), "Heavy.svelte", nil)
+		secondDone <- err
+	}()
+	close(unblock)
+	assert.NoError(t, <-firstDone)
+	assert.NoError(t, <-secondDone)
+	assert.Len(t, started, 1)
+}
+
+func TestViewManager_Render_CancelledContext(t *testing.T) {
+	evaluated := false
+	vm := &fakeVM{
+		evalFn: func(_, _ string) (string, error) {
+			evaluated = true
+			return `{"body":"<h1>Hello</h1>"}`, nil
+		},
+	}
+	v, _ := newTestViewManager(vm)
+
+	//i.e. the client of the HTTP request disconnected
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := v.Render(ctx, "Index.svelte", nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, evaluated)
+}
+
+func TestViewManager_StaticAssetNames(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	v.staticContent = map[string]StaticAsset{
+		"Index.svelte.js":  {MimeType: "text/javascript"},
+		"Index.svelte.css": {MimeType: "text/css"},
+		baseCSSStyleName:   {MimeType: "text/css"},
+		"robots.txt":       {MimeType: "text/plain"},
+	}
+
+	names := v.StaticAssetNames()
+	assert.Equal(t, []string{"Index.svelte.css", "Index.svelte.js", baseCSSStyleName, "robots.txt"}, names)
+}
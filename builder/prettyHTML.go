@@ -0,0 +1,141 @@
+package builder
+
+import (
+	"strings"
+)
+
+// prettyHTMLIndent is the indentation of each nesting level of pretty HTML
+const prettyHTMLIndent = "  "
+
+// voidElements have no closing tag and don't increase the nesting level
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// preservedElements are written exactly as they were rendered, whitespace is
+// significant in them or they contain code
+var preservedElements = map[string]bool{
+	"script": true, "style": true, "pre": true, "textarea": true,
+}
+
+// prettyHTML puts every tag of document on its own line, indented by its
+// nesting level. Elements containing only text stay on one line. Whitespace
+// between tags is replaced, so it's meant for inspecting output, not serving it
+func prettyHTML(document string) string {
+	var b strings.Builder
+	depth := 0
+	writeLine := func(s string) {
+		b.WriteString(strings.Repeat(prettyHTMLIndent, depth))
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+
+	for i := 0; i < len(document); {
+		if document[i] != '<' {
+			end := strings.IndexByte(document[i:], '<')
+			if end < 0 {
+				end = len(document) - i
+			}
+			if text := strings.TrimSpace(document[i : i+end]); len(text) > 0 {
+				writeLine(text)
+			}
+			i += end
+			continue
+		}
+
+		if strings.HasPrefix(document[i:], "<!--") {
+			end := strings.Index(document[i:], "-->")
+			if end < 0 {
+				end = len(document) - i
+			} else {
+				end += len("-->")
+			}
+			writeLine(document[i : i+end])
+			i += end
+			continue
+		}
+
+		end := tagEnd(document, i)
+		tag := document[i:end]
+		name := tagName(tag)
+
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			if depth > 0 {
+				depth--
+			}
+			writeLine(tag)
+		case strings.HasPrefix(tag, "<!"), voidElements[name], strings.HasSuffix(tag, "/>"):
+			writeLine(tag)
+		case preservedElements[name]:
+			end = closingTagEnd(document, end, name)
+			writeLine(document[i:end])
+		default:
+			//keep elements with only text, i.e. <title>Page</title>, on one line
+			textEnd := strings.IndexByte(document[end:], '<')
+			closingTag := "</" + name + ">"
+			if textEnd >= 0 && hasPrefixFold(document[end+textEnd:], closingTag) {
+				text := strings.TrimSpace(document[end : end+textEnd])
+				writeLine(tag + text + closingTag)
+				end += textEnd + len(closingTag)
+				break
+			}
+			writeLine(tag)
+			depth++
+		}
+		i = end
+	}
+
+	return b.String()
+}
+
+// tagEnd returns the index after the > closing the tag starting at start,
+// skipping quoted attribute values
+func tagEnd(document string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(document); i++ {
+		switch c := document[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(document)
+}
+
+// tagName returns the lower cased element name of a tag
+func tagName(tag string) string {
+	name := strings.TrimLeft(tag, "</")
+	end := strings.IndexAny(name, " \t\r\n/>")
+	if end >= 0 {
+		name = name[:end]
+	}
+	return strings.ToLower(name)
+}
+
+// closingTagEnd returns the index after the closing tag of the element name
+// whose content starts at start. The end of document is returned if there's none
+func closingTagEnd(document string, start int, name string) int {
+	closingTag := "</" + name + ">"
+	for i := start; ; i++ {
+		next := strings.IndexByte(document[i:], '<')
+		if next < 0 {
+			return len(document)
+		}
+		i += next
+		if hasPrefixFold(document[i:], closingTag) {
+			return i + len(closingTag)
+		}
+	}
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
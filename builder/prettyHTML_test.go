@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrettyHTML(t *testing.T) {
+	document := `<!DOCTYPE html><html lang="en"><head><title> Index </title>` +
+		`<meta charset="utf-8"><link href="/static/Index.svelte.css" rel="stylesheet">` +
+		`<script type="module">if (a < b) { console.log("<div>") }</script></head>` +
+		`<body><!-- HTML_TAG_START --><div class="a>b"><p>Hello <b>world</b></p><br/>` +
+		`<pre>  keep
+  this</pre></div></body></html>`
+
+	expected := `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <title>Index</title>
+    <meta charset="utf-8">
+    <link href="/static/Index.svelte.css" rel="stylesheet">
+    <script type="module">if (a < b) { console.log("<div>") }</script>
+  </head>
+  <body>
+    <!-- HTML_TAG_START -->
+    <div class="a>b">
+      <p>
+        Hello
+        <b>world</b>
+      </p>
+      <br/>
+      <pre>  keep
+  this</pre>
+    </div>
+  </body>
+</html>
+`
+	assert.Equal(t, expected, prettyHTML(document))
+}
+
+func TestViewManager_RenderView_PrettyHTML(t *testing.T) {
+	vm := newStaticRenderVM(`{"head":"<title>Index</title>","body":"<main><h1>Hello</h1></main>"}`)
+	v, _ := newTestViewManager(vm)
+
+	compact, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, compact.HTML, "<body><main><h1>Hello</h1></main></body>")
+
+	v.prettyHTML = true
+	pretty, err := v.RenderView(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, pretty.HTML, "  <body>\n    <main>\n      <h1>Hello</h1>\n    </main>\n  </body>\n")
+	//the parts aren't formatted
+	assert.Equal(t, compact.Body, pretty.Body)
+
+	//the same document apart from the whitespace between tags
+	assert.Equal(t, stripTagWhitespace(compact.HTML), stripTagWhitespace(pretty.HTML))
+}
+
+func stripTagWhitespace(document string) string {
+	var lines []string
+	for _, line := range strings.Split(document, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,549 @@
+package builder
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// props struct fields tagged with `aviator:"ssr-only"` are passed to the SSR render
+// but are left out of the __aviator_props hydration script
+const propsTagName = "aviator"
+const ssrOnlyTagValue = "ssr-only"
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// clientProps returns props with all ssr-only fields removed along with a boolean
+// indicating whether props contained any. props is returned as-is if it doesn't
+func clientProps(props interface{}) (interface{}, bool) {
+	if props == nil {
+		return nil, false
+	}
+
+	return stripSSROnlyFields(reflect.ValueOf(props), map[uintptr]bool{})
+}
+
+// ssrOnlyTypes caches mayHaveSSROnlyFields by reflect.Type
+var ssrOnlyTypes sync.Map
+
+// typeMayHaveSSROnlyFields is mayHaveSSROnlyFields, resolved once per type
+func typeMayHaveSSROnlyFields(t reflect.Type) bool {
+	if cached, ok := ssrOnlyTypes.Load(t); ok {
+		return cached.(bool)
+	}
+
+	mayHave := mayHaveSSROnlyFields(t, map[reflect.Type]bool{})
+	ssrOnlyTypes.Store(t, mayHave)
+
+	return mayHave
+}
+
+// mayHaveSSROnlyFields reports whether t or any type reachable through it
+// contains a struct field tagged as ssr-only. Interfaces may hold one, so only
+// their values can tell
+func mayHaveSSROnlyFields(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	if isJSONMarshaler(t) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return mayHaveSSROnlyFields(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get(propsTagName) == ssrOnlyTagValue {
+				return true
+			}
+			if mayHaveSSROnlyFields(field.Type, seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// stripSSROnlyFields converts structs with ssr-only fields, including structs
+// held by interfaces, into maps without them in a single pass over val. It
+// reports whether any were found; val is returned as-is if none were. Field
+// names and omissions follow the encoding/json rules so the output serializes
+// the same way the original value would have
+func stripSSROnlyFields(val reflect.Value, seen map[uintptr]bool) (interface{}, bool) {
+	if !val.IsValid() {
+		return nil, false
+	}
+
+	if !typeMayHaveSSROnlyFields(val.Type()) {
+		return val.Interface(), false
+	}
+
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return val.Interface(), false
+		}
+		if val.Kind() == reflect.Ptr {
+			//guard against cyclic structures
+			if seen[val.Pointer()] {
+				return val.Interface(), false
+			}
+			seen[val.Pointer()] = true
+			defer delete(seen, val.Pointer())
+		}
+		stripped, hasSSROnly := stripSSROnlyFields(val.Elem(), seen)
+		if !hasSSROnly {
+			return val.Interface(), false
+		}
+		return stripped, true
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.IsNil() {
+			return val.Interface(), false
+		}
+		items := make([]interface{}, val.Len())
+		hasSSROnly := false
+		for i := 0; i < val.Len(); i++ {
+			var itemHasSSROnly bool
+			items[i], itemHasSSROnly = stripSSROnlyFields(val.Index(i), seen)
+			hasSSROnly = hasSSROnly || itemHasSSROnly
+		}
+		if !hasSSROnly {
+			return val.Interface(), false
+		}
+		return items, true
+	case reflect.Map:
+		if val.IsNil() {
+			return val.Interface(), false
+		}
+		items := make(map[string]interface{}, val.Len())
+		hasSSROnly := false
+		iter := val.MapRange()
+		for iter.Next() {
+			key, err := jsonMapKey(iter.Key())
+			if err != nil {
+				continue
+			}
+			var itemHasSSROnly bool
+			items[key], itemHasSSROnly = stripSSROnlyFields(iter.Value(), seen)
+			hasSSROnly = hasSSROnly || itemHasSSROnly
+		}
+		if !hasSSROnly {
+			return val.Interface(), false
+		}
+		return items, true
+	case reflect.Struct:
+		return structFields(val, seen)
+	}
+
+	return val.Interface(), false
+}
+
+// structFields converts the struct val into a map of its JSON fields, leaving
+// out the ssr-only ones as if they weren't declared
+func structFields(val reflect.Value, seen map[uintptr]bool) (interface{}, bool) {
+	plan := jsonFieldsOf(val.Type())
+	fields := make(map[string]interface{}, len(plan.fields))
+	hasSSROnly := plan.hasSSROnlyFields
+
+FieldLoop:
+	for _, field := range plan.fields {
+		fieldVal := val
+		for _, i := range field.index {
+			//fields of nil embedded struct pointers are left out
+			if fieldVal.Kind() == reflect.Ptr {
+				if fieldVal.IsNil() {
+					continue FieldLoop
+				}
+				fieldVal = fieldVal.Elem()
+			}
+			fieldVal = fieldVal.Field(i)
+		}
+
+		if field.omitEmpty && isEmptyJSONValue(fieldVal) {
+			continue
+		}
+
+		if field.quoted {
+			fields[field.name] = quotedJSONValue(fieldVal)
+			continue
+		}
+
+		var fieldHasSSROnly bool
+		fields[field.name], fieldHasSSROnly = stripSSROnlyFields(fieldVal, seen)
+		hasSSROnly = hasSSROnly || fieldHasSSROnly
+	}
+
+	if !hasSSROnly {
+		return val.Interface(), false
+	}
+	return fields, true
+}
+
+// jsonFieldPlan is how encoding/json serializes a struct type, without its
+// ssr-only fields
+type jsonFieldPlan struct {
+	fields []jsonField
+	//hasSSROnlyFields is true if the struct or its embedded structs declare
+	//ssr-only fields
+	hasSSROnlyFields bool
+}
+
+// jsonFieldPlans caches jsonFields by reflect.Type
+var jsonFieldPlans sync.Map
+
+// jsonFieldsOf returns the field plan of the struct type t, resolved once per type
+func jsonFieldsOf(t reflect.Type) *jsonFieldPlan {
+	if cached, ok := jsonFieldPlans.Load(t); ok {
+		return cached.(*jsonFieldPlan)
+	}
+
+	fields, hasSSROnlyFields := jsonFields(t)
+	plan := &jsonFieldPlan{fields: fields, hasSSROnlyFields: hasSSROnlyFields}
+	jsonFieldPlans.Store(t, plan)
+
+	return plan
+}
+
+// jsonField is a struct field as encoding/json serializes it
+type jsonField struct {
+	name      string
+	index     []int
+	isTagged  bool
+	omitEmpty bool
+	quoted    bool
+}
+
+// jsonFields returns the fields of the struct type t encoding/json serializes,
+// in field order. Embedded struct fields are promoted, where shallower fields
+// win over deeper ones and tagged fields over untagged ones of the same depth.
+// Fields that are still ambiguous are dropped, as encoding/json does. It also
+// reports whether any ssr-only fields were left out
+func jsonFields(t reflect.Type) ([]jsonField, bool) {
+	type embeddedType struct {
+		t     reflect.Type
+		index []int
+	}
+
+	var fields []jsonField
+	hasSSROnlyFields := false
+	current := []embeddedType{}
+	next := []embeddedType{{t: t}}
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, embedded := range current {
+			if visited[embedded.t] {
+				continue
+			}
+			visited[embedded.t] = true
+
+			for i := 0; i < embedded.t.NumField(); i++ {
+				sf := embedded.t.Field(i)
+				if sf.Tag.Get(propsTagName) == ssrOnlyTagValue {
+					hasSSROnlyFields = true
+					continue
+				}
+
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if len(sf.PkgPath) > 0 && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if len(sf.PkgPath) > 0 {
+					continue
+				}
+
+				jsonTag := sf.Tag.Get("json")
+				if jsonTag == "-" {
+					continue
+				}
+				tagParts := strings.Split(jsonTag, ",")
+				name := tagParts[0]
+				if !isValidJSONTag(name) {
+					name = ""
+				}
+
+				index := make([]int, len(embedded.index)+1)
+				copy(index, embedded.index)
+				index[len(embedded.index)] = i
+
+				ft := sf.Type
+				if len(ft.Name()) == 0 && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if len(name) > 0 || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					field := jsonField{
+						name:     name,
+						index:    index,
+						isTagged: len(name) > 0,
+					}
+					if len(field.name) == 0 {
+						field.name = sf.Name
+					}
+					for _, opt := range tagParts[1:] {
+						switch opt {
+						case "omitempty":
+							field.omitEmpty = true
+						case "string":
+							field.quoted = isQuotableKind(ft.Kind())
+						}
+					}
+
+					fields = append(fields, field)
+					//the same type embedded twice at this depth annihilates its fields
+					if count[embedded.t] > 1 {
+						fields = append(fields, field)
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, embeddedType{t: ft, index: index})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		if fields[i].isTagged != fields[j].isTagged {
+			return fields[i].isTagged
+		}
+		return indexLess(fields[i].index, fields[j].index)
+	})
+
+	dominant := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		group := fields[i:j]
+		i = j
+
+		if len(group) > 1 && len(group[0].index) == len(group[1].index) && group[0].isTagged == group[1].isTagged {
+			continue
+		}
+		dominant = append(dominant, group[0])
+	}
+
+	sort.Slice(dominant, func(i, j int) bool {
+		return indexLess(dominant[i].index, dominant[j].index)
+	})
+
+	return dominant, hasSSROnlyFields
+}
+
+func indexLess(a, b []int) bool {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// isValidJSONTag mirrors the field name rules of encoding/json
+func isValidJSONTag(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
+
+// isQuotableKind reports whether the `json:",string"` option applies to kind
+func isQuotableKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	}
+	return false
+}
+
+// quotedJSONValue returns the JSON of val as a string, like the
+// `json:",string"` option encodes it
+func quotedJSONValue(val reflect.Value) interface{} {
+	if isJSONMarshaler(val.Type()) {
+		return val.Interface()
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	encoded, err := json.Marshal(val.Interface())
+	if err != nil {
+		return val.Interface()
+	}
+	return string(encoded)
+}
+
+// jsonMapKey returns the object key encoding/json uses for the map key k
+func jsonMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", nil
+		}
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+
+	return "", fmt.Errorf("unsupported map key type %s", k.Type())
+}
+
+// unsupportedPropPath returns the path of the first value in props that can't
+// be serialized to JSON, i.e. props.items[2].onClick, or an empty string if
+// none is found
+func unsupportedPropPath(props interface{}) string {
+	return findUnsupportedValue(reflect.ValueOf(props), "props", map[uintptr]bool{})
+}
+
+func findUnsupportedValue(val reflect.Value, path string, seen map[uintptr]bool) string {
+	if !val.IsValid() {
+		return ""
+	}
+
+	switch val.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return path
+	}
+
+	if isJSONMarshaler(val.Type()) {
+		return ""
+	}
+
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return ""
+		}
+		if val.Kind() == reflect.Ptr {
+			//guard against cyclic structures
+			if seen[val.Pointer()] {
+				return ""
+			}
+			seen[val.Pointer()] = true
+		}
+		return findUnsupportedValue(val.Elem(), path, seen)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if found := findUnsupportedValue(val.Index(i), fmt.Sprintf("%s[%d]", path, i), seen); len(found) > 0 {
+				return found
+			}
+		}
+	case reflect.Map:
+		iter := val.MapRange()
+		for iter.Next() {
+			keyPath := fmt.Sprintf("%s[%v]", path, iter.Key().Interface())
+			if found := findUnsupportedValue(iter.Value(), keyPath, seen); len(found) > 0 {
+				return found
+			}
+		}
+	case reflect.Struct:
+		t := val.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if len(field.PkgPath) > 0 && !field.Anonymous {
+				continue
+			}
+
+			jsonTag := field.Tag.Get("json")
+			if jsonTag == "-" {
+				continue
+			}
+			name := strings.Split(jsonTag, ",")[0]
+
+			fieldPath := path + "." + name
+			if len(name) == 0 {
+				fieldPath = path + "." + field.Name
+				//embedded struct fields are promoted
+				if field.Anonymous {
+					fieldPath = path
+				}
+			}
+			if found := findUnsupportedValue(val.Field(i), fieldPath, seen); len(found) > 0 {
+				return found
+			}
+		}
+	}
+
+	return ""
+}
+
+func isJSONMarshaler(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+		reflect.PtrTo(t).Implements(jsonMarshalerType) ||
+		reflect.PtrTo(t).Implements(textMarshalerType)
+}
+
+// isEmptyJSONValue mirrors the omitempty rules of encoding/json
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
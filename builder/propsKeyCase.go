@@ -0,0 +1,136 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// PropsKeyCase is the casing prop keys are transformed to when serialized
+type PropsKeyCase string
+
+const (
+	//PropsKeyCaseAsIs leaves keys as the props encoder emits them
+	PropsKeyCaseAsIs PropsKeyCase = "asis"
+	//PropsKeyCaseCamel transforms keys to camelCase, i.e. UserID to userId
+	PropsKeyCaseCamel PropsKeyCase = "camel"
+	//PropsKeyCaseSnake transforms keys to snake_case, i.e. UserID to user_id
+	PropsKeyCaseSnake PropsKeyCase = "snake"
+)
+
+// IsValid reports whether c is a known casing. The empty casing is PropsKeyCaseAsIs
+func (c PropsKeyCase) IsValid() bool {
+	switch c {
+	case "", PropsKeyCaseAsIs, PropsKeyCaseCamel, PropsKeyCaseSnake:
+		return true
+	}
+	return false
+}
+
+// transform returns key in the casing
+func (c PropsKeyCase) transform(key string) string {
+	words := splitKeyWords(key)
+	if len(words) == 0 {
+		return key
+	}
+
+	switch c {
+	case PropsKeyCaseCamel:
+		var b strings.Builder
+		b.WriteString(strings.ToLower(words[0]))
+		for _, word := range words[1:] {
+			runes := []rune(strings.ToLower(word))
+			runes[0] = unicode.ToUpper(runes[0])
+			b.WriteString(string(runes))
+		}
+		return b.String()
+	case PropsKeyCaseSnake:
+		return strings.ToLower(strings.Join(words, "_"))
+	}
+
+	return key
+}
+
+// splitKeyWords splits a key into words at underscores, hyphens, lower to upper
+// case changes and at the end of acronyms, i.e. URLPath is URL and Path
+func splitKeyWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' || r == '-' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r) {
+			continue
+		}
+
+		prev := runes[i-1]
+		endsAcronym := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || endsAcronym {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+
+	return words
+}
+
+// withPropsKeyCase wraps encoder to transform the keys of the top level props
+// object, or of every object when nested is true
+func withPropsKeyCase(
+	encoder func(props interface{}) ([]byte, error),
+	keyCase PropsKeyCase,
+	nested bool,
+) func(props interface{}) ([]byte, error) {
+	return func(props interface{}) ([]byte, error) {
+		encoded, err := encoder(props)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		//keep numbers as they were encoded
+		decoder.UseNumber()
+		var value interface{}
+		err = decoder.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(transformKeys(value, keyCase, nested))
+	}
+}
+
+func transformKeys(value interface{}, keyCase PropsKeyCase, nested bool) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		transformed := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			if nested {
+				item = transformKeys(item, keyCase, nested)
+			}
+			transformed[keyCase.transform(key)] = item
+		}
+		return transformed
+	case []interface{}:
+		if !nested {
+			return typed
+		}
+		for i, item := range typed {
+			typed[i] = transformKeys(item, keyCase, nested)
+		}
+		return typed
+	}
+
+	return value
+}
@@ -0,0 +1,92 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropsKeyCase_Transform(t *testing.T) {
+	cases := map[string][2]string{
+		"Name":       {"name", "name"},
+		"UserID":     {"userId", "user_id"},
+		"URLPath":    {"urlPath", "url_path"},
+		"HTTPServer": {"httpServer", "http_server"},
+		"Address2":   {"address2", "address2"},
+		"firstName":  {"firstName", "first_name"},
+		"last_name":  {"lastName", "last_name"},
+		"ID":         {"id", "id"},
+	}
+
+	for key, expected := range cases {
+		assert.Equal(t, expected[0], PropsKeyCaseCamel.transform(key), key)
+		assert.Equal(t, expected[1], PropsKeyCaseSnake.transform(key), key)
+		assert.Equal(t, key, PropsKeyCaseAsIs.transform(key), key)
+	}
+}
+
+func TestPropsKeyCase_IsValid(t *testing.T) {
+	assert.True(t, PropsKeyCase("").IsValid())
+	assert.True(t, PropsKeyCaseCamel.IsValid())
+	assert.False(t, PropsKeyCase("kebab").IsValid())
+}
+
+type testOwner struct {
+	FirstName string
+}
+
+type testProduct struct {
+	ProductID int64
+	Owner     testOwner
+	Tags      []testOwner
+}
+
+func TestWithPropsKeyCase(t *testing.T) {
+	props := testProduct{
+		ProductID: 9007199254740993,
+		Owner:     testOwner{FirstName: "Ada"},
+		Tags:      []testOwner{{FirstName: "Grace"}},
+	}
+
+	topLevel, err := withPropsKeyCase(json.Marshal, PropsKeyCaseCamel, false)(props)
+	assert.NoError(t, err)
+	//numbers aren't rounded through float64
+	assert.JSONEq(t, `{
+		"productId": 9007199254740993,
+		"owner": {"FirstName": "Ada"},
+		"tags": [{"FirstName": "Grace"}]
+	}`, string(topLevel))
+
+	nested, err := withPropsKeyCase(json.Marshal, PropsKeyCaseSnake, true)(props)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"product_id": 9007199254740993,
+		"owner": {"first_name": "Ada"},
+		"tags": [{"first_name": "Grace"}]
+	}`, string(nested))
+}
+
+func TestViewManager_Render_PropsKeyCase(t *testing.T) {
+	var renderedProps interface{}
+	vm := &fakeVM{
+		programFn: func(_, _ string, globals map[string]interface{}) (string, error) {
+			renderedProps = globals[renderPropsGlobal]
+			return `{"body":"<h1>Hello</h1>"}`, nil
+		},
+	}
+	v, _ := newTestViewManager(vm)
+	v.propsEncoder = withPropsKeyCase(json.Marshal, PropsKeyCaseCamel, false)
+
+	props := struct {
+		FirstName string
+		UserID    int
+	}{"Ada", 1}
+	out, err := v.Render(context.Background(), "Index.svelte", props)
+	assert.NoError(t, err)
+
+	//both the SSR and the hydration props are transformed
+	assert.Equal(t, `{"firstName":"Ada","userId":1}`, renderedProps)
+	assert.Contains(t, out, propsScriptOpenTag+`{"firstName":"Ada","userId":1}`+propsScriptCloseTag)
+}
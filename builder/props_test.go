@@ -0,0 +1,246 @@
+package builder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientProps(t *testing.T) {
+	type Embedded struct {
+		Shared string
+		Secret string `aviator:"ssr-only"`
+	}
+	type child struct {
+		Name     string `json:"name"`
+		Internal int    `json:"internal" aviator:"ssr-only"`
+	}
+	type props struct {
+		Embedded
+		Title    string    `json:"title"`
+		Empty    string    `json:"empty,omitempty"`
+		Skipped  string    `json:"-"`
+		Created  time.Time `json:"created"`
+		Children []child   `json:"children"`
+		ByKey    map[string]*child
+	}
+
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	input := &props{
+		Embedded: Embedded{Shared: "shared", Secret: "secret"},
+		Title:    "title",
+		Skipped:  "skipped",
+		Created:  created,
+		Children: []child{{Name: "a", Internal: 1}},
+		ByKey:    map[string]*child{"b": {Name: "b", Internal: 2}},
+	}
+
+	output, hasSSROnly := clientProps(input)
+	assert.True(t, hasSSROnly)
+
+	outputJSON, err := json.Marshal(output)
+	assert.NoError(t, err)
+
+	createdJSON, _ := json.Marshal(created)
+	expected := `{"ByKey":{"b":{"name":"b"}},"Shared":"shared","children":[{"name":"a"}],` +
+		`"created":` + string(createdJSON) + `,"title":"title"}`
+	assert.JSONEq(t, expected, string(outputJSON))
+
+	plain := map[string]string{"foo": "bar"}
+	output, hasSSROnly = clientProps(plain)
+	assert.False(t, hasSSROnly)
+	assert.Equal(t, plain, output)
+}
+
+func TestClientProps_Interfaces(t *testing.T) {
+	type user struct {
+		Name     string `json:"name"`
+		Password string `json:"password" aviator:"ssr-only"`
+	}
+
+	//structs held by interfaces are only known from the values
+	input := map[string]interface{}{
+		"user":  user{Name: "ada", Password: "secret"},
+		"users": []interface{}{&user{Name: "grace", Password: "secret"}, "guest"},
+		"title": "Users",
+	}
+	output, hasSSROnly := clientProps(input)
+	assert.True(t, hasSSROnly)
+
+	outputJSON, err := json.Marshal(output)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user":{"name":"ada"},"users":[{"name":"grace"},"guest"],"title":"Users"}`, string(outputJSON))
+
+	//a struct referenced twice is stripped both times
+	shared := &user{Name: "ada", Password: "secret"}
+	output, hasSSROnly = clientProps(map[string]interface{}{"author": shared, "editor": shared})
+	assert.True(t, hasSSROnly)
+
+	outputJSON, err = json.Marshal(output)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"author":{"name":"ada"},"editor":{"name":"ada"}}`, string(outputJSON))
+
+	plain := map[string]interface{}{"title": "Users", "count": 2}
+	output, hasSSROnly = clientProps(plain)
+	assert.False(t, hasSSROnly)
+	assert.Equal(t, plain, output)
+}
+
+func TestClientProps_MatchesEncodingJSON(t *testing.T) {
+	type Base struct {
+		Name   string
+		Secret string `aviator:"ssr-only"`
+	}
+	type PlainBase struct {
+		Name string
+	}
+	type Tagged struct {
+		Title string `json:"Name"`
+	}
+	type Other struct {
+		Name string
+	}
+	type base struct {
+		Shared string
+		Secret string `aviator:"ssr-only"`
+	}
+	type plainBase struct {
+		Shared string
+	}
+	type item struct {
+		Name   string `json:"name"`
+		Secret string `aviator:"ssr-only"`
+	}
+	type plainItem struct {
+		Name string `json:"name"`
+	}
+
+	count := 3
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected interface{}
+	}{
+		{
+			name: "shallower field wins over embedded",
+			input: struct {
+				Name string
+				Base
+			}{Name: "outer", Base: Base{Name: "inner", Secret: "secret"}},
+			expected: struct {
+				Name string
+				PlainBase
+			}{Name: "outer", PlainBase: PlainBase{Name: "inner"}},
+		},
+		{
+			name: "tagged field wins at the same depth",
+			input: struct {
+				Other
+				Tagged
+				Secret string `aviator:"ssr-only"`
+			}{Other: Other{Name: "other"}, Tagged: Tagged{Title: "tagged"}, Secret: "secret"},
+			expected: struct {
+				Other
+				Tagged
+			}{Other: Other{Name: "other"}, Tagged: Tagged{Title: "tagged"}},
+		},
+		{
+			name: "ambiguous fields are dropped",
+			input: struct {
+				Base
+				Other
+			}{Base: Base{Name: "base", Secret: "secret"}, Other: Other{Name: "other"}},
+			expected: struct {
+				PlainBase
+				Other
+			}{PlainBase: PlainBase{Name: "base"}, Other: Other{Name: "other"}},
+		},
+		{
+			name: "unexported embedded struct",
+			input: struct {
+				base
+				Title string
+			}{base: base{Shared: "shared", Secret: "secret"}, Title: "title"},
+			expected: struct {
+				plainBase
+				Title string
+			}{plainBase: plainBase{Shared: "shared"}, Title: "title"},
+		},
+		{
+			name: "string option",
+			input: struct {
+				ID      int64   `json:",string"`
+				Enabled bool    `json:"enabled,string"`
+				Label   string  `json:"label,string"`
+				Count   *int    `json:"count,string"`
+				Missing *int    `json:"missing,string"`
+				Ratio   float64 `json:"ratio,string"`
+				Secret  string  `aviator:"ssr-only"`
+			}{ID: 1 << 60, Enabled: true, Label: `<"a">`, Count: &count, Ratio: 0.5, Secret: "secret"},
+			expected: struct {
+				ID      int64   `json:",string"`
+				Enabled bool    `json:"enabled,string"`
+				Label   string  `json:"label,string"`
+				Count   *int    `json:"count,string"`
+				Missing *int    `json:"missing,string"`
+				Ratio   float64 `json:"ratio,string"`
+			}{ID: 1 << 60, Enabled: true, Label: `<"a">`, Count: &count, Ratio: 0.5},
+		},
+		{
+			name: "map keys",
+			input: map[string]item{
+				`say "hi"`: {Name: "quote", Secret: "secret"},
+				"<b>":      {Name: "html", Secret: "secret"},
+				`a\b`:      {Name: "backslash", Secret: "secret"},
+			},
+			expected: map[string]plainItem{
+				`say "hi"`: {Name: "quote"},
+				"<b>":      {Name: "html"},
+				`a\b`:      {Name: "backslash"},
+			},
+		},
+		{
+			name:     "int map keys",
+			input:    map[int]item{-1: {Name: "negative", Secret: "secret"}, 2: {Name: "positive"}},
+			expected: map[int]plainItem{-1: {Name: "negative"}, 2: {Name: "positive"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, hasSSROnly := clientProps(test.input)
+			assert.True(t, hasSSROnly)
+
+			outputJSON, err := json.Marshal(output)
+			assert.NoError(t, err)
+			expectedJSON, err := json.Marshal(test.expected)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(expectedJSON), string(outputJSON))
+		})
+	}
+}
+
+func TestUnsupportedPropPath(t *testing.T) {
+	type item struct {
+		Name    string `json:"name"`
+		OnClick func() `json:"onClick"`
+	}
+	type base struct {
+		Updates chan int
+	}
+	type pageProps struct {
+		*base
+		Title string `json:"title"`
+		Items []item `json:"items"`
+	}
+
+	assert.Equal(t, "", unsupportedPropPath(pageProps{Title: "Cars"}))
+	//encoding/json rejects channels and funcs even when they are nil
+	assert.Equal(t, "props.Updates", unsupportedPropPath(pageProps{base: &base{}}))
+	assert.Equal(t, "props.items[0].onClick", unsupportedPropPath(&pageProps{
+		Items: []item{{Name: "a"}, {Name: "b"}},
+	}))
+	assert.Equal(t, "props[callback]", unsupportedPropPath(map[string]interface{}{"callback": func() {}}))
+}
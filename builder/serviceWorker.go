@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/template"
+)
+
+const serviceWorkerName = "sw.js"
+
+//go:embed serviceWorkerTemplate.gotext
+var serviceWorkerTemplate string
+
+var serviceWorkerGenerator = template.Must(template.New("serviceWorkerTemplate").Parse(serviceWorkerTemplate))
+
+// createServiceWorker generates a service worker that precaches all assets in
+// staticContent. Its cache version is derived from the asset contents, so every
+// build that changes an asset makes clients install the new worker
+func (v *ViewManager) createServiceWorker(staticContent map[string]StaticAsset) (StaticAsset, error) {
+	var assetNames []string
+	for name := range staticContent {
+		if name == serviceWorkerName {
+			continue
+		}
+		assetNames = append(assetNames, name)
+	}
+	sort.Strings(assetNames)
+
+	var versionContent []byte
+	urls := make([]string, 0, len(assetNames))
+	for _, name := range assetNames {
+		versionContent = append(versionContent, name...)
+		versionContent = append(versionContent, staticContent[name].Content...)
+		urls = append(urls, v.precacheURL(name, staticContent[name]))
+	}
+
+	urlsJSON, err := json.Marshal(urls)
+	if err != nil {
+		return StaticAsset{}, err
+	}
+
+	buf := bytes.Buffer{}
+	err = serviceWorkerGenerator.Execute(&buf, map[string]interface{}{
+		"Version": v.hash(versionContent),
+		"URLs":    string(urlsJSON),
+	})
+	if err != nil {
+		return StaticAsset{}, err
+	}
+
+	return StaticAsset{
+		Content:  buf.Bytes(),
+		MimeType: "text/javascript",
+	}, nil
+}
+
+// precacheURL returns the URL pages load the asset from. Built JS and CSS are
+// served under their routes, the user's static assets under the static asset
+// route
+func (v *ViewManager) precacheURL(name string, asset StaticAsset) string {
+	if _, ok := v.userStaticContent[name]; !ok {
+		switch asset.MimeType {
+		case "text/javascript":
+			return assetURL(v.jsRoute, name)
+		case "text/css":
+			return assetURL(v.cssRoute, name)
+		}
+	}
+	return assetURL(v.staticAssetsRoute, name)
+}
+
+func (v *ViewManager) createServiceWorkerRegistration() string {
+	format := "<script>if (\"serviceWorker\" in navigator) { navigator.serviceWorker.register(%q, { scope: %q }) }</script>\n"
+	return fmt.Sprintf(format, assetURL(v.jsRoute, serviceWorkerName), v.serviceWorkerScope)
+}
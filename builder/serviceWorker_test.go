@@ -0,0 +1,73 @@
+package builder
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewManager_CreateServiceWorker(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	v.serviceWorkerScope = "/"
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte("console.log(1)")}
+	v.staticContent["Index.svelte.css"] = StaticAsset{Content: []byte("h1{}")}
+
+	serviceWorker, err := v.createServiceWorker(v.staticContent)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/javascript", serviceWorker.MimeType)
+
+	content := string(serviceWorker.Content)
+	assert.Contains(t, content, `const PRECACHE_URLS = ["/static/Index.svelte.css","/static/Index.svelte.js"]`)
+
+	//rebuilding with changed assets must change the cache version
+	v.staticContent[serviceWorkerName] = serviceWorker
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte("console.log(2)")}
+	rebuiltServiceWorker, err := v.createServiceWorker(v.staticContent)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(rebuiltServiceWorker.Content), serviceWorkerName)
+	assert.NotEqual(t, content, string(rebuiltServiceWorker.Content))
+
+	v.cacheStaticHeadTags()
+	assert.Contains(t, view.staticHeadTags, `navigator.serviceWorker.register("/static/sw.js", { scope: "/" })`)
+}
+
+func TestViewManager_CreateServiceWorker_Routes(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	v.serviceWorkerScope = "/"
+	v.jsRoute = "/js"
+	v.cssRoute = "https://cdn.example.com/css"
+	v.userStaticContent = map[string]StaticAsset{"robots.txt": {MimeType: "text/plain"}}
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte("console.log(1)"), MimeType: "text/javascript"}
+	v.staticContent["Index.svelte.css"] = StaticAsset{Content: []byte("h1{}"), MimeType: "text/css"}
+	v.staticContent["robots.txt"] = v.userStaticContent["robots.txt"]
+
+	serviceWorker, err := v.createServiceWorker(v.staticContent)
+	assert.NoError(t, err)
+	assert.Contains(t, string(serviceWorker.Content), `const PRECACHE_URLS = [`+
+		`"https://cdn.example.com/css/Index.svelte.css","/js/Index.svelte.js","/static/robots.txt"]`)
+
+	//the precached URLs are the ones pages load
+	v.staticContent[serviceWorkerName] = serviceWorker
+	v.cacheStaticHeadTags()
+	assert.Contains(t, view.staticHeadTags, `src="/js/Index.svelte.js"`)
+	assert.Contains(t, view.staticHeadTags, `href="https://cdn.example.com/css/Index.svelte.css"`)
+	assert.Contains(t, view.staticHeadTags, `navigator.serviceWorker.register("/js/sw.js", { scope: "/" })`)
+}
+
+func TestViewManager_CacheKeyHash(t *testing.T) {
+	v, view := newTestViewManager(newStaticRenderVM(`{}`))
+	v.useImportMap = true
+	v.staticContent["Index.svelte.js"] = StaticAsset{Content: []byte("console.log(1)")}
+
+	v.hash = func(content []byte) string {
+		return fmt.Sprintf("len%d", len(content))
+	}
+
+	serviceWorker, err := v.createServiceWorker(v.staticContent)
+	assert.NoError(t, err)
+	assert.Contains(t, string(serviceWorker.Content), `const CACHE_NAME = "aviator-len29"`)
+
+	v.cacheStaticHeadTags()
+	assert.Contains(t, view.staticHeadTags, `"/static/Index.svelte.js?v=len14"`)
+}
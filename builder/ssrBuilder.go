@@ -2,9 +2,14 @@ package builder
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"strings"
 	"sync"
 	"text/template"
@@ -18,13 +23,111 @@ type SSRBuilder struct {
 	vm         js.VM
 	logger     utils.Logger
 	workingDir string
-	cache      Cache
+	//files reads the views from workingDir
+	files   viewsFS
+	cache   Cache
+	options BuildOptions
+
+	//warnings collects the compiler warnings of the current build
+	warnings     []SvelteWarning
+	warningsLock sync.Mutex
+}
+
+// BuildOptions holds the options shared by the SSR and browser builders
+type BuildOptions struct {
+	//PropsReviver is a JS function expression passed to JSON.parse as the reviver
+	//when props are read before rendering and hydrating
+	PropsReviver string
+
+	//StoreInitializer is the source of a JS module whose default export is called
+	//with the render context before SSR and before hydration in the browser
+	StoreInitializer string
+
+	//ExternalResolver is consulted for bare imports. Imports it marks as external
+	//are left as runtime imports of the returned URL in the browser build
+	ExternalResolver ExternalResolver
+
+	//Hydratable decides which components are compiled with hydration support in
+	//the browser build. The last matching rule wins. Components are hydratable
+	//when no rule matches
+	Hydratable []HydratableRule
+
+	//BuildInfo values are defined as __BUILD_{KEY}__ constants in both builds
+	BuildInfo map[string]string
+
+	//Immutable compiles components with svelte's immutable option, which
+	//compares values by reference when checking for changes
+	Immutable bool
+
+	//FailOnA11yWarnings fails the build when the svelte compiler emits any
+	//accessibility warnings
+	FailOnA11yWarnings bool
+
+	//AssetLayout sets the directory structure of the browser build's assets
+	AssetLayout AssetLayout
+
+	//StylePreprocessor transforms the <style> blocks of components before
+	//they're compiled
+	StylePreprocessor StylePreprocessor
+
+	//ImportAliases maps import prefixes, i.e. "$lib", to the paths they're
+	//resolved to. Relative paths are relative to the views directory
+	ImportAliases map[string]string
+}
+
+// cacheKey identifies the options that change how components are compiled, so
+// builds with different options don't share caches
+func (o BuildOptions) cacheKey() string {
+	var keys []string
+	if o.Immutable {
+		keys = append(keys, "immutable")
+	}
+	//components cached without checking for warnings must be compiled again
+	if o.FailOnA11yWarnings {
+		keys = append(keys, "a11y")
+	}
+	//components cached without preprocessing their styles must be compiled again
+	if o.StylePreprocessor != nil {
+		keys = append(keys, "styles")
+	}
+	return strings.Join(keys, "-")
+}
+
+// cacheFingerprint identifies the compiler and every option components are
+// compiled with. Caches created with a different fingerprint are discarded
+func (o BuildOptions) cacheFingerprint(compilerHash string) string {
+	h := sha256.New()
+	io.WriteString(h, compilerHash)
+	io.WriteString(h, ssrCompileFormat)
+	io.WriteString(h, browserCompileFormat)
+	fmt.Fprintf(h, "immutable=%t;a11y=%t;styles=%t;", o.Immutable, o.FailOnA11yWarnings, o.StylePreprocessor != nil)
+	for _, rule := range o.Hydratable {
+		fmt.Fprintf(h, "hydratable:%s=%t;", rule.Glob, rule.Hydratable)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HydratableRule sets whether the components whose path relative to the views
+// directory matches Glob are compiled with hydration support
+type HydratableRule struct {
+	Glob       string
+	Hydratable bool
 }
 
+// ExternalResolver returns the URL a bare import should be loaded from at runtime
+// and whether it should be left out of the bundle. importPath is returned as is
+// when url is empty
+type ExternalResolver func(importPath string) (url string, external bool)
+
 type CompiledResult struct {
 	JS        []byte
 	CSS       []byte
 	SourceMap []byte
+
+	//Warnings are the svelte compiler warnings of the components compiled in
+	//this build. Cached components aren't compiled again
+	Warnings []SvelteWarning
 }
 
 func NewSSRBuilder(
@@ -32,12 +135,16 @@ func NewSSRBuilder(
 	vm js.VM,
 	cache Cache,
 	workingDir string,
+	fsys fs.FS,
+	options BuildOptions,
 ) *SSRBuilder {
 	return &SSRBuilder{
 		logger:     logger,
 		vm:         vm,
 		workingDir: workingDir,
+		files:      viewsFS{root: workingDir, fsys: fsys},
 		cache:      cache,
+		options:    options,
 	}
 }
 
@@ -50,6 +157,9 @@ func (s *SSRBuilder) DevBuild(allViews []*View) (*CompiledResult, error) {
 	}
 
 	cssCache := &sync.Map{}
+	s.warningsLock.Lock()
+	s.warnings = nil
+	s.warningsLock.Unlock()
 
 	result := esbuild.Build(esbuild.BuildOptions{
 		//__aviator_ssr.js is a file created by ssrPlugin at build-time
@@ -68,27 +178,29 @@ func (s *SSRBuilder) DevBuild(allViews []*View) (*CompiledResult, error) {
 		LogLevel:      esbuild.LogLevelInfo,
 		Sourcemap:     esbuild.SourceMapInline,
 		Target:        esbuild.ES2015,
+		Define:        buildInfoDefines(s.options.BuildInfo),
 		Plugins: []esbuild.Plugin{
 			s.ssrPlugin(allEntryPointViews),
+			//the .svelte and js resolvers below resolve the rewritten imports
+			importAliasPlugin(s.workingDir, s.options.ImportAliases),
 			wrappedComponentsPlugin(s.cache, s.workingDir, allViews, s.ssrCompile),
-			svelteComponentsPlugin(s.cache, s.workingDir, cssCache, s.ssrCompile),
-			npmJsPathPlugin(s.workingDir),
+			svelteComponentsPlugin(s.cache, s.workingDir, s.files, cssCache, s.options.StylePreprocessor, s.ssrCompile),
+			//must be registered before js_path, which resolves every .js import
+			storeInitializerPlugin(s.workingDir, s.options.StoreInitializer),
+			externalResolverPlugin(s.options.ExternalResolver, true),
+			npmJsPathPlugin(s.workingDir, s.files),
 		},
 	})
 
 	if len(result.Errors) > 0 {
-		msgs := esbuild.FormatMessages(result.Errors, esbuild.FormatMessagesOptions{
-			Color:         true,
-			Kind:          esbuild.ErrorMessage,
-			TerminalWidth: 80,
-		})
-		return nil, fmt.Errorf(strings.Join(msgs, "\n"))
+		return nil, newBuildError(result.Errors)
 	}
 	s.cache.Finished()
 
 	compiledResult := &CompiledResult{
 		//SourceMap: result.OutputFiles[0].Contents,
-		JS: result.OutputFiles[0].Contents,
+		JS:       result.OutputFiles[0].Contents,
+		Warnings: s.warnings,
 	}
 	//css is generated in the browser builder
 	/*
@@ -125,7 +237,8 @@ func (s *SSRBuilder) ssrPlugin(allEntryPointViews []*View) esbuild.Plugin {
 				func(args esbuild.OnLoadArgs) (result esbuild.OnLoadResult, err error) {
 					//this data is used to compile the .gotext template to get JS
 					viewData := map[string]interface{}{
-						"Views": allEntryPointViews,
+						"Views":               allEntryPointViews,
+						"HasStoreInitializer": len(s.options.StoreInitializer) > 0,
 					}
 
 					buf := bytes.Buffer{}
@@ -150,20 +263,63 @@ type SvelteBuildOutput struct {
 
 	JSSourceMap  string
 	CSSSourceMap string
+
+	//Warnings are only returned when requested from the compiler
+	Warnings []SvelteWarning
+}
+
+// SvelteWarning is a warning emitted by the svelte compiler
+type SvelteWarning struct {
+	//Filename is the path of the component relative to the views directory
+	Filename string
+	Code     string
+	Message  string
+	Line     int
+	Column   int
+}
+
+func (w SvelteWarning) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", w.Filename, w.Line, w.Column, w.Message, w.Code)
 }
 
+// a11yWarningsError returns an error listing the accessibility warnings, or nil
+// if there are none
+func a11yWarningsError(warnings []SvelteWarning) error {
+	var a11yWarnings []string
+	for _, warning := range warnings {
+		if !strings.HasPrefix(warning.Code, "a11y-") {
+			continue
+		}
+		a11yWarnings = append(a11yWarnings, warning.String())
+	}
+	if len(a11yWarnings) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("accessibility warnings:\n%s", strings.Join(a11yWarnings, "\n"))
+}
+
+// ssrCompileFormat is the compiler call of the SSR build
+const ssrCompileFormat = `__svelte__.compile({ "Path": %q, "code": %q, "target": "ssr", "dev": %t, "css": false, "enableSourcemap": %t, "isHydratable": %t, "immutable": %t, "warnings": true, "sourcemap": %s })`
+
 // ssrCompile compiles a compiled
-func (s *SSRBuilder) ssrCompile(path string, code []byte) (*SvelteBuildOutput, error) {
-	format := `__svelte__.compile({ "Path": %q, "code": %q, "target": "ssr", "dev": %t, "css": false, "enableSourcemap": %t, "isHydratable": %t })`
+func (s *SSRBuilder) ssrCompile(path string, relPath string, code []byte) (*SvelteBuildOutput, error) {
+	code, sourceMap, err := preprocessTypeScript(relPath, code)
+	if err != nil {
+		return nil, err
+	}
+
 	expr := fmt.Sprintf(
-		format,
+		ssrCompileFormat,
 		path,
 		code,
 		false,
 		false,
 		false,
+		s.options.Immutable,
+		compilerSourceMap(sourceMap),
 	)
-	result, err := s.vm.Eval(path, expr)
+	result, err := s.vm.Eval(context.Background(), path, expr)
 	if err != nil {
 		return nil, err
 	}
@@ -172,5 +328,19 @@ func (s *SSRBuilder) ssrCompile(path string, code []byte) (*SvelteBuildOutput, e
 		return nil, err
 	}
 
+	for i := range outputStruct.Warnings {
+		outputStruct.Warnings[i].Filename = relPath
+	}
+	s.warningsLock.Lock()
+	s.warnings = append(s.warnings, outputStruct.Warnings...)
+	s.warningsLock.Unlock()
+
+	if s.options.FailOnA11yWarnings {
+		err = a11yWarningsError(outputStruct.Warnings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return outputStruct, nil
 }
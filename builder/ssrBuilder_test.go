@@ -0,0 +1,166 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSRGenerator_StoreInitializer(t *testing.T) {
+	views := []*View{{WrappedUniqueName: "__AviatorWrapped_Index"}}
+
+	buf := bytes.Buffer{}
+	err := ssrGenerator.Execute(&buf, map[string]interface{}{
+		"Views":               views,
+		"HasStoreInitializer": true,
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `import initStores from "__aviator_store_initializer.js"`)
+	assert.Contains(t, buf.String(), `initStores(context || {})`)
+
+	buf.Reset()
+	err = ssrGenerator.Execute(&buf, map[string]interface{}{
+		"Views": views,
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "initStores")
+}
+
+func TestSSRBuilder_Immutable(t *testing.T) {
+	var compileExpr string
+	vm := &fakeVM{
+		evalFn: func(_, expression string) (string, error) {
+			compileExpr = expression
+			return `{}`, nil
+		},
+	}
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+
+	s := NewSSRBuilder(nopLogger{}, vm, cache, "/views", nil, BuildOptions{Immutable: true})
+	_, err = s.ssrCompile("Index.svelte", "Index.svelte", []byte("<h1>Index</h1>"))
+	assert.NoError(t, err)
+	assert.Contains(t, compileExpr, `"immutable": true`)
+
+	b := NewBrowserBuilder(nopLogger{}, vm, cache, "/views", nil, BuildOptions{Immutable: true})
+	_, err = b.browserCompile("Index.svelte", "Index.svelte", []byte("<h1>Index</h1>"))
+	assert.NoError(t, err)
+	assert.Contains(t, compileExpr, `"immutable": true`)
+
+	//compiled output differs, so the caches must too
+	assert.NotEqual(t, BuildOptions{}.cacheKey(), BuildOptions{Immutable: true}.cacheKey())
+}
+
+func TestSvelteCompiler_Immutable(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+
+	vm := newGojaTestVM(t, string(compilerCode))
+	compile := func(immutable bool) string {
+		out, err := vm.Eval(context.Background(), "", fmt.Sprintf(
+			`__svelte__.compile({ "Path": "Counter.svelte", "code": %q, "target": "dom", "dev": false, "css": false, "enableSourcemap": false, "isHydratable": true, "immutable": %t })`,
+			`<script>export let count = 0</script><p>{count}</p>`,
+			immutable,
+		))
+		assert.NoError(t, err)
+		return out
+	}
+
+	assert.Contains(t, compile(false), "safe_not_equal")
+	assert.NotContains(t, compile(true), "safe_not_equal")
+}
+
+func TestSSRBuilder_FailOnA11yWarnings(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	fixture, err := os.ReadFile("test_data/a11y/MissingAlt.svelte")
+	assert.NoError(t, err)
+
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+
+	s := NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	_, err = s.ssrCompile("MissingAlt.svelte", "a11y/MissingAlt.svelte", fixture)
+	assert.NoError(t, err)
+
+	s = NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{FailOnA11yWarnings: true})
+	_, err = s.ssrCompile("MissingAlt.svelte", "a11y/MissingAlt.svelte", fixture)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "a11y/MissingAlt.svelte:5:0")
+	assert.Contains(t, err.Error(), "a11y-missing-attribute")
+
+	b := NewBrowserBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{FailOnA11yWarnings: true})
+	_, err = b.browserCompile("MissingAlt.svelte", "a11y/MissingAlt.svelte", fixture)
+	assert.Error(t, err)
+
+	_, err = b.browserCompile("Alt.svelte", "a11y/Alt.svelte", []byte(`<img src="/cat.png" alt="A cat">`))
+	assert.NoError(t, err)
+
+	//the whole build fails on the component's warnings
+	workingDir, err := filepath.Abs("test_data")
+	assert.NoError(t, err)
+	result := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   `import MissingAlt from "./a11y/MissingAlt.svelte"`,
+			ResolveDir: workingDir,
+			Loader:     esbuild.LoaderJS,
+		},
+		Bundle:   true,
+		LogLevel: esbuild.LogLevelSilent,
+		Plugins: []esbuild.Plugin{
+			svelteComponentsPlugin(cache, workingDir, viewsFS{}, &sync.Map{}, nil, s.ssrCompile),
+		},
+	})
+	assert.NotEmpty(t, result.Errors)
+	assert.Contains(t, newBuildError(result.Errors).Error(), "a11y-missing-attribute")
+
+	//components cached without the check must be compiled again
+	assert.NotEqual(t, BuildOptions{}.cacheKey(), BuildOptions{FailOnA11yWarnings: true}.cacheKey())
+}
+
+func TestSSRBuilder_Warnings(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	fixture, err := os.ReadFile("test_data/warnings/UnusedProp.svelte")
+	assert.NoError(t, err)
+
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+
+	s := NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	out, err := s.ssrCompile("UnusedProp.svelte", "warnings/UnusedProp.svelte", fixture)
+	assert.NoError(t, err)
+
+	assert.Len(t, out.Warnings, 1)
+	warning := out.Warnings[0]
+	assert.Equal(t, "warnings/UnusedProp.svelte", warning.Filename)
+	assert.Equal(t, "unused-export-let", warning.Code)
+	assert.Equal(t, 3, warning.Line)
+	assert.Contains(t, warning.String(), "warnings/UnusedProp.svelte:3:")
+
+	//collected for the build result
+	assert.Equal(t, out.Warnings, s.warnings)
+
+	//not an accessibility warning
+	s = NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{FailOnA11yWarnings: true})
+	_, err = s.ssrCompile("UnusedProp.svelte", "warnings/UnusedProp.svelte", fixture)
+	assert.NoError(t, err)
+}
+
+func TestBuildOptions_CacheFingerprint(t *testing.T) {
+	options := BuildOptions{}
+	assert.Equal(t, options.cacheFingerprint("compilerA"), options.cacheFingerprint("compilerA"))
+	assert.NotEqual(t, options.cacheFingerprint("compilerA"), options.cacheFingerprint("compilerB"))
+
+	hydratable := BuildOptions{Hydratable: []HydratableRule{{Glob: "static/*", Hydratable: false}}}
+	assert.NotEqual(t, options.cacheFingerprint("compilerA"), hydratable.cacheFingerprint("compilerA"))
+}
@@ -0,0 +1,40 @@
+package builder
+
+import (
+	"io/fs"
+	"mime"
+	"path"
+)
+
+// loadStaticAssets reads every file in fsys as a static asset named by its path
+// in fsys. The mime type is derived from the file extension
+func loadStaticAssets(fsys fs.FS) (map[string]StaticAsset, error) {
+	assets := map[string]StaticAsset{}
+	if fsys == nil {
+		return assets, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		mimeType := mime.TypeByExtension(path.Ext(name))
+		if len(mimeType) == 0 {
+			mimeType = "application/octet-stream"
+		}
+
+		assets[name] = StaticAsset{
+			Content:  content,
+			MimeType: mimeType,
+		}
+		return nil
+	})
+
+	return assets, err
+}
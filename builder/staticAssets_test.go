@@ -0,0 +1,21 @@
+package builder
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStaticAssets(t *testing.T) {
+	assets, err := loadStaticAssets(fstest.MapFS{
+		"Index.svelte.fr.js":  {Data: []byte(`"bonjour"`)},
+		"fonts/Inter.unknown": {Data: []byte(`font`)},
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, assets, 2)
+	assert.Equal(t, []byte(`"bonjour"`), assets["Index.svelte.fr.js"].Content)
+	assert.Contains(t, assets["Index.svelte.fr.js"].MimeType, "javascript")
+	assert.Equal(t, "application/octet-stream", assets["fonts/Inter.unknown"].MimeType)
+}
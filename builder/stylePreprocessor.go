@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+// StylePreprocessor transforms the contents of a component's <style> block into
+// CSS before the component is compiled. path is the path of the component
+// relative to the views directory and lang is the block's lang attribute, empty
+// for plain CSS. Blocks are passed as is when it returns them unchanged
+type StylePreprocessor func(path string, lang string, style string) (string, error)
+
+var styleTagRegexp = regexp.MustCompile(`(?s)<style([^>]*)>(.*?)</style>`)
+var styleLangRegexp = regexp.MustCompile(`\blang\s*=\s*["']([^"']*)["']`)
+
+// isCSSLang reports whether a <style> block with lang holds plain CSS
+func isCSSLang(lang string) bool {
+	return len(lang) == 0 || strings.EqualFold(lang, "css")
+}
+
+// preprocessStyles replaces the contents of the <style> blocks of a component
+// with the output of preprocessor. Blocks in a language other than CSS fail
+// without a preprocessor, the compiler would pass them to the browser verbatim
+func preprocessStyles(relPath string, code []byte, preprocessor StylePreprocessor) ([]byte, error) {
+	source := string(code)
+	styles := styleTagRegexp.FindAllStringSubmatchIndex(source, -1)
+	if len(styles) == 0 {
+		return code, nil
+	}
+
+	var output strings.Builder
+	//copied is the end of the source already written to output
+	copied := 0
+	for _, style := range styles {
+		var lang string
+		if match := styleLangRegexp.FindStringSubmatch(source[style[2]:style[3]]); match != nil {
+			lang = match[1]
+		}
+		if preprocessor == nil {
+			if !isCSSLang(lang) {
+				return nil, fmt.Errorf(
+					"%s: <style lang=%q> needs a style preprocessor, see WithStylePreprocessor",
+					relPath,
+					lang,
+				)
+			}
+			continue
+		}
+
+		contentStart, contentEnd := style[4], style[5]
+		css, err := preprocessor(relPath, lang, source[contentStart:contentEnd])
+		if err != nil {
+			return nil, fmt.Errorf("failed to preprocess the styles of %s: %w", relPath, err)
+		}
+
+		output.WriteString(source[copied:contentStart])
+		output.WriteString(css)
+		copied = contentEnd
+	}
+	if copied == 0 {
+		return code, nil
+	}
+	output.WriteString(source[copied:])
+
+	return []byte(output.String()), nil
+}
+
+// ESBuildStylePreprocessor returns the built-in preprocessor, which runs plain
+// CSS blocks through esbuild to lower newer syntax for the browsers, i.e.
+// "chrome58" or "safari11". Other languages are returned unchanged, so it can
+// follow a Sass or PostCSS step in a custom preprocessor
+func ESBuildStylePreprocessor(browsers ...string) (StylePreprocessor, error) {
+	engines, err := parseEngines(browsers)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(path string, lang string, style string) (string, error) {
+		if !isCSSLang(lang) {
+			return style, nil
+		}
+
+		result := esbuild.Transform(style, esbuild.TransformOptions{
+			Loader:     esbuild.LoaderCSS,
+			Engines:    engines,
+			Sourcefile: path,
+			LogLevel:   esbuild.LogLevelSilent,
+		})
+		if len(result.Errors) > 0 {
+			msg := strings.Join(esbuild.FormatMessages(result.Errors, esbuild.FormatMessagesOptions{
+				Kind: esbuild.ErrorMessage,
+			}), "\n")
+			return "", fmt.Errorf("invalid CSS: %s", msg)
+		}
+
+		return string(result.Code), nil
+	}, nil
+}
+
+var engineNames = map[string]esbuild.EngineName{
+	"chrome":  esbuild.EngineChrome,
+	"edge":    esbuild.EngineEdge,
+	"firefox": esbuild.EngineFirefox,
+	"ios":     esbuild.EngineIOS,
+	"safari":  esbuild.EngineSafari,
+}
+
+var engineRegexp = regexp.MustCompile(`^([a-z]+)(\d+(?:\.\d+)*)$`)
+
+// parseEngines parses browser versions in esbuild's format, i.e. "chrome58"
+func parseEngines(browsers []string) ([]esbuild.Engine, error) {
+	engines := make([]esbuild.Engine, 0, len(browsers))
+	for _, browser := range browsers {
+		match := engineRegexp.FindStringSubmatch(strings.ToLower(browser))
+		if match == nil {
+			return nil, fmt.Errorf("invalid browser version %q, i.e. chrome58", browser)
+		}
+		name, ok := engineNames[match[1]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported browser %q", match[1])
+		}
+		engines = append(engines, esbuild.Engine{Name: name, Version: match[2]})
+	}
+
+	return engines, nil
+}
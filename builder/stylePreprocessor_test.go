@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSass replaces the $primary variable, enough to tell scss went through it
+func fakeSass(_ string, lang string, style string) (string, error) {
+	if lang != "scss" {
+		return style, nil
+	}
+	style = strings.Replace(style, "$primary: red;", "", 1)
+	return strings.ReplaceAll(style, "$primary", "red"), nil
+}
+
+func TestPreprocessStyles(t *testing.T) {
+	plain := []byte(`<h1>Hi</h1><style>h1 { color: red }</style>`)
+	code, err := preprocessStyles("Plain.svelte", plain, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, code)
+
+	scss := []byte("<h1>Hi</h1>\n<style lang=\"scss\">$primary: red; h1 { color: $primary }</style>\n")
+	_, err = preprocessStyles("Sass.svelte", scss, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `Sass.svelte: <style lang="scss">`)
+
+	var langs []string
+	code, err = preprocessStyles("Sass.svelte", scss, func(path string, lang string, style string) (string, error) {
+		assert.Equal(t, "Sass.svelte", path)
+		langs = append(langs, lang)
+		return fakeSass(path, lang, style)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"scss"}, langs)
+	assert.Equal(t, "<h1>Hi</h1>\n<style lang=\"scss\"> h1 { color: red }</style>\n", string(code))
+}
+
+func TestESBuildStylePreprocessor(t *testing.T) {
+	preprocessor, err := ESBuildStylePreprocessor("chrome58")
+	assert.NoError(t, err)
+
+	css, err := preprocessor("Index.svelte", "", "h1 { color: #ff000080 } :global(body) { margin: 0 }")
+	assert.NoError(t, err)
+	assert.Contains(t, css, "rgba(255, 0, 0, 0.502)")
+	assert.Contains(t, css, ":global(body)")
+
+	//other languages are left to the preprocessors before it
+	css, err = preprocessor("Index.svelte", "scss", "h1 { color: $primary }")
+	assert.NoError(t, err)
+	assert.Equal(t, "h1 { color: $primary }", css)
+
+	_, err = ESBuildStylePreprocessor("netscape4")
+	assert.Error(t, err)
+}
+
+func TestSvelteComponentsPlugin_StylePreprocessor(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+	b := NewBrowserBuilder(nopLogger{}, vm, cache, "", nil, BuildOptions{})
+
+	workingDir := t.TempDir()
+	source := []byte("<h1>Hello</h1>\n<style lang=\"scss\">\n  $primary: red;\n  h1 { color: $primary }\n</style>\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(workingDir, "Hello.svelte"), source, 0644))
+
+	build := func(preprocessor StylePreprocessor) esbuild.BuildResult {
+		return esbuild.Build(esbuild.BuildOptions{
+			Stdin: &esbuild.StdinOptions{
+				Contents:   `import Hello from "./Hello.svelte"; console.log(Hello)`,
+				ResolveDir: workingDir,
+				Loader:     esbuild.LoaderJS,
+			},
+			Bundle:   true,
+			Outdir:   "out",
+			LogLevel: esbuild.LogLevelSilent,
+			External: []string{"svelte/internal"},
+			Plugins: []esbuild.Plugin{
+				svelteComponentsPlugin(cache, workingDir, viewsFS{}, &sync.Map{}, preprocessor, b.browserCompile),
+			},
+		})
+	}
+
+	result := build(nil)
+	assert.NotEmpty(t, result.Errors)
+
+	esbuildPreprocessor, err := ESBuildStylePreprocessor("chrome58")
+	assert.NoError(t, err)
+	result = build(func(path string, lang string, style string) (string, error) {
+		css, err := fakeSass(path, lang, style)
+		if err != nil {
+			return "", err
+		}
+		return esbuildPreprocessor(path, "css", css)
+	})
+	assert.Empty(t, result.Errors)
+
+	var bundledCSS string
+	for _, file := range result.OutputFiles {
+		if strings.HasSuffix(file.Path, ".css") {
+			bundledCSS += string(file.Contents)
+		}
+	}
+	assert.Regexp(t, `h1\.svelte-\w+ \{\s*color: red;?\s*\}`, bundledCSS)
+	assert.NotContains(t, bundledCSS, "$primary")
+}
@@ -0,0 +1,286 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	esbuild "github.com/evanw/esbuild/pkg/api"
+)
+
+var typeScriptLangRegexp = regexp.MustCompile(`\blang\s*=\s*["'](ts|typescript)["']`)
+
+// typeScriptTsconfig keeps imports that are only used in the markup, which
+// esbuild can't see and would otherwise remove as unused. Imports of types
+// must use `import type`
+const typeScriptTsconfig = `{"compilerOptions":{"preserveValueImports":true}}`
+
+// preprocessTypeScript strips the types of the <script lang="ts"> blocks of a
+// component so the svelte compiler can parse them. It also returns the source
+// map from the preprocessed component to code, which is empty if code has no
+// TypeScript blocks
+func preprocessTypeScript(path string, code []byte) ([]byte, string, error) {
+	source := string(code)
+	scripts := scriptTagRegexp.FindAllStringSubmatchIndex(source, -1)
+
+	var output strings.Builder
+	mappings := &sourceMapMappings{}
+	//copied is the end of the source already written to output
+	copied := 0
+	for _, script := range scripts {
+		attrs := source[script[2]:script[3]]
+		if !typeScriptLangRegexp.MatchString(attrs) {
+			continue
+		}
+
+		contentStart, contentEnd := script[4], script[5]
+		result := esbuild.Transform(source[contentStart:contentEnd], esbuild.TransformOptions{
+			Loader:      esbuild.LoaderTS,
+			Sourcemap:   esbuild.SourceMapExternal,
+			Sourcefile:  path,
+			TsconfigRaw: typeScriptTsconfig,
+			LogLevel:    esbuild.LogLevelSilent,
+		})
+		startLine, startColumn := textPosition(source[:contentStart])
+		if len(result.Errors) > 0 {
+			return nil, "", typeScriptError(path, result.Errors[0], startLine, startColumn)
+		}
+
+		mappings.addUnchanged(source[copied:contentStart], source[:copied], output.String())
+		output.WriteString(source[copied:contentStart])
+
+		err := mappings.addShifted(string(result.Map), output.String(), startLine, startColumn)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read the source map of %s: %w", path, err)
+		}
+		output.WriteString(string(result.Code))
+		copied = contentEnd
+	}
+	if copied == 0 {
+		return code, "", nil
+	}
+
+	mappings.addUnchanged(source[copied:], source[:copied], output.String())
+	output.WriteString(source[copied:])
+
+	sourceMap, err := json.Marshal(map[string]interface{}{
+		"version":        3,
+		"sources":        []string{path},
+		"sourcesContent": []string{source},
+		"names":          []string{},
+		"mappings":       mappings.encode(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return []byte(output.String()), string(sourceMap), nil
+}
+
+// compilerSourceMap returns the sourcemap argument of the svelte compiler for
+// the source map of a preprocessed component
+func compilerSourceMap(sourceMap string) string {
+	if len(sourceMap) == 0 {
+		return "null"
+	}
+	return sourceMap
+}
+
+// typeScriptError formats an esbuild error in a script block starting at line
+// and column of the component
+func typeScriptError(path string, msg esbuild.Message, line int, column int) error {
+	if msg.Location == nil {
+		return fmt.Errorf("failed to compile TypeScript in %s: %s", path, msg.Text)
+	}
+
+	errLine := line + msg.Location.Line - 1
+	errColumn := msg.Location.Column
+	if msg.Location.Line == 1 {
+		errColumn += column
+	}
+	return fmt.Errorf("failed to compile TypeScript in %s:%d:%d: %s", path, errLine+1, errColumn, msg.Text)
+}
+
+// textPosition returns the zero based line and UTF-16 column at the end of
+// text, the unit source maps use
+func textPosition(text string) (int, int) {
+	line := strings.Count(text, "\n")
+	lastLine := text[strings.LastIndex(text, "\n")+1:]
+	return line, len(utf16.Encode([]rune(lastLine)))
+}
+
+// sourceMapSegment maps a generated column to a position of the only source
+type sourceMapSegment struct {
+	generatedColumn int
+	sourceLine      int
+	sourceColumn    int
+}
+
+// sourceMapMappings are the mappings of a source map with a single source,
+// one list of segments per generated line
+type sourceMapMappings struct {
+	lines [][]sourceMapSegment
+}
+
+func (m *sourceMapMappings) add(generatedLine int, segment sourceMapSegment) {
+	for len(m.lines) <= generatedLine {
+		m.lines = append(m.lines, nil)
+	}
+	m.lines[generatedLine] = append(m.lines[generatedLine], segment)
+}
+
+// addUnchanged maps every line of text, copied from the source as is, to its
+// line of the source. sourceBefore and generatedBefore are the source and
+// output preceding text
+func (m *sourceMapMappings) addUnchanged(text string, sourceBefore string, generatedBefore string) {
+	sourceLine, sourceColumn := textPosition(sourceBefore)
+	generatedLine, generatedColumn := textPosition(generatedBefore)
+
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			generatedColumn, sourceColumn = 0, 0
+		}
+		if len(line) > 0 {
+			m.add(generatedLine+i, sourceMapSegment{generatedColumn, sourceLine + i, sourceColumn})
+		}
+	}
+}
+
+// addShifted adds the mappings of the esbuild source map of a script block
+// placed after generated in the output, and starting at line and column of the
+// source
+func (m *sourceMapMappings) addShifted(rawMap string, generated string, line int, column int) error {
+	parsed := struct {
+		Mappings string `json:"mappings"`
+	}{}
+	err := json.Unmarshal([]byte(rawMap), &parsed)
+	if err != nil {
+		return err
+	}
+
+	generatedLine, generatedColumn := textPosition(generated)
+	lines, err := decodeMappings(parsed.Mappings)
+	if err != nil {
+		return err
+	}
+	for i, segments := range lines {
+		for _, segment := range segments {
+			if i == 0 {
+				segment.generatedColumn += generatedColumn
+			}
+			if segment.sourceLine == 0 {
+				segment.sourceColumn += column
+			}
+			segment.sourceLine += line
+			m.add(generatedLine+i, segment)
+		}
+	}
+
+	return nil
+}
+
+const base64VLQChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// decodeMappings decodes the mappings of a source map with a single source
+func decodeMappings(mappings string) ([][]sourceMapSegment, error) {
+	var lines [][]sourceMapSegment
+	sourceLine, sourceColumn := 0, 0
+	for _, line := range strings.Split(mappings, ";") {
+		var segments []sourceMapSegment
+		generatedColumn := 0
+		for _, rawSegment := range strings.Split(line, ",") {
+			if len(rawSegment) == 0 {
+				continue
+			}
+			fields, err := decodeVLQ(rawSegment)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("invalid source map mapping %q", rawSegment)
+			}
+			generatedColumn += fields[0]
+			//segments without a source position can't be shifted
+			if len(fields) < 4 {
+				continue
+			}
+			sourceLine += fields[2]
+			sourceColumn += fields[3]
+			segments = append(segments, sourceMapSegment{generatedColumn, sourceLine, sourceColumn})
+		}
+		lines = append(lines, segments)
+	}
+
+	return lines, nil
+}
+
+func decodeVLQ(segment string) ([]int, error) {
+	var fields []int
+	value, shift := 0, 0
+	for _, char := range segment {
+		digit := strings.IndexRune(base64VLQChars, char)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid source map mapping %q", segment)
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value, shift = 0, 0
+	}
+
+	return fields, nil
+}
+
+func encodeVLQ(b *strings.Builder, value int) {
+	vlq := value << 1
+	if value < 0 {
+		vlq = (-value << 1) | 1
+	}
+	for {
+		digit := vlq & 31
+		vlq >>= 5
+		if vlq > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64VLQChars[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
+
+// encode returns the mappings field of the source map
+func (m *sourceMapMappings) encode() string {
+	var b strings.Builder
+	sourceLine, sourceColumn := 0, 0
+	for i, segments := range m.lines {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		generatedColumn := 0
+		for j, segment := range segments {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			encodeVLQ(&b, segment.generatedColumn-generatedColumn)
+			//the only source
+			encodeVLQ(&b, 0)
+			encodeVLQ(&b, segment.sourceLine-sourceLine)
+			encodeVLQ(&b, segment.sourceColumn-sourceColumn)
+			generatedColumn = segment.generatedColumn
+			sourceLine, sourceColumn = segment.sourceLine, segment.sourceColumn
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreprocessTypeScript(t *testing.T) {
+	fixture, err := os.ReadFile("test_data/typescript/TypedProps.svelte")
+	assert.NoError(t, err)
+
+	code, sourceMap, err := preprocessTypeScript("typescript/TypedProps.svelte", fixture)
+	assert.NoError(t, err)
+	output := string(code)
+
+	assert.NotContains(t, output, "interface User")
+	assert.NotContains(t, output, ": string")
+	assert.Contains(t, output, "export let user;")
+	//imports used only in the markup are kept
+	assert.Contains(t, output, `import Nested from "../views/index.svelte"`)
+	assert.NotContains(t, output, "Snapshot }")
+	//the markup is untouched
+	assert.True(t, strings.HasSuffix(output, "<h1 class={size}>{greeting(user)}</h1>\n<Nested />\n{#if snapshot}<p>{snapshot}</p>{/if}\n"))
+
+	parsed := struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}{}
+	assert.NoError(t, json.Unmarshal([]byte(sourceMap), &parsed))
+	assert.Equal(t, []string{"typescript/TypedProps.svelte"}, parsed.Sources)
+
+	//lines of the output map back to the lines they came from
+	lines, err := decodeMappings(parsed.Mappings)
+	assert.NoError(t, err)
+	sourceLines := strings.Split(string(fixture), "\n")
+	for i, line := range strings.Split(output, "\n") {
+		for _, marker := range []string{"export let size", "<h1 class", "<Nested />"} {
+			if !strings.Contains(line, marker) {
+				continue
+			}
+			if assert.NotEmpty(t, lines[i], marker) {
+				assert.Contains(t, sourceLines[lines[i][0].sourceLine], marker)
+			}
+		}
+	}
+
+	//components without TypeScript are compiled as is
+	plain := []byte(`<script>export let name</script><h1>{name}</h1>`)
+	code, sourceMap, err = preprocessTypeScript("Plain.svelte", plain)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, code)
+	assert.Empty(t, sourceMap)
+
+	_, _, err = preprocessTypeScript("Broken.svelte", []byte("<h1>Hi</h1>\n<script lang=\"ts\">\n  let x: = 1\n</script>"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Broken.svelte:3:")
+}
+
+func TestSSRBuilder_TypeScript(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	fixture, err := os.ReadFile("test_data/typescript/TypedProps.svelte")
+	assert.NoError(t, err)
+
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+
+	s := NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	out, err := s.ssrCompile("TypedProps.svelte", "typescript/TypedProps.svelte", fixture)
+	assert.NoError(t, err)
+	assert.Contains(t, out.JSCode, "greeting(user)")
+	assert.NotContains(t, out.JSCode, ": User")
+
+	b := NewBrowserBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	out, err = b.browserCompile("TypedProps.svelte", "typescript/TypedProps.svelte", fixture)
+	assert.NoError(t, err)
+	assert.Contains(t, out.JSCode, "Nested")
+}
@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var cssCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/`)
+var cssSelectorNameRegexp = regexp.MustCompile(`([.#])(-?[_a-zA-Z][_a-zA-Z0-9-]*)`)
+var htmlAttrRegexp = regexp.MustCompile(`\s(class|id)=(?:"([^"]*)"|'([^']*)')`)
+
+// UnusedCSS renders the view and returns the selectors in its stylesheets that
+// reference a class or id that isn't in the rendered HTML. Only the markup of
+// this render is considered, so selectors used for other props or states are
+// reported too. It's meant as a diagnostic, nothing is removed
+func (v *ViewManager) UnusedCSS(
+	ctx context.Context,
+	viewPath string,
+	props interface{},
+	opts ...RenderOption,
+) ([]string, error) {
+	rendered, err := v.renderView(ctx, viewPath, props, opts...)
+	if err != nil {
+		return nil, err
+	}
+	view := v.ViewByRelPath(viewPath)
+
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	cssNames := view.CSSImports
+	if _, ok := v.staticContent[baseCSSStyleName]; ok {
+		cssNames = append([]string{baseCSSStyleName}, cssNames...)
+	}
+
+	var css strings.Builder
+	for _, name := range cssNames {
+		css.Write(v.staticContent[name].Content)
+		css.WriteString("\n")
+	}
+
+	return unusedSelectors(css.String(), rendered.Head+rendered.Body), nil
+}
+
+// unusedSelectors returns the sorted selectors in css that reference a class or
+// an id not present in html
+func unusedSelectors(css string, html string) []string {
+	used := map[string]bool{}
+	for _, match := range htmlAttrRegexp.FindAllStringSubmatch(html, -1) {
+		prefix := "."
+		if match[1] == "id" {
+			prefix = "#"
+		}
+		for _, name := range strings.Fields(match[2] + match[3]) {
+			used[prefix+name] = true
+		}
+	}
+
+	unused := map[string]bool{}
+	css = cssCommentRegexp.ReplaceAllString(css, "")
+	for _, block := range strings.Split(css, "}") {
+		//the selectors are the text between the last { and the previous } or {,
+		//which also handles rules nested in @media blocks
+		declStart := strings.LastIndex(block, "{")
+		if declStart < 0 {
+			continue
+		}
+		selectorList := block[:declStart]
+		if nestedStart := strings.LastIndex(selectorList, "{"); nestedStart >= 0 {
+			selectorList = selectorList[nestedStart+1:]
+		}
+		if strings.HasPrefix(strings.TrimSpace(selectorList), "@") {
+			continue
+		}
+
+		for _, selector := range strings.Split(selectorList, ",") {
+			selector = strings.TrimSpace(selector)
+			for _, match := range cssSelectorNameRegexp.FindAllStringSubmatch(selector, -1) {
+				//svelte scoping classes are always present on the elements they scope
+				if strings.HasPrefix(match[2], "svelte-") {
+					continue
+				}
+				if !used[match[1]+match[2]] {
+					unused[selector] = true
+					break
+				}
+			}
+		}
+	}
+
+	selectors := make([]string, 0, len(unused))
+	for selector := range unused {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	return selectors
+}
@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewManager_UnusedCSS(t *testing.T) {
+	vm := newStaticRenderVM(`{"body":"<div class=\"card svelte-x1\" id=\"main\"><p class='title svelte-x1'>Cars</p></div>"}`)
+	v, _ := newTestViewManager(vm)
+	v.staticContent["Index.svelte.css"] = StaticAsset{
+		Content: []byte(`
+			/* .commented { color: red } */
+			.card.svelte-x1 { padding: 1em }
+			.title.svelte-x1, .subtitle.svelte-x1 { font-weight: bold }
+			#main { margin: 0 }
+			#sidebar .card { width: 0.5em }
+			@media print {
+				.no-print.svelte-x1 { display: none }
+				.card.svelte-x1 { padding: 0 }
+			}
+			@keyframes spin { from { opacity: 0 } to { opacity: 1 } }
+		`),
+	}
+
+	unused, err := v.UnusedCSS(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"#sidebar .card",
+		".no-print.svelte-x1",
+		".subtitle.svelte-x1",
+	}, unused)
+}
@@ -34,6 +34,20 @@ type View struct {
 	//treated as an entrypoint for both SSR and Browser JS
 	IsEntrypoint bool
 
+	//IsErrorView is true for +error.svelte components, which are entrypoints
+	IsErrorView bool
+
+	//StaticOnly views are rendered without JS imports and the props script
+	StaticOnly bool
+
+	//CacheVary are the render context keys set by the view's metadata file that
+	//its render cache key varies on
+	CacheVary []string
+
+	//ErrorView is rendered in place of this view when it throws during SSR. nil
+	//if no +error.svelte applies
+	ErrorView *View
+
 	//ApplicableLayouts is a slice of Views that represent layouts that apply to this
 	//view. Lower index means the layout is closer to this view in the ancestral hierarchy
 	ApplicableLayoutViews []*View
@@ -43,6 +57,10 @@ type View struct {
 	JSImports  []string
 	CSSImports []string
 
+	//staticHeadTags holds the rendered JS and CSS import tags. They don't change
+	//between renders of the same view, so they are computed once per build
+	staticHeadTags string
+
 	//applicableLayouts is used temporarily internally by viewManger
 	applicableLayouts []*Layout
 }
@@ -65,17 +83,32 @@ func newViewFromComponent(c *Component) *View {
 	if unicode.IsUpper(firstRune) && unicode.IsLetter(firstRune) {
 		isEntrypoint = true
 	}
+	//error views are rendered in place of the view that failed
+	if c.isErrorComponent {
+		isEntrypoint = true
+	}
+
+	meta := ComponentMeta{}
+	if c.Meta != nil {
+		meta = *c.Meta
+	}
+	if meta.Entrypoint != nil {
+		isEntrypoint = *meta.Entrypoint
+	}
 
 	uniqueName := utils.PathPascalCase(c.RelativePath())
 	return &View{
 		Path:              c.Path,
 		RelPath:           c.RelativePath(),
 		UniqueName:        uniqueName,
-		WrappedUniqueName: "__AviatorWrapped_" + uniqueName,
+		WrappedUniqueName: wrappedNamePrefix + uniqueName,
 		ComponentName:     c.Name,
 		Component:         c,
 		Layout:            c.Layout,
 		IsEntrypoint:      isEntrypoint,
+		IsErrorView:       c.isErrorComponent,
+		StaticOnly:        meta.StaticOnly,
+		CacheVary:         meta.CacheVary,
 	}
 }
 
@@ -85,7 +118,7 @@ func newViewFromLayout(l *Layout) *View {
 		Path:              l.Path,
 		RelPath:           l.RelativePath(),
 		UniqueName:        uniqueName,
-		WrappedUniqueName: "__AviatorWrapped_" + uniqueName,
+		WrappedUniqueName: wrappedNamePrefix + uniqueName,
 		ComponentName:     l.Name,
 		Layout:            l,
 		IsLayout:          true,
@@ -1,9 +1,15 @@
 package builder
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -53,11 +59,27 @@ type ViewManager struct {
 	tree      *componentTree
 	vm        js.VM
 
+	//files reads the view sources
+	files viewsFS
+
+	//hasBuilt is set after the first successful build. Builds outside of dev
+	//mode never change after it
+	hasBuilt bool
+	//isExported is set for views loaded from an export, which have no sources
+	//to build from
+	isExported bool
+
 	htmlGenerator *template.Template
 
 	//ssrCacheManager     *cacheManager
 	//browserCacheManager *cacheManager
-	watcher       *watcher.Batcher
+	watcher *watcher.Batcher
+
+	//viewsLock guards swapping views and staticContent. Every build creates new
+	//ones, so they aren't modified once swapped in and renders only need the
+	//lock to read the maps. It also guards swapping htmlGenerator when the
+	//template file is reloaded
+	viewsLock     sync.RWMutex
 	views         map[string]*View
 	staticContent map[string]StaticAsset
 
@@ -68,143 +90,826 @@ type ViewManager struct {
 	ssrBuilder        *SSRBuilder
 	logger            utils.Logger
 	staticAssetsRoute string
-	htmlLang          string
-
+	//jsRoute and cssRoute prefix the JS and CSS import tags
+	jsRoute  string
+	cssRoute string
+	htmlLang string
+
+	serviceWorkerScope string
+	useImportMap       bool
+	hash               HashFunc
+	propsEncoder       func(props interface{}) ([]byte, error)
+	propsReviver       string
+
+	hasStoreInitializer bool
+
+	//ssrBundle is the JS of the last SSR build
+	ssrBundle []byte
+	//buildVersion is the hash of ssrBundle and staticContent
+	buildVersion string
+
+	tempFilePatterns []string
+
+	//renderableViews are globs of the views that can be rendered. All views
+	//can be rendered when it's empty
+	renderableViews []string
+
+	cssMediaRules []CSSMediaRule
+
+	//concurrencyRules cap how many renders of a view run at once. viewSlots
+	//holds a semaphore per limited view, created on its first render
+	concurrencyRules []ViewConcurrencyRule
+	viewSlots        map[string]chan struct{}
+	viewSlotsLock    sync.Mutex
+
+	cacheVaryRules []CacheVaryRule
+
+	buildInfo map[string]string
+
+	//htmlTemplateFile is reparsed into htmlGenerator when it changes in dev mode
+	htmlTemplateFile string
+	//watchesHTMLTemplateDir is true when the template is outside the views
+	//directory and its directory had to be watched separately
+	watchesHTMLTemplateDir bool
+
+	//prettyHTML indents the rendered documents
+	prettyHTML bool
+	//compactPropsScript drops the whitespace in and after the props script
+	compactPropsScript bool
+	//omitNilPropsScript leaves the props script out of pages rendered with nil props
+	omitNilPropsScript bool
+	//isolateLayoutProps renders layouts with the layout props instead of the page's
+	isolateLayoutProps bool
+
+	//explicitEntrypoints only makes the registered views entrypoints
+	explicitEntrypoints bool
+	entrypointsLock     sync.Mutex
+	entrypoints         map[string]bool
+
+	//deterministicSSR seeds Math.random with ssrSeed before every render
+	deterministicSSR bool
+	ssrSeed          int64
+
+	//outputPath is where static assets are written after every build
+	outputPath string
+
+	//userStaticContent are the assets added to the built assets after every build
+	userStaticContent map[string]StaticAsset
+
+	//Mutex serializes builds, handling watcher events and Close. It guards
+	//hasBuilt, the caches, the component tree and the watcher
 	sync.Mutex
 }
 
-func NewViewManager(
-	logger utils.Logger,
-	vm js.VM,
-	tree ComponentTree,
-	htmlGenerator *template.Template,
-	isDevMode bool,
-	cacheDir string,
-	viewsDir string,
-	staticAssetsRoute string,
-	htmlLang string,
-) (*ViewManager, error) {
-	viewWatcher, err := watcher.New(eventBatchTime)
-	if err != nil {
-		return nil, err
+// CSSMediaRule sets the media attribute of the link tags of the CSS assets whose
+// name matches Glob
+type CSSMediaRule struct {
+	Glob  string
+	Media string
+}
+
+// ViewConcurrencyRule limits the number of concurrent renders of each view whose
+// path relative to the views directory matches Glob
+type ViewConcurrencyRule struct {
+	Glob  string
+	Limit int
+}
+
+// ViewManagerConfig holds everything needed to create a ViewManager
+type ViewManagerConfig struct {
+	Logger        utils.Logger
+	VM            js.VM
+	Tree          ComponentTree
+	HTMLGenerator *template.Template
+
+	IsDevMode         bool
+	CacheDir          string
+	ViewsDir          string
+	StaticAssetsRoute string
+	HTMLLang          string
+
+	//JSRoute and CSSRoute are the routes or URL prefixes of the JS and CSS
+	//assets in the rendered pages. Both default to StaticAssetsRoute
+	JSRoute  string
+	CSSRoute string
+
+	//SkipNodeModulesCache compiles the components in node_modules on every
+	//startup instead of caching them
+	SkipNodeModulesCache bool
+
+	//ViewsFS is read in place of ViewsDir when set. Views aren't watched in dev
+	//mode since they can't change
+	ViewsFS fs.FS
+
+	//HTMLTemplateFile is parsed into the HTML document template, replacing
+	//HTMLGenerator. It's reparsed whenever it changes in dev mode
+	HTMLTemplateFile string
+
+	//PrettyHTML puts every tag of the rendered documents on its own line,
+	//indented by its nesting level. Meant for debugging in dev mode
+	PrettyHTML bool
+
+	//CompactPropsScript removes insignificant whitespace from the props payload
+	//and the newline after the props and context scripts
+	CompactPropsScript bool
+
+	//OmitNilPropsScript leaves the props script out of pages rendered with nil
+	//props, their views are hydrated with undefined props
+	OmitNilPropsScript bool
+
+	//IsolateLayoutProps renders layouts with only the props set with the
+	//LayoutProps render option instead of the props of the page
+	IsolateLayoutProps bool
+
+	//ExplicitEntrypoints disables detecting entrypoints by the casing of their
+	//file name. Only the views in Entrypoints, those whose metadata declares
+	//them as entrypoints and the error views of those are entrypoints
+	ExplicitEntrypoints bool
+	//Entrypoints are the relative paths of the registered entrypoints
+	Entrypoints []string
+
+	//DeterministicSSR replaces Math.random during SSR with a PRNG seeded with
+	//SSRSeed before every render, so renders are reproducible. It doesn't
+	//affect the browser
+	DeterministicSSR bool
+	SSRSeed          int64
+
+	//OutputPath is the directory static assets are written to after every
+	//build, for serving them without Aviator. Nothing is written when empty
+	OutputPath string
+
+	//CacheNamespace is the subdirectory of CacheDir the caches are stored in
+	CacheNamespace string
+
+	//CacheMode sets where compiled components are cached. CacheDir is only
+	//used by CacheModeDisk, the default
+	CacheMode CacheMode
+
+	//CompilerHash identifies the svelte compiler. Caches are discarded when it
+	//changes
+	CompilerHash string
+
+	//ServiceWorkerScope enables the generated service worker when it's not empty
+	ServiceWorkerScope string
+
+	//UseImportMap emits an import map and a single entry module instead of
+	//a module script per JS asset
+	UseImportMap bool
+
+	//CacheKeyHash is used to compute asset versions and cache keys.
+	//Defaults to a truncated sha1 digest
+	CacheKeyHash HashFunc
+
+	//PropsEncoder serializes props to JSON. Defaults to json.Marshal
+	PropsEncoder func(props interface{}) ([]byte, error)
+
+	//PropsKeyCase transforms the keys of the encoded props object. Nested
+	//objects are only transformed when PropsKeyCaseNested is set
+	PropsKeyCase       PropsKeyCase
+	PropsKeyCaseNested bool
+
+	//TempFilePatterns are matched against the base name of changed files in
+	//addition to the built-in editor temp file rules. A pattern without
+	//wildcards matches as a suffix
+	TempFilePatterns []string
+
+	//InitBuildRetries is the number of times the initial build is retried when
+	//it fails because a file couldn't be read. Compile errors are not retried
+	InitBuildRetries int
+
+	//RenderableViews are path.Match globs matched against the relative path of
+	//a view. Rendering a view that matches none of them fails with
+	//ErrViewNotRenderable. All views can be rendered when it's empty
+	RenderableViews []string
+
+	//StaticAssets are served along with the built assets, i.e. locale specific
+	//variants of them. Files are named by their path in the FS
+	StaticAssets fs.FS
+
+	//CSSMedia sets the media attribute of the link tags of matching CSS assets
+	CSSMedia []CSSMediaRule
+
+	//ViewConcurrency caps the concurrent renders of matching views. The last
+	//matching rule wins
+	ViewConcurrency []ViewConcurrencyRule
+
+	//CacheBackend shares compiled components between machines. Components
+	//missing from the local cache are looked up in it
+	CacheBackend CacheBackend
+
+	//CacheVary declares the render context values RenderCacheKey includes for
+	//matching views. The dimensions of all matching rules are combined
+	CacheVary []CacheVaryRule
+
+	BuildOptions BuildOptions
+}
+
+func NewViewManager(config ViewManagerConfig) (*ViewManager, error) {
+	//views only change during development
+	var viewWatcher *watcher.Batcher
+	var err error
+	if config.IsDevMode && config.ViewsFS == nil {
+		viewWatcher, err = watcher.New(eventBatchTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cacheNamespace := filepath.Join(config.CacheNamespace, config.BuildOptions.cacheKey())
+	cacheFingerprint := config.BuildOptions.cacheFingerprint(config.CompilerHash)
+	files := viewsFS{root: config.ViewsDir, fsys: config.ViewsFS}
+
+	var ssrCache, browserCache Cache
+	switch config.CacheMode {
+	case CacheModeMemory:
+		ssrCache = newMemoryCacheManager(CacheTypeSSR, files)
+		browserCache = newMemoryCacheManager(CacheTypeBrowser, files)
+	case CacheModeDisabled:
+		ssrCache, _ = newNopCache()
+		browserCache, _ = newNopCache()
+	default:
+		ssrCache, err = newCacheManager(CacheTypeSSR, config.CacheDir, cacheNamespace, cacheFingerprint, files)
+		if err != nil {
+			return nil, err
+		}
+
+		browserCache, err = newCacheManager(CacheTypeBrowser, config.CacheDir, cacheNamespace, cacheFingerprint, files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CacheBackend != nil {
+		ssrCache = newBackendCache(ssrCache, config.CacheBackend, CacheTypeSSR, cacheFingerprint, files, config.Logger)
+		browserCache = newBackendCache(browserCache, config.CacheBackend, CacheTypeBrowser, cacheFingerprint, files, config.Logger)
+	}
+
+	//wraps the backend too, so node_modules aren't shared either
+	if config.SkipNodeModulesCache {
+		ssrCache = &skipNodeModulesCache{ssrCache}
+		browserCache = &skipNodeModulesCache{browserCache}
+	}
+
+	v := newViewManager(config)
+	v.watcher = viewWatcher
+	v.tree = config.Tree.(*componentTree)
+	v.ssrCache = ssrCache
+	v.browserCache = browserCache
+	v.ssrBuilder = NewSSRBuilder(config.Logger, config.VM, ssrCache, config.ViewsDir, config.ViewsFS, config.BuildOptions)
+	v.browserBuilder = NewBrowserBuilder(config.Logger, config.VM, browserCache, config.ViewsDir, config.ViewsFS, config.BuildOptions)
+
+	if len(v.htmlTemplateFile) > 0 {
+		v.htmlTemplateFile = filepath.Clean(v.htmlTemplateFile)
+		err = v.loadHTMLTemplate()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	ssrCache, err := newCacheManager(CacheTypeSSR, cacheDir) // newNopCache()
+	v.userStaticContent, err = loadStaticAssets(config.StaticAssets)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read static assets: %w", err)
 	}
 
-	browserCache, err := newCacheManager(CacheTypeBrowser, cacheDir) //newNopCache()
+	err = v.initPropsReviver()
 	if err != nil {
 		return nil, err
 	}
 
-	ssrBuilder := NewSSRBuilder(logger, vm, ssrCache, viewsDir)
-	browserBuilder := NewBrowserBuilder(logger, vm, browserCache, viewsDir)
-	v := &ViewManager{
-		vm:                vm,
-		logger:            logger,
-		watcher:           viewWatcher,
-		tree:              tree.(*componentTree),
-		htmlGenerator:     htmlGenerator,
-		isDevMode:         isDevMode,
-		browserBuilder:    browserBuilder,
-		ssrBuilder:        ssrBuilder,
-		ssrCache:          ssrCache,
-		browserCache:      browserCache,
-		viewsDir:          viewsDir,
-		staticAssetsRoute: staticAssetsRoute,
-		htmlLang:          htmlLang,
+	err = retryBuild(v.logger, config.InitBuildRetries, initBuildBackoff, v.Build)
+
+	return v, err
+}
+
+// newViewManager creates a ViewManager with the rendering related config applied
+func newViewManager(config ViewManagerConfig) *ViewManager {
+	hash := config.CacheKeyHash
+	if hash == nil {
+		hash = defaultHash
+	}
+
+	jsRoute := config.JSRoute
+	if len(jsRoute) == 0 {
+		jsRoute = config.StaticAssetsRoute
+	}
+	cssRoute := config.CSSRoute
+	if len(cssRoute) == 0 {
+		cssRoute = config.StaticAssetsRoute
 	}
 
-	v.refreshViews()
-	err = v.Build()
+	propsEncoder := config.PropsEncoder
+	if propsEncoder == nil {
+		propsEncoder = json.Marshal
+	}
+	if len(config.PropsKeyCase) > 0 && config.PropsKeyCase != PropsKeyCaseAsIs {
+		propsEncoder = withPropsKeyCase(propsEncoder, config.PropsKeyCase, config.PropsKeyCaseNested)
+	}
 
-	return v, err
+	return &ViewManager{
+		vm:                  config.VM,
+		logger:              config.Logger,
+		htmlGenerator:       config.HTMLGenerator,
+		isDevMode:           config.IsDevMode,
+		viewsDir:            config.ViewsDir,
+		staticAssetsRoute:   config.StaticAssetsRoute,
+		jsRoute:             jsRoute,
+		cssRoute:            cssRoute,
+		htmlLang:            config.HTMLLang,
+		serviceWorkerScope:  config.ServiceWorkerScope,
+		useImportMap:        config.UseImportMap,
+		hash:                hash,
+		propsEncoder:        propsEncoder,
+		propsReviver:        config.BuildOptions.PropsReviver,
+		hasStoreInitializer: len(config.BuildOptions.StoreInitializer) > 0,
+		tempFilePatterns:    config.TempFilePatterns,
+		renderableViews:     config.RenderableViews,
+		cssMediaRules:       config.CSSMedia,
+		concurrencyRules:    config.ViewConcurrency,
+		cacheVaryRules:      config.CacheVary,
+		viewSlots:           map[string]chan struct{}{},
+		buildInfo:           config.BuildOptions.BuildInfo,
+		outputPath:          config.OutputPath,
+		htmlTemplateFile:    config.HTMLTemplateFile,
+		prettyHTML:          config.PrettyHTML,
+		compactPropsScript:  config.CompactPropsScript,
+		omitNilPropsScript:  config.OmitNilPropsScript,
+		isolateLayoutProps:  config.IsolateLayoutProps,
+		explicitEntrypoints: config.ExplicitEntrypoints,
+		entrypoints:         entrypointSet(config.Entrypoints),
+		deterministicSSR:    config.DeterministicSSR,
+		ssrSeed:             config.SSRSeed,
+		files:               viewsFS{root: config.ViewsDir, fsys: config.ViewsFS},
+		views:               map[string]*View{},
+		staticContent:       map[string]StaticAsset{},
+	}
+}
+
+// initPropsReviver defines the props reviver in every VM so the SSR render
+// can parse props with it
+func (v *ViewManager) initPropsReviver() error {
+	if len(v.propsReviver) == 0 {
+		return nil
+	}
+
+	err := v.vm.InitializationScript(
+		"aviator_props_reviver.js",
+		"var __aviator_props_reviver = ("+v.propsReviver+");",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate props reviver: %w", err)
+	}
+
+	return nil
 }
 
+// Build compiles all views. Outside of dev mode only the first successful build
+// happens and later calls are no-ops
 func (v *ViewManager) Build() error {
-	allViews := v.AllViews()
+	v.Lock()
+	defer v.Unlock()
+
+	return v.build()
+}
+
+// build is Build for callers that hold the lock
+func (v *ViewManager) build() error {
+	if v.isExported {
+		return ErrExportedViews
+	}
+	if v.hasBuilt && !v.isDevMode {
+		return nil
+	}
+
+	//renders keep using the current views and assets until the build is done
+	views, allViews := v.newViewList()
+
+	//dependency upgrades aren't seen by the watcher
+	for _, cache := range []Cache{v.browserCache, v.ssrCache} {
+		err := cache.InvalidateChangedDependencies()
+		if err != nil {
+			v.logger.Error("error invalidating changed dependencies: " + err.Error())
+			return err
+		}
+	}
 
 	//TODO: break up browser builds by page? maybe?
-	staticContent, err := v.browserBuilder.BuildDev(allViews)
+	staticContent, err := v.buildBrowserAssets(allViews)
 	if err != nil {
-		v.logger.Error("error building SSR build: " + err.Error())
 		return err
 	}
-	v.staticContent = staticContent
 
-	err = v.browserCache.Persist()
+	ssrBuild, err := v.buildSSRBundle(allViews)
 	if err != nil {
-		v.logger.Error("error persisting Browser cache: " + err.Error())
 		return err
 	}
 
-	ssrBuild, err := v.ssrBuilder.DevBuild(allViews)
+	if len(ssrBuild.CSS) > 0 {
+		staticContent[baseCSSStyleName] = StaticAsset{
+			Content:  ssrBuild.CSS,
+			MimeType: "text/css",
+		}
+	}
+
+	return v.swapBuild(views, staticContent, ssrBuild.JS)
+}
+
+// BuildBrowser rebuilds the browser assets of the views and keeps the SSR
+// bundle, i.e. after a change that only affects client side code. It does a
+// full Build when nothing was built yet or views were added or removed since,
+// as the SSR bundle wouldn't match them
+func (v *ViewManager) BuildBrowser() error {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.buildBrowser()
+}
+
+func (v *ViewManager) buildBrowser() error {
+	if v.isExported {
+		return ErrExportedViews
+	}
+	views, allViews := v.newViewList()
+	if !v.hasBuilt || !v.hasViewSet(views) {
+		return v.build()
+	}
+
+	err := v.browserCache.InvalidateChangedDependencies()
 	if err != nil {
-		v.logger.Error("error building Browser build: " + err.Error())
+		v.logger.Error("error invalidating changed dependencies: " + err.Error())
 		return err
 	}
 
-	err = v.ssrCache.Persist()
+	staticContent, err := v.buildBrowserAssets(allViews)
+	if err != nil {
+		return err
+	}
+
+	//the base CSS comes from the SSR build
+	v.viewsLock.RLock()
+	if asset, ok := v.staticContent[baseCSSStyleName]; ok {
+		staticContent[baseCSSStyleName] = asset
+	}
+	v.viewsLock.RUnlock()
+
+	return v.swapBuild(views, staticContent, nil)
+}
+
+// BuildSSR rebuilds the SSR bundle of the current views and evaluates it on
+// every VM, i.e. after a change to a shim. Browser assets are kept. It does a
+// full Build when nothing was built yet or views were added or removed since
+func (v *ViewManager) BuildSSR() error {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.buildSSR()
+}
+
+func (v *ViewManager) buildSSR() error {
+	if v.isExported {
+		return ErrExportedViews
+	}
+	views, _ := v.newViewList()
+	if !v.hasBuilt || !v.hasViewSet(views) {
+		return v.build()
+	}
+
+	err := v.ssrCache.InvalidateChangedDependencies()
+	if err != nil {
+		v.logger.Error("error invalidating changed dependencies: " + err.Error())
+		return err
+	}
+
+	//the current views already have their browser assets
+	v.viewsLock.RLock()
+	views = v.views
+	allViews := make([]*View, 0, len(views))
+	for _, view := range views {
+		allViews = append(allViews, view)
+	}
+	staticContent := make(map[string]StaticAsset, len(v.staticContent))
+	for name, asset := range v.staticContent {
+		if name != baseCSSStyleName && name != serviceWorkerName {
+			staticContent[name] = asset
+		}
+	}
+	v.viewsLock.RUnlock()
+
+	ssrBuild, err := v.buildSSRBundle(allViews)
 	if err != nil {
-		v.logger.Error("error persisting SSR cache: " + err.Error())
 		return err
 	}
 
 	if len(ssrBuild.CSS) > 0 {
-		v.staticContent[baseCSSStyleName] = StaticAsset{
+		staticContent[baseCSSStyleName] = StaticAsset{
 			Content:  ssrBuild.CSS,
 			MimeType: "text/css",
 		}
 	}
 
-	_, err = v.vm.Eval(
-		"aviator_ssr_router.js",
-		string(ssrBuild.JS),
-	)
+	return v.swapBuild(views, staticContent, ssrBuild.JS)
+}
+
+// newViewList creates the views of the component tree, as a map by relative
+// path and as a list
+func (v *ViewManager) newViewList() (map[string]*View, []*View) {
+	views := v.newViews()
+	allViews := make([]*View, 0, len(views))
+	for _, view := range views {
+		allViews = append(allViews, view)
+	}
+	return views, allViews
+}
+
+// hasViewSet reports whether views are the views of the last build
+func (v *ViewManager) hasViewSet(views map[string]*View) bool {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	if len(views) != len(v.views) {
+		return false
+	}
+	for relPath := range views {
+		if _, ok := v.views[relPath]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// buildBrowserAssets builds the browser assets of allViews, along with the
+// static assets of the user
+func (v *ViewManager) buildBrowserAssets(allViews []*View) (map[string]StaticAsset, error) {
+	staticContent, err := v.browserBuilder.BuildDev(allViews)
 	if err != nil {
-		return fmt.Errorf("encoutered error while evaluating generated JS code. "+
-			"This is most likely caused by the use of a new or not yet supported JS feature: %+v", err)
+		v.logger.Error("error building SSR build: " + err.Error())
+		return nil, err
+	}
+	for name, asset := range v.userStaticContent {
+		staticContent[name] = asset
 	}
 
-	return err
+	err = v.browserCache.Persist()
+	if err != nil {
+		v.logger.Error("error persisting Browser cache: " + err.Error())
+		return nil, err
+	}
+
+	return staticContent, nil
+}
+
+// buildSSRBundle builds the SSR bundle of allViews and logs its warnings
+func (v *ViewManager) buildSSRBundle(allViews []*View) (*CompiledResult, error) {
+	ssrBuild, err := v.ssrBuilder.DevBuild(allViews)
+	if err != nil {
+		v.logger.Error("error building Browser build: " + err.Error())
+		return nil, err
+	}
+	for _, warning := range ssrBuild.Warnings {
+		v.logger.Error("svelte warning: " + warning.String())
+	}
+
+	err = v.ssrCache.Persist()
+	if err != nil {
+		v.logger.Error("error persisting SSR cache: " + err.Error())
+		return nil, err
+	}
+
+	return ssrBuild, nil
+}
+
+// swapBuild swaps in the built views and assets. ssrBundle is evaluated on
+// every VM first, the current one is kept when it's nil
+func (v *ViewManager) swapBuild(views map[string]*View, staticContent map[string]StaticAsset, ssrBundle []byte) error {
+	if len(v.serviceWorkerScope) > 0 {
+		serviceWorker, err := v.createServiceWorker(staticContent)
+		if err != nil {
+			return err
+		}
+		staticContent[serviceWorkerName] = serviceWorker
+	}
+
+	//a failed build leaves the last good views, assets and SSR bundle in place.
+	//Renders wait for the new bundle to be evaluated on every VM and swapped in
+	v.viewsLock.Lock()
+	if ssrBundle != nil {
+		err := v.vm.InitializationScript(
+			"aviator_ssr_router.js",
+			string(ssrBundle),
+		)
+		if err != nil {
+			//VMs that evaluated the new bundle before the failure go back to the last good one
+			if v.ssrBundle != nil {
+				restoreErr := v.vm.InitializationScript("aviator_ssr_router.js", string(v.ssrBundle))
+				if restoreErr != nil {
+					v.logger.Error("failed to restore the previous SSR bundle: " + restoreErr.Error())
+				}
+			}
+			v.viewsLock.Unlock()
+			return fmt.Errorf("encoutered error while evaluating generated JS code. "+
+				"This is most likely caused by the use of a new or not yet supported JS feature: %+v", err)
+		}
+		v.ssrBundle = ssrBundle
+	}
+	v.views = views
+	v.staticContent = staticContent
+	v.buildVersion = v.computeBuildVersion()
+	v.cacheStaticHeadTags()
+	v.viewsLock.Unlock()
+	v.hasBuilt = true
+
+	if len(v.outputPath) > 0 {
+		_, err := v.PersistAssets()
+		if err != nil {
+			v.logger.Error("error writing static assets: " + err.Error())
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (v *ViewManager) refreshViews() {
-	v.views = map[string]*View{}
+// PersistAssets writes the static assets to the configured output path and
+// returns the path of each written file relative to it by asset name. Files
+// left over from a previous build are removed
+func (v *ViewManager) PersistAssets() (map[string]string, error) {
+	if len(v.outputPath) == 0 {
+		return nil, errors.New("asset output path not configured")
+	}
+
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	return v.browserBuilder.PersistAssets(v.outputPath, v.staticContent)
+}
+
+// newViews creates the views of all components and layouts in the tree
+func (v *ViewManager) newViews() map[string]*View {
+	views := map[string]*View{}
 
 	for _, component := range v.tree.GetAllComponents() {
 		view := newViewFromComponent(component)
 		view.applicableLayouts = component.ApplicableLayouts()
-		v.views[component.RelativePath()] = view
+		views[component.RelativePath()] = view
 	}
 
 	for _, layout := range v.tree.GetAllLayouts() {
 		view := newViewFromLayout(layout)
 		view.applicableLayouts = layout.ApplicableLayouts()
-		v.views[layout.RelativePath()] = view
+		views[layout.RelativePath()] = view
 	}
 
-	for _, view := range v.views {
+	for _, view := range views {
 		layouts := view.getApplicableLayouts()
 		var layoutViews []*View
 		for _, layout := range layouts {
-			layoutViews = append(layoutViews, v.views[layout.RelativePath()])
+			layoutView, ok := views[layout.RelativePath()]
+			//a layout removed from the tree can't wrap the view
+			if !ok {
+				v.logger.Error("skipped layout " + layout.RelativePath() + " of " + view.RelPath + ": it is not in the component tree")
+				continue
+			}
+			layoutViews = append(layoutViews, layoutView)
 		}
 
 		view.ApplicableLayoutViews = layoutViews
+
+		if view.Component != nil {
+			if errorComponent := view.Component.ErrorComponent(); errorComponent != nil {
+				view.ErrorView = views[errorComponent.RelativePath()]
+			}
+		}
+	}
+
+	if v.explicitEntrypoints {
+		v.applyExplicitEntrypoints(views)
+	}
+
+	return views
+}
+
+// entrypointSet returns the relative paths as a set of clean paths
+func entrypointSet(relPaths []string) map[string]bool {
+	set := make(map[string]bool, len(relPaths))
+	for _, relPath := range relPaths {
+		set[filepath.Clean(filepath.FromSlash(relPath))] = true
+	}
+	return set
+}
+
+// RegisterEntrypoint makes the views entrypoints when explicit entrypoints are
+// enabled. Views registered after a build become entrypoints on the next one
+func (v *ViewManager) RegisterEntrypoint(relPaths ...string) {
+	v.entrypointsLock.Lock()
+	defer v.entrypointsLock.Unlock()
+
+	for relPath := range entrypointSet(relPaths) {
+		v.entrypoints[relPath] = true
+	}
+}
+
+// applyExplicitEntrypoints replaces the entrypoints detected from the file names
+// with the registered ones. The error views of entrypoints stay entrypoints,
+// they're rendered in their place
+func (v *ViewManager) applyExplicitEntrypoints(views map[string]*View) {
+	v.entrypointsLock.Lock()
+	defer v.entrypointsLock.Unlock()
+
+	for _, view := range views {
+		view.IsEntrypoint = false
+	}
+	for relPath := range v.entrypoints {
+		if _, ok := views[relPath]; !ok {
+			v.logger.Error("registered entrypoint does not exist: " + relPath)
+		}
+	}
+
+	for relPath, view := range views {
+		if view.IsLayout {
+			continue
+		}
+		meta := view.Component.Meta
+		if !v.entrypoints[relPath] && (meta == nil || meta.Entrypoint == nil || !*meta.Entrypoint) {
+			continue
+		}
+		view.IsEntrypoint = true
+		if view.ErrorView != nil {
+			view.ErrorView.IsEntrypoint = true
+		}
 	}
 }
 
 // ViewByRelPath returns a view by the relative Path
 func (v *ViewManager) ViewByRelPath(path string) *View {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
 	view := v.views[path]
 	return view
 }
 
+// CompileView compiles the svelte component of the view on its own for target,
+// "ssr" or "dom", with the options of the builds. Imports aren't bundled
+func (v *ViewManager) CompileView(viewPath, target string) (*SvelteBuildOutput, error) {
+	view := v.ViewByRelPath(viewPath)
+	if view == nil {
+		return nil, fmt.Errorf("view does not exist in path %s", viewPath)
+	}
+
+	code, err := v.files.ReadFile(view.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source of view %s: %w", viewPath, err)
+	}
+	code = bytes.TrimPrefix(code, utf8BOM)
+
+	switch target {
+	case "ssr":
+		return v.ssrBuilder.ssrCompile(view.Path, view.RelPath, code)
+	case "dom":
+		return v.browserBuilder.browserCompile(view.Path, view.RelPath, code)
+	}
+
+	return nil, fmt.Errorf("unknown compile target %q, expected ssr or dom", target)
+}
+
+// FindViews returns the relative paths of all views whose file name is
+// shortName, sorted. The .svelte extension can be omitted
+func (v *ViewManager) FindViews(shortName string) []string {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	var paths []string
+	for relPath := range v.views {
+		fileName := filepath.Base(relPath)
+		if fileName == shortName || strings.TrimSuffix(fileName, filepath.Ext(fileName)) == shortName {
+			paths = append(paths, relPath)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// isRenderable reports whether the view at relPath matches the renderable views
+// allowlist
+func (v *ViewManager) isRenderable(relPath string) bool {
+	if len(v.renderableViews) == 0 {
+		return true
+	}
+
+	for _, pattern := range v.renderableViews {
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
 // AllViews returns all views
 func (v *ViewManager) AllViews() []*View {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
 	var views []*View
 	for _, view := range v.views {
 		views = append(views, view)
@@ -213,20 +918,41 @@ func (v *ViewManager) AllViews() []*View {
 }
 
 // StartWatch starts watching views directory for changes
+// Nothing is watched outside of dev mode
 func (v *ViewManager) StartWatch() error {
+	if v.watcher == nil {
+		return nil
+	}
+
 	//fsnotify doesn't currently support watching a directory recursively, so we must
 	//manually watch each child directory here
+	watchedDirs := map[string]bool{}
 	for _, dirPath := range v.tree.GetAllDescendantPaths() {
 		err := v.watcher.Add(dirPath)
 		if err != nil {
 			return err
 		}
+		watchedDirs[filepath.Clean(dirPath)] = true
+	}
+
+	if len(v.htmlTemplateFile) > 0 {
+		templateDir := filepath.Dir(v.htmlTemplateFile)
+		if !watchedDirs[templateDir] {
+			err := v.watcher.Add(templateDir)
+			if err != nil {
+				return err
+			}
+			v.watchesHTMLTemplateDir = true
+		}
 	}
 
+	//Close clears v.watcher. The loop ends once closing the batcher closes its
+	//errors channel
+	batcher := v.watcher
 	go func() {
 		for {
 			select {
-			case events, _ := <-v.watcher.Events:
+			case events, _ := <-batcher.Events:
 				err := v.handleEvents(events)
 				if err != nil {
 					v.logger.Error(
@@ -234,7 +960,7 @@ func (v *ViewManager) StartWatch() error {
 							err).Error(),
 					)
 				}
-			case err, ok := <-v.watcher.Errors():
+			case err, ok := <-batcher.Errors():
 				if !ok {
 					return
 				}
@@ -248,14 +974,44 @@ func (v *ViewManager) StartWatch() error {
 	return nil
 }
 
+// Close stops watching the views for changes. It's a no-op outside of dev mode
+func (v *ViewManager) Close() error {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.watcher == nil {
+		return nil
+	}
+	err := v.watcher.Close()
+	v.watcher = nil
+
+	return err
+}
+
 func (v *ViewManager) handleEvents(events []fsnotify.Event) error {
 	v.Lock()
 	defer v.Unlock()
 
+	//batches received before Close must not rebuild on the closing VMs
+	if v.watcher == nil {
+		return nil
+	}
+
 	numHandledEvents := 0
 	for _, e := range events {
 		//skip events on editor created temp files
-		if isTempFile(e.Name) || e.Name == "" {
+		if v.isTempFile(e.Name) || e.Name == "" {
+			continue
+		}
+
+		//the HTML template isn't part of the build
+		if v.isHTMLTemplateEvent(e) {
+			if e.Op&fsnotify.Remove != fsnotify.Remove && filepath.Clean(e.Name) == v.htmlTemplateFile {
+				err := v.loadHTMLTemplate()
+				if err != nil {
+					v.logger.Error(err.Error())
+				}
+			}
 			continue
 		}
 
@@ -293,8 +1049,7 @@ func (v *ViewManager) handleEvents(events []fsnotify.Event) error {
 	}
 
 	if numHandledEvents > 0 {
-		v.refreshViews()
-		err := v.Build()
+		err := v.build()
 		if err != nil {
 			return err
 		}
@@ -303,6 +1058,51 @@ func (v *ViewManager) handleEvents(events []fsnotify.Event) error {
 	return nil
 }
 
+// isHTMLTemplateEvent reports whether e is for the HTML template file or for
+// another file in its directory when that directory is only watched for it
+func (v *ViewManager) isHTMLTemplateEvent(e fsnotify.Event) bool {
+	if len(v.htmlTemplateFile) == 0 {
+		return false
+	}
+
+	name := filepath.Clean(e.Name)
+	if name == v.htmlTemplateFile {
+		return true
+	}
+
+	return v.watchesHTMLTemplateDir && filepath.Dir(name) == filepath.Dir(v.htmlTemplateFile)
+}
+
+// loadHTMLTemplate parses the HTML template file. The current template is kept
+// if parsing fails
+func (v *ViewManager) loadHTMLTemplate() error {
+	content, err := os.ReadFile(v.htmlTemplateFile)
+	if err != nil {
+		return fmt.Errorf("failed to read HTML template: %w", err)
+	}
+
+	htmlGenerator, err := template.New(filepath.Base(v.htmlTemplateFile)).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML template: %w", err)
+	}
+
+	//renders may be executing the current template
+	v.viewsLock.Lock()
+	v.htmlGenerator = htmlGenerator
+	v.viewsLock.Unlock()
+
+	return nil
+}
+
+// htmlTemplate returns the current HTML template. Templates are never modified
+// once parsed, so it can be executed without holding the lock
+func (v *ViewManager) htmlTemplate() *template.Template {
+	v.viewsLock.RLock()
+	defer v.viewsLock.RUnlock()
+
+	return v.htmlGenerator
+}
+
 func (v *ViewManager) handleRenameEvent(e fsnotify.Event) error {
 	err := v.ssrCache.Invalidate(e.Name)
 	if err != nil {
@@ -314,7 +1114,7 @@ func (v *ViewManager) handleRenameEvent(e fsnotify.Event) error {
 		return err
 	}
 
-	rescanPath := filepath.Base(e.Name)
+	rescanPath := e.Name
 
 	//rescan the parent dir for both file and dir removal
 	return v.tree.RescanDir(rescanPath)
@@ -325,6 +1125,11 @@ func (v *ViewManager) handleWriteEvent(e fsnotify.Event) error {
 
 	_ = v.browserCache.Invalidate(e.Name)
 
+	//metadata is read when scanning for components
+	if isComponentMetaFile(e.Name) {
+		return v.tree.RescanDir(e.Name)
+	}
+
 	return nil
 }
 
@@ -333,21 +1138,27 @@ func (v *ViewManager) handleRemoveEvent(e fsnotify.Event) error {
 
 	_ = v.browserCache.Invalidate(e.Name)
 
-	rescanPath := filepath.Base(e.Name)
+	rescanPath := e.Name
 
 	//rescan the parent dir for both file and dir removal
 	return v.tree.RescanDir(rescanPath)
 }
 
+// handleCreateEvent must be called with v locked, as Close clears v.watcher
 func (v *ViewManager) handleCreateEvent(e fsnotify.Event) error {
 	fileInfo, err := os.Stat(e.Name)
+	//the file may have been removed again within the same batch of events
+	if errors.Is(err, fs.ErrNotExist) {
+		return v.tree.RescanDir(e.Name)
+	}
 	if err != nil {
 		return err
 	}
 
-	rescanPath := filepath.Base(e.Name)
+	rescanPath := e.Name
 
-	if fileInfo.IsDir() {
+	//the watcher is gone once the view manager is closed
+	if fileInfo.IsDir() && v.watcher != nil {
 		// recursively add new directories to watch list
 		// When mkdir -p is used, only the top directory triggers an event (at least on OSX)
 		dirs, err := utils.RecursivelyGetAllChildDirs(e.Name)
@@ -367,6 +1178,31 @@ func (v *ViewManager) handleCreateEvent(e fsnotify.Event) error {
 	return v.tree.RescanDir(rescanPath)
 }
 
+// isTempFile checks the built-in editor temp file rules and the user provided
+// temp file patterns
+func (v *ViewManager) isTempFile(name string) bool {
+	if isTempFile(name) {
+		return true
+	}
+
+	baseName := filepath.Base(name)
+	for _, pattern := range v.tempFilePatterns {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			if strings.HasSuffix(baseName, pattern) {
+				return true
+			}
+			continue
+		}
+
+		isMatch, _ := filepath.Match(pattern, baseName)
+		if isMatch {
+			return true
+		}
+	}
+
+	return false
+}
+
 // from Hugo
 // https://github.com/gohugoio/hugo/blob/cbc35c48d252a1b44e4c30e26cfba2ff462a1f96/commands/hugo.go#L1039
 func isTempFile(name string) bool {
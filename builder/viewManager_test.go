@@ -0,0 +1,613 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mansoor-s/aviator/js"
+	"github.com/mansoor-s/aviator/watcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewManager_IsTempFile(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	v.tempFilePatterns = []string{"*.bak", ".orig", "~lock.*"}
+
+	assert.True(t, v.isTempFile("/views/index.svelte.swp"))
+	assert.True(t, v.isTempFile("/views/index.svelte.bak"))
+	assert.True(t, v.isTempFile("/views/index.svelte.orig"))
+	assert.True(t, v.isTempFile("/views/~lock.index.svelte#"))
+	assert.False(t, v.isTempFile("/views/index.svelte"))
+	assert.False(t, v.isTempFile("/views/backup/index.svelte"))
+
+	//events for ignored files must not trigger a rebuild
+	err := v.handleEvents([]fsnotify.Event{
+		{Name: "/views/index.svelte.bak", Op: fsnotify.Create},
+		{Name: "/views/index.svelte.orig", Op: fsnotify.Write},
+	})
+	assert.NoError(t, err)
+}
+
+// watchTestViewManager gives v the watcher it has in dev mode, so it handles
+// events until it's closed
+func watchTestViewManager(t *testing.T, v *ViewManager) {
+	var err error
+	v.watcher, err = watcher.New(eventBatchTime)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = v.Close() })
+}
+
+func TestViewManager_FindViews(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	for _, relPath := range []string{"products/Card.svelte", "users/Card.svelte", "users/CardList.svelte"} {
+		v.views[relPath] = &View{RelPath: relPath}
+	}
+
+	expected := []string{"products/Card.svelte", "users/Card.svelte"}
+	assert.Equal(t, expected, v.FindViews("Card.svelte"))
+	assert.Equal(t, expected, v.FindViews("Card"))
+	assert.Empty(t, v.FindViews("Missing"))
+}
+
+func TestNewViewManager_NonDevMode(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	evaluated := false
+	vm := &fakeVM{
+		initFn: func(_, _ string) error {
+			evaluated = true
+			return nil
+		},
+	}
+
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:   nopLogger{},
+		VM:       vm,
+		Tree:     tree,
+		CacheDir: t.TempDir(),
+		ViewsDir: viewsDir,
+	})
+	assert.NoError(t, err)
+
+	//the initial build still happens
+	assert.True(t, evaluated)
+
+	assert.Nil(t, v.watcher)
+	assert.NoError(t, v.StartWatch())
+
+	//and is never rebuilt
+	evaluated = false
+	assert.NoError(t, v.Build())
+	assert.False(t, evaluated)
+	assert.NoError(t, v.Close())
+
+	devV, err := NewViewManager(ViewManagerConfig{
+		Logger:    nopLogger{},
+		VM:        vm,
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, devV.watcher)
+	assert.NoError(t, devV.StartWatch())
+
+	evaluated = false
+	assert.NoError(t, devV.Build())
+	assert.True(t, evaluated)
+
+	assert.NoError(t, devV.Close())
+	assert.Nil(t, devV.watcher)
+}
+
+func TestNewViewManager_OutputPath(t *testing.T) {
+	viewsDir := t.TempDir()
+	outputPath := filepath.Join(t.TempDir(), "public")
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:     nopLogger{},
+		VM:         newStaticRenderVM(""),
+		Tree:       tree,
+		CacheDir:   t.TempDir(),
+		ViewsDir:   viewsDir,
+		OutputPath: outputPath,
+		StaticAssets: fstest.MapFS{
+			"Index.svelte.fr.js": {Data: []byte(`"bonjour"`)},
+			"fonts/Inter.woff2":  {Data: []byte(`font`)},
+		},
+	})
+	assert.NoError(t, err)
+
+	for name, asset := range v.staticContent {
+		content, err := os.ReadFile(filepath.Join(outputPath, filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, asset.Content, content)
+	}
+	assert.FileExists(t, filepath.Join(outputPath, "fonts", "Inter.woff2"))
+}
+
+func TestViewManager_HTMLTemplateFile(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	templateFile := filepath.Join(t.TempDir(), "document.html")
+	assert.NoError(t, os.WriteFile(templateFile, []byte(`<main>{{.Body}}</main>`), 0644))
+
+	v.htmlTemplateFile = templateFile
+	assert.NoError(t, v.loadHTMLTemplate())
+	watchTestViewManager(t, v)
+
+	out, err := v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "<main><h1>Hello</h1></main>", out)
+
+	assert.NoError(t, os.WriteFile(templateFile, []byte(`<article>{{.Body}}</article>`), 0644))
+	err = v.handleEvents([]fsnotify.Event{{Name: templateFile, Op: fsnotify.Write}})
+	assert.NoError(t, err)
+
+	out, err = v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "<article><h1>Hello</h1></article>", out)
+
+	//a broken template keeps the last good one
+	assert.NoError(t, os.WriteFile(templateFile, []byte(`<article>{{.Body</article>`), 0644))
+	err = v.handleEvents([]fsnotify.Event{{Name: templateFile, Op: fsnotify.Write}})
+	assert.NoError(t, err)
+
+	out, err = v.Render(context.Background(), "Index.svelte", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "<article><h1>Hello</h1></article>", out)
+}
+
+func TestViewManager_HTMLTemplateFile_ConcurrentReload(t *testing.T) {
+	v, _ := newTestViewManager(newStaticRenderVM(`{"body":"<h1>Hello</h1>"}`))
+	templateFile := filepath.Join(t.TempDir(), "document.html")
+	assert.NoError(t, os.WriteFile(templateFile, []byte(`<main>{{.Body}}</main>`), 0644))
+	v.htmlTemplateFile = templateFile
+	watchTestViewManager(t, v)
+
+	done := make(chan struct{})
+	var renders int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				out, err := v.Render(context.Background(), "Index.svelte", nil)
+				assert.NoError(t, err)
+				//either template, never a mix
+				assert.Contains(t, []string{"<main><h1>Hello</h1></main>", "<article><h1>Hello</h1></article>"}, out)
+				atomic.AddInt64(&renders, 1)
+			}
+		}()
+	}
+
+	//keep reloading until renders ran alongside the reloads
+	for i := 0; i < 50 || atomic.LoadInt64(&renders) < 200; i++ {
+		content := `<main>{{.Body}}</main>`
+		if i%2 == 1 {
+			content = `<article>{{.Body}}</article>`
+		}
+		assert.NoError(t, os.WriteFile(templateFile, []byte(content), 0644))
+		assert.NoError(t, v.handleEvents([]fsnotify.Event{{Name: templateFile, Op: fsnotify.Write}}))
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestViewManager_HandleEvents_Subdirectory(t *testing.T) {
+	root := t.TempDir()
+	subDir := filepath.Join(root, "catalog")
+	assert.NoError(t, os.Mkdir(subDir, os.ModePerm))
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	v.tree = tree
+	v.ssrCache, err = newNopCache()
+	assert.NoError(t, err)
+	v.browserCache, err = newNopCache()
+	assert.NoError(t, err)
+
+	catsPath := filepath.Join(subDir, "cats.svelte")
+	assert.NoError(t, os.WriteFile(catsPath, nil, 0644))
+	assert.NoError(t, v.handleCreateEvent(fsnotify.Event{Name: catsPath, Op: fsnotify.Create}))
+	assert.Contains(t, tree.Children[subDir].Components, "cats")
+
+	dogsPath := filepath.Join(subDir, "dogs.svelte")
+	assert.NoError(t, os.Rename(catsPath, dogsPath))
+	assert.NoError(t, v.handleRenameEvent(fsnotify.Event{Name: catsPath, Op: fsnotify.Rename}))
+	assert.NotContains(t, tree.Children[subDir].Components, "cats")
+	assert.Contains(t, tree.Children[subDir].Components, "dogs")
+
+	assert.NoError(t, os.Remove(dogsPath))
+	assert.NoError(t, v.handleRemoveEvent(fsnotify.Event{Name: dogsPath, Op: fsnotify.Remove}))
+	assert.Empty(t, tree.Children[subDir].Components)
+
+	//a file created and removed within the same batch is merged into a Create|Remove event
+	assert.NoError(t, v.handleCreateEvent(fsnotify.Event{Name: catsPath, Op: fsnotify.Create | fsnotify.Remove}))
+	assert.Empty(t, tree.Children[subDir].Components)
+
+	//a directory created after Close is still scanned, just not watched
+	assert.Nil(t, v.watcher)
+	newDir := filepath.Join(subDir, "birds")
+	assert.NoError(t, os.MkdirAll(filepath.Join(newDir, "owls"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(newDir, "owls", "barn.svelte"), nil, 0644))
+	assert.NoError(t, v.handleCreateEvent(fsnotify.Event{Name: newDir, Op: fsnotify.Create}))
+	assert.Contains(t, tree.Children[subDir].Children[newDir].Children[filepath.Join(newDir, "owls")].Components, "barn")
+}
+
+func TestViewManager_NewViews_MissingLayout(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "users"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "+layout.svelte"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "users", "list.svelte"), nil, 0644))
+	tree, err := CreateComponentTree(root)
+	assert.NoError(t, err)
+
+	v, _ := newTestViewManager(newStaticRenderVM(`{}`))
+	v.tree = tree
+
+	//a component still pointing at a layout that's gone from the tree isn't wrapped by it
+	delete(tree.Layouts, "+layout")
+	views := v.newViews()
+	view := views[filepath.Join("users", "list.svelte")]
+	assert.NotNil(t, view)
+	assert.Empty(t, view.ApplicableLayoutViews)
+}
+
+func TestViewManager_HandleEvents_ConcurrentReads(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:    nopLogger{},
+		VM:        newStaticRenderVM(`{}`),
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+		StaticAssets: fstest.MapFS{
+			"robots.txt": {Data: []byte("User-agent: *")},
+		},
+	})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, found := v.GetStaticAsset("robots.txt")
+			assert.True(t, found)
+			v.AllViews()
+			v.FindViews("Index")
+		}
+	}()
+
+	//every handled change rebuilds and swaps the views and assets while they're read
+	notesPath := filepath.Join(viewsDir, "notes.txt")
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, os.WriteFile(notesPath, []byte(fmt.Sprint(i)), 0644))
+		assert.NoError(t, v.handleEvents([]fsnotify.Event{{Name: notesPath, Op: fsnotify.Write}}))
+	}
+	close(done)
+	<-readerDone
+}
+
+func TestViewManager_Build_FailedRebuild(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	var evalErr error
+	vm := &fakeVM{
+		initFn: func(_, _ string) error {
+			return evalErr
+		},
+	}
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:    nopLogger{},
+		VM:        vm,
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+		StaticAssets: fstest.MapFS{
+			"robots.txt": {Data: []byte("v1")},
+		},
+	})
+	assert.NoError(t, err)
+
+	//the rebuild with the changed asset fails evaluating the SSR bundle
+	v.userStaticContent["robots.txt"] = StaticAsset{Content: []byte("v2"), MimeType: "text/plain"}
+	evalErr = errors.New("SyntaxError: Unexpected token")
+	assert.Error(t, v.Build())
+
+	asset, found := v.GetStaticAsset("robots.txt")
+	assert.True(t, found)
+	assert.Equal(t, "v1", string(asset.Content))
+
+	//fixing it applies the new build
+	evalErr = nil
+	assert.NoError(t, v.Build())
+
+	asset, found = v.GetStaticAsset("robots.txt")
+	assert.True(t, found)
+	assert.Equal(t, "v2", string(asset.Content))
+}
+
+func TestViewManager_SwapBuild_PartialEvalFailure(t *testing.T) {
+	vm, err := js.NewVMPool(js.EngineGoja, 3, js.VMOptions{Logger: nopLogger{}})
+	assert.NoError(t, err)
+
+	//the new bundle throws on the second VM it's evaluated on
+	var evalCount int32
+	assert.NoError(t, vm.SetGlobal("evalCount", func() int32 {
+		return atomic.AddInt32(&evalCount, 1)
+	}))
+
+	v := newViewManager(ViewManagerConfig{VM: vm, Logger: nopLogger{}})
+	assert.NoError(t, v.swapBuild(map[string]*View{}, map[string]StaticAsset{}, []byte(`var __aviator__ = { version: "v1" };`)))
+
+	err = v.swapBuild(map[string]*View{}, map[string]StaticAsset{}, []byte(`
+		if (evalCount() === 2) {
+			throw new Error("bundle failed")
+		}
+		var __aviator__ = { version: "v2" };
+	`))
+	assert.ErrorContains(t, err, "bundle failed")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&evalCount))
+	assert.Contains(t, string(v.ssrBundle), "v1")
+
+	//every VM is back on the last good bundle
+	assert.NoError(t, vm.InitializationScript("check.js", `
+		if (__aviator__.version !== "v1") {
+			throw new Error("stale bundle " + __aviator__.version)
+		}
+	`))
+}
+
+func TestViewManager_BuildSSR_BuildBrowser(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	evaluations := 0
+	vm := &fakeVM{
+		initFn: func(_, _ string) error {
+			evaluations++
+			return nil
+		},
+	}
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:    nopLogger{},
+		VM:        vm,
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+		StaticAssets: fstest.MapFS{
+			"robots.txt": {Data: []byte("v1")},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, evaluations)
+	v.userStaticContent["robots.txt"] = StaticAsset{Content: []byte("v2"), MimeType: "text/plain"}
+
+	//the SSR bundle is evaluated again, the browser assets are kept
+	v.ssrBundle = nil
+	assert.NoError(t, v.BuildSSR())
+	assert.Equal(t, 2, evaluations)
+	assert.NotEmpty(t, v.ssrBundle)
+	asset, found := v.GetStaticAsset("robots.txt")
+	assert.True(t, found)
+	assert.Equal(t, "v1", string(asset.Content))
+
+	//the browser assets are rebuilt, the SSR bundle is kept
+	bundle := v.ssrBundle
+	assert.NoError(t, v.BuildBrowser())
+	assert.Equal(t, 2, evaluations)
+	assert.Equal(t, bundle, v.ssrBundle)
+	asset, found = v.GetStaticAsset("robots.txt")
+	assert.True(t, found)
+	assert.Equal(t, "v2", string(asset.Content))
+}
+
+func TestViewManager_Build_ConcurrentWithEvents(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger:    nopLogger{},
+		VM:        &fakeVM{},
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+	})
+	assert.NoError(t, err)
+
+	//manual builds run alongside the rebuilds of the watcher
+	var wg sync.WaitGroup
+	for _, build := range []func() error{v.Build, v.BuildSSR, v.BuildBrowser} {
+		wg.Add(1)
+		go func(build func() error) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, build())
+			}
+		}(build)
+	}
+	for i := 0; i < 5; i++ {
+		dir := filepath.Join(viewsDir, fmt.Sprintf("dir%d", i))
+		assert.NoError(t, os.Mkdir(dir, os.ModePerm))
+		assert.NoError(t, v.handleEvents([]fsnotify.Event{{Name: dir, Op: fsnotify.Create}}))
+	}
+	wg.Wait()
+	assert.True(t, v.hasBuilt)
+}
+
+func TestViewManager_HandleEvents_AfterClose(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	var builds int32
+	v, err := NewViewManager(ViewManagerConfig{
+		Logger: nopLogger{},
+		VM: &fakeVM{
+			initFn: func(_, _ string) error {
+				atomic.AddInt32(&builds, 1)
+				return nil
+			},
+		},
+		Tree:      tree,
+		CacheDir:  t.TempDir(),
+		ViewsDir:  viewsDir,
+		IsDevMode: true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, v.Close())
+	initialBuilds := atomic.LoadInt32(&builds)
+
+	//a batch received before Close doesn't rebuild on the closed VMs
+	dir := filepath.Join(viewsDir, "users")
+	assert.NoError(t, os.Mkdir(dir, os.ModePerm))
+	assert.NoError(t, v.handleEvents([]fsnotify.Event{{Name: dir, Op: fsnotify.Create}}))
+	assert.Equal(t, initialBuilds, atomic.LoadInt32(&builds))
+}
+
+func TestNewViewManager_CacheMode(t *testing.T) {
+	viewsDir := t.TempDir()
+	tree, err := CreateComponentTree(viewsDir)
+	assert.NoError(t, err)
+
+	for _, mode := range []CacheMode{CacheModeMemory, CacheModeDisabled} {
+		cacheDir := filepath.Join(t.TempDir(), "cache")
+		v, err := NewViewManager(ViewManagerConfig{
+			Logger:    nopLogger{},
+			VM:        newStaticRenderVM(`{}`),
+			Tree:      tree,
+			CacheDir:  cacheDir,
+			CacheMode: mode,
+			ViewsDir:  viewsDir,
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, v)
+
+		//nothing is written to disk
+		assert.NoDirExists(t, cacheDir)
+	}
+}
+
+func TestViewManager_CompileView(t *testing.T) {
+	compilerCode, err := os.ReadFile("../embedded_assets/svelte_compiler.js")
+	assert.NoError(t, err)
+	viewPath, err := filepath.Abs("test_data/views/index.svelte")
+	assert.NoError(t, err)
+
+	vm := newGojaTestVM(t, string(compilerCode))
+	cache, err := newNopCache()
+	assert.NoError(t, err)
+	v, _ := newTestViewManager(vm)
+	v.ssrBuilder = NewSSRBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	v.browserBuilder = NewBrowserBuilder(nopLogger{}, vm, cache, "test_data", nil, BuildOptions{})
+	v.views["index.svelte"] = &View{Path: viewPath, RelPath: "index.svelte"}
+
+	ssr, err := v.CompileView("index.svelte", "ssr")
+	assert.NoError(t, err)
+	assert.Contains(t, ssr.JSCode, "create_ssr_component")
+
+	dom, err := v.CompileView("index.svelte", "dom")
+	assert.NoError(t, err)
+	assert.NotContains(t, dom.JSCode, "create_ssr_component")
+	assert.Contains(t, dom.JSCode, "SvelteComponent")
+	assert.Contains(t, dom.CSSCode, "color:red")
+
+	_, err = v.CompileView("index.svelte", "native")
+	assert.Error(t, err)
+	_, err = v.CompileView("missing.svelte", "ssr")
+	assert.Error(t, err)
+}
+
+func TestViewManager_ExplicitEntrypoints(t *testing.T) {
+	views := fstest.MapFS{
+		"+layout.svelte":        {Data: []byte(`<slot></slot>`)},
+		"+error.svelte":         {Data: []byte(`<h1>Error</h1>`)},
+		"Index.svelte":          {Data: []byte(`<h1>Home</h1>`)},
+		"Card.svelte":           {Data: []byte(`<div></div>`)},
+		"widget.svelte":         {Data: []byte(`<div></div>`)},
+		"widget.meta.json":      {Data: []byte(`{"entrypoint": true}`)},
+		"admin/Settings.svelte": {Data: []byte(`<h1>Settings</h1>`)},
+	}
+	root := filepath.Join(t.TempDir(), "views")
+	tree, err := CreateComponentTree(root, WithFS(views))
+	assert.NoError(t, err)
+
+	entrypoints := func(v *ViewManager) []string {
+		var relPaths []string
+		for relPath, view := range v.newViews() {
+			if view.IsEntrypoint {
+				relPaths = append(relPaths, relPath)
+			}
+		}
+		return relPaths
+	}
+
+	v := newViewManager(ViewManagerConfig{Logger: nopLogger{}, ExplicitEntrypoints: true})
+	v.tree = tree
+	//only views declared in their metadata, capitalized names don't count
+	assert.ElementsMatch(t, []string{"widget.svelte", "+error.svelte"}, entrypoints(v))
+
+	v.RegisterEntrypoint("admin/Settings.svelte")
+	assert.ElementsMatch(t, []string{
+		"widget.svelte",
+		"+error.svelte",
+		filepath.Join("admin", "Settings.svelte"),
+	}, entrypoints(v))
+
+	v = newViewManager(ViewManagerConfig{
+		Logger:              nopLogger{},
+		ExplicitEntrypoints: true,
+		Entrypoints:         []string{"Index.svelte"},
+	})
+	v.tree = tree
+	assert.ElementsMatch(t, []string{"widget.svelte", "+error.svelte", "Index.svelte"}, entrypoints(v))
+
+	//the file names decide without the option
+	v = newViewManager(ViewManagerConfig{Logger: nopLogger{}})
+	v.tree = tree
+	v.RegisterEntrypoint("admin/Settings.svelte")
+	assert.ElementsMatch(t, []string{
+		"Index.svelte",
+		"Card.svelte",
+		"widget.svelte",
+		"+error.svelte",
+		filepath.Join("admin", "Settings.svelte"),
+	}, entrypoints(v))
+}
@@ -0,0 +1,64 @@
+package builder
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// viewsFS reads view files by their OS path. When fsys is set, paths inside root
+// are read from fsys relative to root. Everything else, i.e. node_modules
+// outside the views directory, is read from the OS filesystem. The zero value
+// reads everything from the OS
+type viewsFS struct {
+	//root is the views directory fsys is mounted at
+	root string
+	fsys fs.FS
+}
+
+// IsVirtual reports whether views are read from an fs.FS instead of the OS
+func (v viewsFS) IsVirtual() bool {
+	return v.fsys != nil
+}
+
+// name returns the fsys name of path, or false if path should be read from the OS
+func (v viewsFS) name(path string) (string, bool) {
+	if v.fsys == nil {
+		return "", false
+	}
+
+	absRoot, err := filepath.Abs(v.root)
+	if err != nil {
+		return "", false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	relPath, err := filepath.Rel(absRoot, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(relPath), true
+}
+
+func (v viewsFS) ReadFile(path string) ([]byte, error) {
+	name, ok := v.name(path)
+	if !ok {
+		return os.ReadFile(path)
+	}
+
+	return fs.ReadFile(v.fsys, name)
+}
+
+func (v viewsFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	name, ok := v.name(path)
+	if !ok {
+		return os.ReadDir(path)
+	}
+
+	return fs.ReadDir(v.fsys, name)
+}
@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewsFS(t *testing.T) {
+	outsideDir := t.TempDir()
+	outsideFile := filepath.Join(outsideDir, "runtime.js")
+	assert.NoError(t, os.WriteFile(outsideFile, []byte(`export default 1`), 0644))
+
+	root := filepath.Join(outsideDir, "views")
+	files := viewsFS{
+		root: root,
+		fsys: fstest.MapFS{
+			"index.svelte":        {Data: []byte(`<h1>Home</h1>`)},
+			"catalog/cats.svelte": {Data: []byte(`<h1>Cats</h1>`)},
+		},
+	}
+	assert.True(t, files.IsVirtual())
+
+	content, err := files.ReadFile(filepath.Join(root, "catalog", "cats.svelte"))
+	assert.NoError(t, err)
+	assert.Equal(t, `<h1>Cats</h1>`, string(content))
+
+	entries, err := files.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = files.ReadFile(filepath.Join(root, "missing.svelte"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	//paths outside of the views directory are read from the OS
+	content, err = files.ReadFile(outsideFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `export default 1`, string(content))
+
+	var osFiles viewsFS
+	assert.False(t, osFiles.IsVirtual())
+	content, err = osFiles.ReadFile(outsideFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `export default 1`, string(content))
+}
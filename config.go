@@ -2,12 +2,15 @@ package aviator
 
 import (
 	"fmt"
+	"io/fs"
+	"sync"
+	"text/template"
+	"time"
+
 	"github.com/fsnotify/fsnotify"
 	"github.com/mansoor-s/aviator/builder"
 	"github.com/mansoor-s/aviator/js"
 	"github.com/mansoor-s/aviator/utils"
-	"sync"
-	"text/template"
 )
 
 type Option func(config *Aviator)
@@ -25,19 +28,73 @@ type Aviator struct {
 
 	assetListenPath  string
 	staticAssetRoute string
+	jsRoute          string
+	cssRoute         string
 
 	htmlGenerator *template.Template
 
-	isDevMode bool
-	numVMs    int
-	htmlLang  string
+	isDevMode        bool
+	numVMs           int
+	vmAcquireTimeout time.Duration
+	jsEngine         js.Engine
+	fetch            js.FetchOptions
+	polyfills        []js.GojaPolyfill
+	htmlLang         string
 
+	//isInitialized is guarded by viewLock
 	isInitialized bool
 
 	viewsPath  string
 	outputPath string
 	cacheDir   string
 
+	serviceWorkerScope string
+	maxScanDepth       int
+	ignorePatterns     []string
+	useImportMap       bool
+	cacheKeyHash       builder.HashFunc
+	propsEncoder       func(props interface{}) ([]byte, error)
+	propsKeyCase       builder.PropsKeyCase
+	propsKeyCaseNested bool
+	propsReviver       string
+	storeInitializer   string
+	embeddedApp        fs.FS
+	tempFilePatterns   []string
+	initBuildRetries   int
+	renderableViews    []string
+	externalResolver   builder.ExternalResolver
+	stylePreprocessor  builder.StylePreprocessor
+	importAliases      map[string]string
+	cacheNamespace     string
+	cacheMode          builder.CacheMode
+	cacheBackend       builder.CacheBackend
+	assetLayout        builder.AssetLayout
+	hydratable         []builder.HydratableRule
+	cssMedia           []builder.CSSMediaRule
+	viewConcurrency    []builder.ViewConcurrencyRule
+	cacheVary          []builder.CacheVaryRule
+	ssrBridges         map[string]func(args ...interface{}) (interface{}, error)
+	buildInfo          map[string]string
+	immutable          bool
+	assetHeaders       func(name string) map[string]string
+	staticAssets       fs.FS
+	htmlTemplateFile   string
+	viewsFS            fs.FS
+	failOnA11yWarnings bool
+	prettyHTML         bool
+	compactPropsScript bool
+	omitNilPropsScript bool
+	isolateLayoutProps bool
+	deterministicSSR   bool
+	ssrSeed            int64
+
+	//explicitEntrypoints only makes the views in entrypoints entrypoints
+	explicitEntrypoints bool
+	entrypoints         []string
+
+	//skipNodeModulesCache is negated so node_modules are cached by default
+	skipNodeModulesCache bool
+
 	// TODO: optimize by removing this lock for non-dev environment
 	viewLock sync.RWMutex
 
@@ -58,12 +115,99 @@ func WithNumJsVMs(numVMs int) Option {
 	}
 }
 
+// WithVMAcquireTimeout sets how long a render waits for one of the JS VMs when
+// all of them are busy. Renders then fail fast with ErrVMPoolExhausted, which is
+// also logged, instead of queueing indefinitely. Renders wait until their
+// context is done by default
+func WithVMAcquireTimeout(timeout time.Duration) Option {
+	return func(a *Aviator) {
+		a.vmAcquireTimeout = timeout
+	}
+}
+
+// WithJSEngine selects the JS engine server side rendering runs on. Defaults to
+// goja. V8 is faster for SSR heavy workloads but needs CGO and the binary must be
+// built with the v8 build tag, Init fails otherwise
+func WithJSEngine(engine JSEngine) Option {
+	return func(a *Aviator) {
+		a.jsEngine = engine
+	}
+}
+
+// WithFetch sets whether components can use fetch during SSR, false by default.
+// Requests are sent with net/http from the server and block the render until
+// they're done. Any component can then make the server send requests, including
+// to internal addresses it can reach and the client can't. Restrict them with
+// WithFetchAllowedHosts when URLs can come from user input. Only applies to goja
+func WithFetch(enabled bool) Option {
+	return func(a *Aviator) {
+		a.fetch.Enabled = enabled
+	}
+}
+
+// WithFetchTimeout sets the timeout of each fetch request made during SSR,
+// 10 seconds by default
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(a *Aviator) {
+		a.fetch.Timeout = timeout
+	}
+}
+
+// WithFetchAllowedHosts restricts fetch requests made during SSR to hosts, i.e.
+// "api.example.com" or "localhost:8080". Every host is allowed by default
+func WithFetchAllowedHosts(hosts ...string) Option {
+	return func(a *Aviator) {
+		a.fetch.AllowedHosts = append(a.fetch.AllowedHosts, hosts...)
+	}
+}
+
+// WithGojaPolyfill installs additional globals on every goja VM, after the
+// built-in TextEncoder, TextDecoder, setTimeout and fetch, which it can replace.
+// It's ignored by the V8 engine
+func WithGojaPolyfill(polyfill GojaPolyfill) Option {
+	return func(a *Aviator) {
+		a.polyfills = append(a.polyfills, polyfill)
+	}
+}
+
 func WithViewsPath(path string) Option {
 	return func(a *Aviator) {
 		a.viewsPath = path
 	}
 }
 
+// WithViewsFS reads the views from fsys, i.e. an embed.FS, instead of the views
+// path. fsys is treated as the contents of the views path, which defaults to the
+// working directory and is still where bare imports like svelte are resolved
+// from. Views aren't watched in dev mode
+func WithViewsFS(fsys fs.FS) Option {
+	return func(a *Aviator) {
+		a.viewsFS = fsys
+	}
+}
+
+// WithMaxScanDepth limits how many directory levels below the views path are
+// scanned for components. Directories past the limit are skipped with a warning.
+// 0 means no limit
+func WithMaxScanDepth(depth int) Option {
+	return func(a *Aviator) {
+		a.maxScanDepth = depth
+	}
+}
+
+// WithIgnore excludes the files and directories of the views directory
+// matching gitignore style patterns from scanning, so they're never views, i.e.
+// "*.stories.svelte" or "__tests__/". Patterns in a .aviatorignore file at the
+// root of the views directory apply as well. It's read on Init
+func WithIgnore(patterns ...string) Option {
+	return func(a *Aviator) {
+		a.ignorePatterns = append(a.ignorePatterns, patterns...)
+	}
+}
+
+// WithAssetOutputPath writes the static assets to path after every build so
+// they can be served by something other than Aviator, i.e. a CDN or a reverse
+// proxy. Asset file names match the names used in the rendered pages
 func WithAssetOutputPath(path string) Option {
 	return func(a *Aviator) {
 		a.outputPath = path
@@ -76,12 +220,411 @@ func WithStaticAssetRoute(route string) Option {
 	}
 }
 
+// WithJSRoute sets the route or URL prefix of the JS assets in the rendered
+// pages, i.e. a CDN serving them from a different host. Defaults to the static
+// asset route
+func WithJSRoute(route string) Option {
+	return func(a *Aviator) {
+		a.jsRoute = route
+	}
+}
+
+// WithCSSRoute sets the route or URL prefix of the CSS assets in the rendered
+// pages. Defaults to the static asset route
+func WithCSSRoute(route string) Option {
+	return func(a *Aviator) {
+		a.cssRoute = route
+	}
+}
+
 func WithHTMLLang(lang string) Option {
 	return func(a *Aviator) {
 		a.htmlLang = lang
 	}
 }
 
+// WithServiceWorker generates a sw.js static asset that precaches all built
+// assets and registers it on every rendered page with the given scope. It's
+// served under the JS route. A scope broader than the JS route requires the
+// asset handler to send the Service-Worker-Allowed header
+func WithServiceWorker(scope string) Option {
+	return func(a *Aviator) {
+		a.serviceWorkerScope = scope
+	}
+}
+
+// WithImportMap emits a <script type="importmap"> for the view's JS assets and a
+// single module script that imports them instead of one script tag per asset
+func WithImportMap(useImportMap bool) Option {
+	return func(a *Aviator) {
+		a.useImportMap = useImportMap
+	}
+}
+
+// WithCacheKeyHash replaces the hash function used to compute asset versions and
+// cache keys, i.e. to match the algorithm used by an external cache layer
+func WithCacheKeyHash(hash func(content []byte) string) Option {
+	return func(a *Aviator) {
+		a.cacheKeyHash = hash
+	}
+}
+
+// WithPropsKeyCase transforms the keys of the props, i.e. Go's PascalCase field
+// names, to keyCase when they're serialized for both SSR and hydration. Only the
+// top level keys are transformed unless nested is true. Defaults to
+// PropsKeyCaseAsIs
+func WithPropsKeyCase(keyCase PropsKeyCase, nested bool) Option {
+	return func(a *Aviator) {
+		a.propsKeyCase = keyCase
+		a.propsKeyCaseNested = nested
+	}
+}
+
+// WithPropsEncoder replaces json.Marshal for serializing props. It's the Go half
+// of the encoder/reviver pair, see WithPropsReviver
+func WithPropsEncoder(encoder func(props interface{}) ([]byte, error)) Option {
+	return func(a *Aviator) {
+		a.propsEncoder = encoder
+	}
+}
+
+// WithPropsReviver sets a JS function expression used as the JSON.parse reviver
+// for props, i.e. `function(key, value) { ... }`.
+//
+// Props are serialized once in Go by the props encoder and parsed with the
+// reviver both before SSR and before hydration in the browser, so both sides
+// see the same types. Any value the encoder writes in a special form (i.e. a
+// time.Time as an RFC 3339 string) must be turned back into its JS type
+// (a Date) by the reviver
+func WithPropsReviver(reviver string) Option {
+	return func(a *Aviator) {
+		a.propsReviver = reviver
+	}
+}
+
+// WithStoreInitializer seeds svelte stores with server provided values so they
+// render the same on the server and during hydration. initializer is the source
+// of a JS module, resolved relative to the views directory, whose default export
+// is called with the render context (see RenderContext) before every SSR render
+// and before hydration in the browser:
+//
+//	import { user } from "./stores.js"
+//	export default function(context) {
+//		user.set(context.user)
+//	}
+func WithStoreInitializer(initializer string) Option {
+	return func(a *Aviator) {
+		a.storeInitializer = initializer
+	}
+}
+
+// WithEmbeddedApp renders from an app written by Aviator.Export instead of
+// building the views directory, i.e. from an embed.FS. The Svelte compiler and
+// esbuild aren't used and nothing is watched
+func WithEmbeddedApp(fsys fs.FS) Option {
+	return func(a *Aviator) {
+		a.embeddedApp = fsys
+	}
+}
+
+// WithTempFilePatterns ignores changes to files whose name matches one of the
+// patterns in addition to the built-in editor temp files. Patterns are
+// filepath.Match globs, i.e. "*.bak". Patterns without wildcards match as
+// a suffix, i.e. ".orig"
+func WithTempFilePatterns(patterns ...string) Option {
+	return func(a *Aviator) {
+		a.tempFilePatterns = append(a.tempFilePatterns, patterns...)
+	}
+}
+
+// WithInitBuildRetries retries the build done by Init up to n times when it fails
+// because a file couldn't be read, i.e. it was being written during startup.
+// Compile errors fail Init right away
+func WithInitBuildRetries(n int) Option {
+	return func(a *Aviator) {
+		a.initBuildRetries = n
+	}
+}
+
+// WithRenderableViews only allows rendering views whose path relative to the views
+// directory matches one of the globs, i.e. "pages/*.svelte". Rendering any other
+// view fails with ErrViewNotRenderable. All views can be rendered by default
+func WithRenderableViews(globs ...string) Option {
+	return func(a *Aviator) {
+		a.renderableViews = append(a.renderableViews, globs...)
+	}
+}
+
+// WithExternalResolver is consulted for every bare import in the views, i.e.
+// import confetti from "canvas-confetti". Imports it marks as external aren't
+// bundled and are loaded from the returned URL by the browser, which allows
+// using CDN hosted packages. External packages aren't available during SSR and
+// should only be used by browser-only code such as onMount
+func WithExternalResolver(resolver func(importPath string) (url string, external bool)) Option {
+	return func(a *Aviator) {
+		a.externalResolver = resolver
+	}
+}
+
+// WithStylePreprocessor transforms the <style> blocks of the components before
+// they're compiled, i.e. to compile <style lang="scss"> with Sass or run PostCSS.
+// The preprocessor must return CSS. ESBuildStylePreprocessor lowers plain CSS
+// for older browsers. Components with styles in other languages fail to build
+// without one
+func WithStylePreprocessor(preprocessor StylePreprocessor) Option {
+	return func(a *Aviator) {
+		a.stylePreprocessor = preprocessor
+	}
+}
+
+// WithImportAlias resolves imports starting with one of the aliases to the path
+// it maps to, i.e. {"$lib": "lib"} resolves $lib/Button.svelte to
+// lib/Button.svelte. Relative paths are relative to the views directory. When
+// aliases overlap, the longest one wins
+func WithImportAlias(aliases map[string]string) Option {
+	return func(a *Aviator) {
+		if a.importAliases == nil {
+			a.importAliases = map[string]string{}
+		}
+		for alias, target := range aliases {
+			a.importAliases[alias] = target
+		}
+	}
+}
+
+// WithAssetLayout sets the directory structure of the built JS and CSS assets,
+// which is reflected in their names and URLs. Defaults to AssetLayoutFlat
+func WithAssetLayout(layout AssetLayout) Option {
+	return func(a *Aviator) {
+		a.assetLayout = layout
+	}
+}
+
+// WithCacheDir sets the directory compiled components are cached in, which is
+// created if it doesn't exist. Defaults to .aviator_cache in the working
+// directory. Instances sharing a directory must use distinct namespaces, see
+// WithCacheNamespace
+func WithCacheDir(path string) Option {
+	return func(a *Aviator) {
+		a.cacheDir = path
+	}
+}
+
+// WithCacheBackend shares compiled components through backend, i.e. between CI
+// machines. Components missing from the local cache are looked up in it and
+// newly compiled ones are stored in it. backend errors are logged, not fatal
+func WithCacheBackend(backend CacheBackend) Option {
+	return func(a *Aviator) {
+		a.cacheBackend = backend
+	}
+}
+
+// WithCacheMode sets where compiled components are cached. CacheModeMemory suits
+// ephemeral environments, i.e. CI, or a read-only filesystem. Defaults to
+// CacheModeDisk
+func WithCacheMode(mode CacheMode) Option {
+	return func(a *Aviator) {
+		a.cacheMode = mode
+	}
+}
+
+// WithCacheNamespace stores the build caches in a subdirectory of the cache
+// directory. Instances sharing a cache directory need distinct namespaces so
+// their caches don't collide
+func WithCacheNamespace(namespace string) Option {
+	return func(a *Aviator) {
+		a.cacheNamespace = namespace
+	}
+}
+
+// WithHydratable sets whether the components whose path relative to the views
+// directory matches viewGlob are compiled with hydration support. Views compiled
+// without it are mounted from scratch in the browser instead of hydrating the
+// server rendered HTML, which is lighter for mostly static views. When several
+// globs match a component, the one added last wins
+func WithHydratable(viewGlob string, hydratable bool) Option {
+	return func(a *Aviator) {
+		a.hydratable = append(a.hydratable, builder.HydratableRule{
+			Glob:       viewGlob,
+			Hydratable: hydratable,
+		})
+	}
+}
+
+// WithCSSMedia adds a media attribute to the link tags of the CSS assets whose
+// name matches assetGlob, i.e. WithCSSMedia("*Print*.css", "print"). Browsers
+// don't block rendering on stylesheets whose media doesn't apply
+func WithCSSMedia(assetGlob string, media string) Option {
+	return func(a *Aviator) {
+		a.cssMedia = append(a.cssMedia, builder.CSSMediaRule{
+			Glob:  assetGlob,
+			Media: media,
+		})
+	}
+}
+
+// WithViewConcurrencyLimit allows at most n concurrent renders of each view whose
+// path matches viewGlob, so expensive views can't take up the whole VM pool.
+// Renders over the limit wait until their context is done and then fail with
+// ErrViewBusy. The last matching limit applies
+func WithViewConcurrencyLimit(viewGlob string, n int) Option {
+	return func(a *Aviator) {
+		a.viewConcurrency = append(a.viewConcurrency, builder.ViewConcurrencyRule{
+			Glob:  viewGlob,
+			Limit: n,
+		})
+	}
+}
+
+// WithSSRBridge makes fn callable from components during SSR as the global
+// function name. JS arguments are exported to their Go equivalents and a non-nil
+// error is thrown in JS, failing the render unless the component catches it.
+// fn must return synchronously, SSR doesn't wait for promises. Bridged functions
+// don't exist in the browser, so guard calls with typeof name !== "undefined"
+func WithSSRBridge(name string, fn func(args ...interface{}) (interface{}, error)) Option {
+	return func(a *Aviator) {
+		if a.ssrBridges == nil {
+			a.ssrBridges = map[string]func(args ...interface{}) (interface{}, error){}
+		}
+		a.ssrBridges[name] = fn
+	}
+}
+
+// WithCacheNodeModules sets whether the compiled components in node_modules are
+// cached like the app's own, true by default. Dependency components are numerous
+// and rarely change, so not caching them keeps the cache directory small at the
+// cost of compiling them on startup
+func WithCacheNodeModules(cache bool) Option {
+	return func(a *Aviator) {
+		a.skipNodeModulesCache = !cache
+	}
+}
+
+// WithBuildInfo makes build metadata such as the version or commit available to
+// components. Each value is defined as a __BUILD_{KEY}__ constant in the bundles,
+// i.e. {"version": "1.2.0"} as __BUILD_VERSION__, and the whole map is
+// available during SSR through getContext("__aviator_build_info")
+func WithBuildInfo(info map[string]string) Option {
+	return func(a *Aviator) {
+		a.buildInfo = info
+	}
+}
+
+// WithImmutable compiles components with svelte's immutable option. Changes are
+// then detected by reference, which is faster but only correct for apps that
+// never mutate props or state objects in place
+func WithImmutable(immutable bool) Option {
+	return func(a *Aviator) {
+		a.immutable = immutable
+	}
+}
+
+// WithFailOnA11yWarnings fails the build when the svelte compiler emits any
+// accessibility warnings, i.e. an <img> without alt text
+func WithFailOnA11yWarnings(fail bool) Option {
+	return func(a *Aviator) {
+		a.failOnA11yWarnings = fail
+	}
+}
+
+// WithPrettyHTML indents the rendered documents, putting every tag on its own
+// line. It's meant for inspecting the output in dev mode, production should
+// serve the compact documents
+func WithPrettyHTML(pretty bool) Option {
+	return func(a *Aviator) {
+		a.prettyHTML = pretty
+	}
+}
+
+// WithCompactPropsScript removes insignificant whitespace a custom props encoder
+// emits from the props script and leaves out the newline after it. It saves a
+// few bytes per script on pages with many of them
+func WithCompactPropsScript(compact bool) Option {
+	return func(a *Aviator) {
+		a.compactPropsScript = compact
+	}
+}
+
+// WithOmitNilPropsScript leaves the props script out of pages rendered with nil
+// props. Their views are hydrated with undefined props instead of parsing an
+// empty object, which saves a few bytes and a parse on prop-less pages
+func WithOmitNilPropsScript(omit bool) Option {
+	return func(a *Aviator) {
+		a.omitNilPropsScript = omit
+	}
+}
+
+// WithIsolatedLayoutProps renders layouts with only the props passed with the
+// LayoutProps render option, none when it's not used, instead of the props of
+// the page
+func WithIsolatedLayoutProps(isolated bool) Option {
+	return func(a *Aviator) {
+		a.isolateLayoutProps = isolated
+	}
+}
+
+// WithExplicitEntrypoints stops making views whose file name starts with an
+// uppercase letter entrypoints. Only the views registered with
+// RegisterEntrypoint or declared as entrypoints in their .meta.json file are,
+// along with their +error.svelte views. Useful for large apps where building
+// every capitalized component as a page is wasteful
+func WithExplicitEntrypoints(explicit bool) Option {
+	return func(a *Aviator) {
+		a.explicitEntrypoints = explicit
+	}
+}
+
+// WithDeterministicSSR seeds Math.random with seed before every server side
+// render, so components using it render the same output every time, i.e. for
+// snapshot tests. It only affects SSR, Math.random in the browser is untouched
+// and hydration may still see different values. Only the low 32 bits of seed
+// are used
+func WithDeterministicSSR(seed int64) Option {
+	return func(a *Aviator) {
+		a.deterministicSSR = true
+		a.ssrSeed = seed
+	}
+}
+
+// WithCacheVary declares the render context values the output of the views
+// matching viewGlob varies by, i.e. "locale" or "theme". RenderCacheKey
+// includes them in the key. Can be used multiple times, the dimensions of all
+// matching rules are combined
+func WithCacheVary(viewGlob string, dims []string) Option {
+	return func(a *Aviator) {
+		a.cacheVary = append(a.cacheVary, builder.CacheVaryRule{
+			Glob:       viewGlob,
+			Dimensions: dims,
+		})
+	}
+}
+
+// WithAssetHeaders sets the extra response headers StaticAssetHandler sends
+// with each static asset, i.e. CORS headers for fonts. headers is called with
+// the asset name and may return nil
+func WithAssetHeaders(headers func(name string) map[string]string) Option {
+	return func(a *Aviator) {
+		a.assetHeaders = headers
+	}
+}
+
+// WithStaticAssets serves the files in fsys as static assets along with the
+// built ones, named by their path in fsys. Locale specific variants of the
+// built assets, i.e. Index.svelte.fr.js, are used when rendering with Locale
+func WithStaticAssets(fsys fs.FS) Option {
+	return func(a *Aviator) {
+		a.staticAssets = fsys
+	}
+}
+
+// WithHTMLTemplateFile loads the HTML document template from path. In dev mode
+// the file is watched and reparsed whenever it changes
+func WithHTMLTemplateFile(path string) Option {
+	return func(a *Aviator) {
+		a.htmlTemplateFile = path
+	}
+}
+
 func WithLogger(l utils.Logger) Option {
 	return func(a *Aviator) {
 		a.logger = l
@@ -9,6 +9,17 @@ type Input = {
     css: boolean
     enableSourcemap: boolean
     isHydratable: boolean
+    immutable?: boolean
+    warnings?: boolean
+    //the source map of the preprocessing, chained with the compiler's
+    sourcemap?: object | null
+}
+
+type Warning = {
+    Code: string
+    Message: string
+    Line?: number
+    Column?: number
 }
 
 // Capitalized for Go
@@ -18,6 +29,7 @@ type Output =
     CSSCode: any
     JSSourceMap: string
     CSSSourceMap: string
+    Warnings?: Warning[]
 }
     | {
     Error: {
@@ -31,15 +43,17 @@ type Output =
 // Compile svelte code
 
 export function compile(input: Input): string {
-    const { code, path, target, dev, css, enableSourcemap, isHydratable } = input
+    const { code, path, target, dev, css, enableSourcemap, isHydratable, immutable, warnings, sourcemap } = input
     const svelte = compileSvelte(code, {
         filename: path,
         generate: target,
         hydratable: isHydratable,
+        immutable: immutable === true,
         format: "esm",
         dev: dev,
         css: css,
         enableSourcemap: enableSourcemap,
+        sourcemap: sourcemap ?? undefined,
     })
 
     const jsSourceMap = enableSourcemap === true ? svelte.js.map.toUrl() : ""
@@ -50,5 +64,11 @@ export function compile(input: Input): string {
         JSCode: svelte.js.code,
         CSSSourceMap: cssSourceMap,
         JSSourceMap: jsSourceMap,
+        Warnings: warnings === true ? svelte.warnings.map((warning) => ({
+            Code: warning.code,
+            Message: warning.message,
+            Line: warning.start?.line,
+            Column: warning.start?.column,
+        })) : undefined,
     } as Output)
 }
\ No newline at end of file
@@ -37237,15 +37237,17 @@ ${frame}`
 
   // compiler.ts
   function compile2(input) {
-    const { code, path, target, dev, css, enableSourcemap, isHydratable } = input;
+    const { code, path, target, dev, css, enableSourcemap, isHydratable, immutable, warnings, sourcemap } = input;
     const svelte = compile(code, {
       filename: path,
       generate: target,
       hydratable: isHydratable,
+      immutable: immutable === true,
       format: "esm",
       dev,
       css,
-      enableSourcemap
+      enableSourcemap,
+      sourcemap: sourcemap != null ? sourcemap : void 0
     });
     const jsSourceMap = enableSourcemap === true ? svelte.js.map.toUrl() : "";
     const cssSourceMap = enableSourcemap === true ? svelte.css.map.toUrl() : "";
@@ -37253,7 +37255,16 @@ ${frame}`
       CSSCode: svelte.css.code,
       JSCode: svelte.js.code,
       CSSSourceMap: cssSourceMap,
-      JSSourceMap: jsSourceMap
+      JSSourceMap: jsSourceMap,
+      Warnings: warnings === true ? svelte.warnings.map((warning) => {
+        var _a, _b;
+        return {
+          Code: warning.code,
+          Message: warning.message,
+          Line: (_a = warning.start) == null ? void 0 : _a.line,
+          Column: (_b = warning.start) == null ? void 0 : _b.column
+        };
+      }) : void 0
     });
   }
   return __toCommonJS(compiler_exports);
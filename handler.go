@@ -0,0 +1,102 @@
+package aviator
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler renders the view for every request with the props propsFn returns for
+// it. The status, redirect and headers the component sets through the
+// "__aviator_meta" svelte context are applied to the response. Errors are
+// answered with a 500, which includes the error message in dev mode:
+//
+//	mux.Handle("/", a.Handler("Index.svelte", func(r *http.Request) (interface{}, error) {
+//		return loadIndexProps(r.Context())
+//	}))
+func (a *Aviator) Handler(viewPath string, propsFn func(r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		props, err := propsFn(r)
+		if err != nil {
+			a.handlerError(w, viewPath, err)
+			return
+		}
+
+		result, err := a.RenderView(r.Context(), viewPath, props)
+		if err != nil {
+			//nobody is waiting for the response
+			if r.Context().Err() != nil {
+				return
+			}
+			a.handlerError(w, viewPath, err)
+			return
+		}
+
+		for key, value := range result.Meta.Headers {
+			w.Header().Set(key, value)
+		}
+
+		status := result.Meta.Status
+		if len(result.Meta.Redirect) > 0 {
+			if status == 0 {
+				status = http.StatusFound
+			}
+			http.Redirect(w, r, result.Meta.Redirect, status)
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		//the component may render something other than HTML
+		if len(w.Header().Get("Content-Type")) == 0 {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, result.HTML)
+	})
+}
+
+// handlerError logs err and answers with a 500. The error is only shown to the
+// client in dev mode
+func (a *Aviator) handlerError(w http.ResponseWriter, viewPath string, err error) {
+	a.logger.Error("error rendering " + viewPath + ": " + err.Error())
+
+	message := http.StatusText(http.StatusInternalServerError)
+	if a.isDevMode {
+		message = err.Error()
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// StaticAssetHandler serves the static assets referenced by rendered pages.
+// prefix is trimmed from the request path to get the asset name, so it must be
+// the route the handler is mounted on, i.e. the static asset, JS or CSS route:
+//
+//	mux.Handle("/static/", a.StaticAssetHandler("/static"))
+//	mux.Handle("/js/", a.StaticAssetHandler("/js"))
+//
+// Headers returned by the WithAssetHeaders func are added to every response
+func (a *Aviator) StaticAssetHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		name = strings.TrimPrefix(name, "/")
+
+		content, mimeType, found := a.GetStaticAsset(name)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", mimeType)
+		if a.assetHeaders != nil {
+			for key, value := range a.assetHeaders(name) {
+				w.Header().Set(key, value)
+			}
+		}
+
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+	})
+}
@@ -0,0 +1,196 @@
+package aviator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAviator_StaticAssetHandler(t *testing.T) {
+	app := fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"Views": [],
+			"Assets": [
+				{"Name": "Index.svelte.js", "MimeType": "text/javascript"},
+				{"Name": "font.woff2", "MimeType": "font/woff2"}
+			]
+		}`)},
+		"ssr.js":                 {Data: []byte(`var __aviator__ = {}`)},
+		"assets/Index.svelte.js": {Data: []byte(`console.log("index")`)},
+		"assets/font.woff2":      {Data: []byte(`font`)},
+	}
+
+	a := NewAviator(
+		WithEmbeddedApp(app),
+		WithStaticAssetRoute("/static"),
+		WithAssetHeaders(func(name string) map[string]string {
+			if strings.HasSuffix(name, ".woff2") {
+				return map[string]string{"Access-Control-Allow-Origin": "*"}
+			}
+			return nil
+		}),
+	)
+	assert.NoError(t, a.Init())
+	t.Cleanup(func() { a.Close() })
+	handler := a.StaticAssetHandler("/static")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/font.woff2", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "font/woff2", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "font", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/Index.svelte.js", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/javascript", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/missing.js", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	//assets can be served under the JS route too
+	rec = httptest.NewRecorder()
+	a.StaticAssetHandler("/js").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/js/Index.svelte.js", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `console.log("index")`, rec.Body.String())
+}
+
+func TestAviator_Handler(t *testing.T) {
+	app := fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"Views": [
+				{"RelPath": "Index.svelte", "WrappedUniqueName": "__AviatorWrapped_Index", "IsEntrypoint": true}
+			],
+			"Assets": []
+		}`)},
+		"ssr.js": {Data: []byte(`var __aviator__ = {
+			render: function(name, props) {
+				if (props.fail) {
+					throw new Error("render failed")
+				}
+				var meta = props.redirect ? { redirect: props.redirect } : { headers: { "Cache-Control": "no-store" } }
+				if (props.contentType) {
+					meta.headers["Content-Type"] = props.contentType
+				}
+				return JSON.stringify({ body: "<h1>" + props.title + "</h1>", meta: meta })
+			}
+		};`)},
+	}
+
+	newHandler := func(devMode bool) http.Handler {
+		a := NewAviator(WithEmbeddedApp(app), WithDevMode(devMode), WithNullLogger())
+		assert.NoError(t, a.Init())
+		t.Cleanup(func() { a.Close() })
+		return a.Handler("Index.svelte", func(r *http.Request) (interface{}, error) {
+			if r.URL.Query().Has("propsError") {
+				return nil, errors.New("props failed")
+			}
+			return map[string]interface{}{
+				"title":       r.URL.Query().Get("title"),
+				"redirect":    r.URL.Query().Get("redirect"),
+				"contentType": r.URL.Query().Get("contentType"),
+				"fail":        r.URL.Query().Has("fail"),
+			}, nil
+		})
+	}
+	handler := newHandler(false)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?title=Hello", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), "<h1>Hello</h1>")
+
+	//a Content-Type set by the component isn't overridden
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?title=Feed&contentType=application/xml", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?redirect=/login", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/login", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?propsError", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "props failed")
+
+	rec = httptest.NewRecorder()
+	newHandler(true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?fail", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "render failed")
+}
+
+func TestAviator_Handler_CancelledRequest(t *testing.T) {
+	app := fstest.MapFS{
+		"manifest.json": {Data: []byte(`{
+			"Views": [
+				{"RelPath": "Index.svelte", "WrappedUniqueName": "__AviatorWrapped_Index", "IsEntrypoint": true}
+			],
+			"Assets": []
+		}`)},
+		"ssr.js": {Data: []byte(`var __aviator__ = {
+			render: function(name, props) {
+				renderStarted()
+				while (props.spin) {}
+				return JSON.stringify({ body: "<h1>Rendered</h1>" })
+			}
+		};`)},
+	}
+
+	started := make(chan struct{}, 1)
+	a := NewAviator(
+		WithEmbeddedApp(app),
+		WithNullLogger(),
+		WithSSRBridge("renderStarted", func(_ ...interface{}) (interface{}, error) {
+			started <- struct{}{}
+			return nil, nil
+		}),
+	)
+	assert.NoError(t, a.Init())
+	t.Cleanup(func() { a.Close() })
+	handler := a.Handler("Index.svelte", func(r *http.Request) (interface{}, error) {
+		return map[string]interface{}{"spin": r.URL.Query().Has("spin")}, nil
+	})
+
+	//a request cancelled before rendering is never rendered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	assert.Empty(t, started)
+	assert.Empty(t, rec.Body.String())
+
+	//cancelling it while rendering stops the render
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	rec = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?spin", nil).WithContext(ctx))
+		close(done)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the render wasn't stopped")
+	}
+	assert.Empty(t, rec.Body.String())
+	assert.NotContains(t, rec.Header().Get("Content-Type"), "text/html")
+}
@@ -0,0 +1,50 @@
+package js
+
+import (
+	"context"
+
+	"github.com/mansoor-s/aviator/utils"
+)
+
+type consoleLabelKey struct{}
+
+// WithConsoleLabel labels the console output of the scripts evaluated with ctx,
+// i.e. with the path of the view being rendered. Without a label, output is
+// labeled with the path of the evaluated script
+func WithConsoleLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, consoleLabelKey{}, label)
+}
+
+func consoleLabel(ctx context.Context, path string) string {
+	if label, ok := ctx.Value(consoleLabelKey{}).(string); ok {
+		return label
+	}
+	return path
+}
+
+// consolePrinter writes console output to logger: log and info as info, warn
+// and error as errors. Messages are prefixed with the label of the script
+// being evaluated
+type consolePrinter struct {
+	logger utils.Logger
+	vm     *gojaVM
+}
+
+func (p *consolePrinter) format(s string) string {
+	if len(p.vm.consoleLabel) == 0 {
+		return s
+	}
+	return "[" + p.vm.consoleLabel + "] " + s
+}
+
+func (p *consolePrinter) Log(s string) {
+	p.logger.Info(p.format(s))
+}
+
+func (p *consolePrinter) Warn(s string) {
+	p.logger.Error(p.format(s))
+}
+
+func (p *consolePrinter) Error(s string) {
+	p.logger.Error(p.format(s))
+}
@@ -0,0 +1,38 @@
+package js
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	infos  []string
+	errors []string
+}
+
+func (l *recordingLogger) Info(s string)  { l.infos = append(l.infos, s) }
+func (l *recordingLogger) Error(s string) { l.errors = append(l.errors, s) }
+
+func TestGojaVM_ConsoleLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	vm, err := newGojaVM(VMOptions{Logger: logger})
+	assert.NoError(t, err)
+
+	ctx := WithConsoleLabel(context.Background(), "users/List.svelte")
+	_, err = vm.Eval(ctx, "runtime_renderer", `
+		console.log("rendering %s", "list")
+		console.info("info")
+		console.warn("careful")
+		console.error(new Error("failed").message)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[users/List.svelte] rendering list", "[users/List.svelte] info"}, logger.infos)
+	assert.Equal(t, []string{"[users/List.svelte] careful", "[users/List.svelte] failed"}, logger.errors)
+
+	//scripts without a label are labeled with their path
+	_, err = vm.Eval(context.Background(), "init.js", `console.debug("ready")`)
+	assert.NoError(t, err)
+	assert.Equal(t, "[init.js] ready", logger.infos[2])
+}
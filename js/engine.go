@@ -0,0 +1,84 @@
+package js
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/puddle"
+	"github.com/mansoor-s/aviator/utils"
+)
+
+// Engine is the JS engine VMs are created with
+type Engine string
+
+const (
+	//EngineGoja is the pure Go engine. It's the default and doesn't need CGO
+	EngineGoja Engine = "goja"
+	//EngineV8 is V8 through v8go. It's faster for SSR heavy workloads but needs
+	//CGO and building with the v8 build tag
+	EngineV8 Engine = "v8"
+)
+
+// ErrV8Unavailable is returned when creating V8 VMs in a binary built without
+// the v8 build tag
+var ErrV8Unavailable = errors.New("the v8 JS engine requires building with -tags v8")
+
+// ErrVMPoolExhausted is returned when every VM stayed busy for the whole
+// acquire timeout
+var ErrVMPoolExhausted = errors.New("VM pool exhausted")
+
+// VMOptions configures the VMs of a pool
+type VMOptions struct {
+	//Fetch configures the fetch global of goja VMs. V8 VMs always have fetch
+	Fetch FetchOptions
+
+	//Polyfills install additional globals on goja VMs, after the built-in
+	//TextEncoder, TextDecoder, setTimeout and fetch
+	Polyfills []GojaPolyfill
+
+	//Logger receives the console output of goja VMs and the errors of
+	//exhausted pools. Console output is written to stdout when nil
+	Logger utils.Logger
+
+	//AcquireTimeout is how long a script waits for a busy VM to be released
+	//before failing with ErrVMPoolExhausted. It waits until its context is done
+	//when zero
+	AcquireTimeout time.Duration
+}
+
+// NewVMPool creates a pool of poolSize VMs of engine. An empty engine is
+// EngineGoja
+func NewVMPool(engine Engine, poolSize int, options VMOptions) (VM, error) {
+	switch engine {
+	case "", EngineGoja:
+		return NewGojaVMPool(poolSize, options)
+	case EngineV8:
+		return NewV8VMPool(poolSize, options)
+	}
+
+	return nil, fmt.Errorf("unknown JS engine %q", engine)
+}
+
+// acquireVM acquires a VM of pool, failing with ErrVMPoolExhausted when none
+// was released within timeout. A timeout of zero waits until ctx is done
+func acquireVM(ctx context.Context, pool *puddle.Pool, timeout time.Duration, logger utils.Logger) (*puddle.Resource, error) {
+	if timeout <= 0 {
+		return pool.Acquire(ctx)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := pool.Acquire(acquireCtx)
+	//a caller that gave up isn't a sign of saturation
+	if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: all %d VMs were busy for %s", ErrVMPoolExhausted, pool.Stat().MaxResources(), timeout)
+		if logger != nil {
+			logger.Error(err.Error() + ", consider raising the number of VMs")
+		}
+	}
+
+	return res, err
+}
@@ -1,34 +1,117 @@
 package js
 
-/*
-import "rogchap.com/v8go"
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
 
-const (
-	v8Err = iota
-	unkErr
+	"github.com/dop251/goja"
 )
 
-//JSError provides a JS runtime agnostic error
+// JSError is an error thrown by a script. Scripts bundled with an inline
+// source map have their stack frames mapped back to the original sources
 type JSError struct {
-	errType int
-	err     error
+	//Message is the thrown value as a string, i.e. "ReferenceError: x is not defined"
+	Message string
+	//Stack starts with the frame the error was thrown from
+	Stack []StackFrame
+
+	err error
+}
+
+// StackFrame is a call in the stack of a JSError. File is empty for native
+// functions
+type StackFrame struct {
+	Function string
+	File     string
+	Line     int
+	Column   int
+}
+
+func (f StackFrame) String() string {
+	location := "native"
+	if len(f.File) > 0 {
+		location = f.File + ":" + strconv.Itoa(f.Line) + ":" + strconv.Itoa(f.Column)
+	}
+	if len(f.Function) == 0 {
+		return location
+	}
+	return f.Function + " (" + location + ")"
 }
 
-func (j JSError) Error() string {
-	return j.err.Error()
+// Error returns the message and the location the error was thrown from
+func (e *JSError) Error() string {
+	if len(e.Stack) == 0 {
+		return e.Message
+	}
+	return e.Message + " at " + e.Stack[0].String()
 }
 
-func (j JSError) StackTrace() string {
-	if j.errType == v8Err {
-		return j.err.(*v8go.JSError).StackTrace
+// StackTrace returns the message followed by one line per frame, formatted
+// like a JS stack trace
+func (e *JSError) StackTrace() string {
+	var b strings.Builder
+	b.WriteString(e.Message)
+	for _, frame := range e.Stack {
+		b.WriteString("\n    at ")
+		b.WriteString(frame.String())
 	}
-	return ""
+	return b.String()
+}
+
+// Unwrap returns the error of the JS runtime, i.e. a *goja.Exception
+func (e *JSError) Unwrap() error {
+	return e.err
 }
 
-func newV8JSError(err error) JSError {
-	return JSError{
-		errType: v8Err,
+// stackFrameLine matches frames formatted as "fn (file:line:column)" or
+// "file:line:column". goja adds the program counter to the column, i.e.
+// "file:3:19(4)"
+var stackFrameLine = regexp.MustCompile(`^\s*at (?:(.+) \()?(.+):(\d+):(\d+)(?:\(\d+\))?\)?$`)
+
+// nativeFrameLine matches frames of native functions, "fn (native)" or "native"
+var nativeFrameLine = regexp.MustCompile(`^\s*at (?:(.+) \()?native\)?$`)
+
+// parseStackFrames parses the "at ..." lines of a stack trace, lines that
+// aren't frames are skipped
+func parseStackFrames(stack string) []StackFrame {
+	var frames []StackFrame
+	for _, line := range strings.Split(stack, "\n") {
+		if match := stackFrameLine.FindStringSubmatch(line); match != nil {
+			frame := StackFrame{
+				Function: match[1],
+				File:     match[2],
+			}
+			frame.Line, _ = strconv.Atoi(match[3])
+			frame.Column, _ = strconv.Atoi(match[4])
+			frames = append(frames, frame)
+			continue
+		}
+		if match := nativeFrameLine.FindStringSubmatch(line); match != nil {
+			frames = append(frames, StackFrame{Function: match[1]})
+		}
+	}
+	return frames
+}
+
+// newGojaJSError converts goja exceptions to a *JSError, other errors are
+// returned as is. goja has already mapped the positions through the inline
+// source map of the script
+func newGojaJSError(err error) error {
+	var exception *goja.Exception
+	if !errors.As(err, &exception) {
+		return err
+	}
+
+	var message string
+	if exception.Value() != nil {
+		message = exception.Value().String()
+	}
+
+	return &JSError{
+		Message: message,
+		Stack:   parseStackFrames(strings.TrimPrefix(exception.String(), message)),
 		err:     err,
 	}
 }
-*/
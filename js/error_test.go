@@ -0,0 +1,73 @@
+package js
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dop251/goja"
+	esbuild "github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVM_JSError(t *testing.T) {
+	//bundled like the SSR bundle: an IIFE with an inline source map
+	dir := t.TempDir()
+	result := esbuild.Build(esbuild.BuildOptions{
+		Stdin: &esbuild.StdinOptions{
+			Contents:   "export function render(props) {\n  var greeting = 'Hello '\n  return greeting + props.user.name\n}\n",
+			Sourcefile: "comp.js",
+			ResolveDir: dir,
+		},
+		AbsWorkingDir: dir,
+		Outdir:        "./out",
+		Bundle:        true,
+		Format:        esbuild.FormatIIFE,
+		GlobalName:    "__aviator__",
+		Sourcemap:     esbuild.SourceMapInline,
+	})
+	assert.Empty(t, result.Errors)
+
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+	_, err = vm.Eval(context.Background(), "bundle.js", string(result.OutputFiles[0].Contents))
+	assert.NoError(t, err)
+
+	_, err = vm.Eval(context.Background(), "runtime_renderer", "__aviator__.render({})")
+	var jsErr *JSError
+	assert.True(t, errors.As(err, &jsErr))
+	assert.Equal(t, "TypeError: Cannot read property 'name' of undefined", jsErr.Message)
+	assert.Equal(t, "render", jsErr.Stack[0].Function)
+	assert.Equal(t, "../comp.js", jsErr.Stack[0].File)
+	assert.Equal(t, 3, jsErr.Stack[0].Line)
+	assert.Equal(t, "runtime_renderer", jsErr.Stack[len(jsErr.Stack)-1].File)
+	assert.Contains(t, err.Error(), "at render (../comp.js:3:")
+	assert.Contains(t, jsErr.StackTrace(), "\n    at runtime_renderer:1:")
+
+	//the goja exception is still available
+	var exception *goja.Exception
+	assert.True(t, errors.As(err, &exception))
+
+	//any value can be thrown
+	_, err = vm.Eval(context.Background(), "throw.js", `throw "plain"`)
+	assert.True(t, errors.As(err, &jsErr))
+	assert.Equal(t, "plain", jsErr.Message)
+	assert.Equal(t, "throw.js", jsErr.Stack[0].File)
+}
+
+func TestParseStackFrames(t *testing.T) {
+	//V8 formatted
+	frames := parseStackFrames("Error: boom\n    at render (bundle.js:10:5)\n    at bundle.js:20:1\n    at Array.map (native)")
+	assert.Equal(t, []StackFrame{
+		{Function: "render", File: "bundle.js", Line: 10, Column: 5},
+		{File: "bundle.js", Line: 20, Column: 1},
+		{Function: "Array.map"},
+	}, frames)
+
+	//goja formatted
+	frames = parseStackFrames("\tat render (svelte:/views/Index.svelte:3:16(12))\n\tat native\n")
+	assert.Equal(t, []StackFrame{
+		{Function: "render", File: "svelte:/views/Index.svelte", Line: 3, Column: 16},
+		{},
+	}, frames)
+}
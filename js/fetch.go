@@ -0,0 +1,204 @@
+package js
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// DefaultFetchTimeout is the timeout of fetch requests when none is configured
+const DefaultFetchTimeout = 10 * time.Second
+
+// DefaultFetchMaxResponseSize is the largest response body fetch reads when no
+// limit is configured, 10MB
+const DefaultFetchMaxResponseSize = 10 << 20
+
+// maxFetchRedirects is the number of redirects fetch follows, like net/http
+const maxFetchRedirects = 10
+
+// FetchOptions configures the fetch global of goja VMs
+type FetchOptions struct {
+	//Enabled registers fetch. It's undefined otherwise, so SSR can't make
+	//requests
+	Enabled bool
+
+	//Timeout of each request, including reading the response body. Defaults to
+	//DefaultFetchTimeout
+	Timeout time.Duration
+
+	//AllowedHosts are the hosts requests may be sent to, with or without the
+	//port. Redirects are only followed to these hosts. Every host is allowed
+	//when empty
+	AllowedHosts []string
+
+	//MaxResponseSize is the largest response body in bytes, larger responses
+	//fail. Defaults to DefaultFetchMaxResponseSize
+	MaxResponseSize int64
+}
+
+// isAllowed reports whether requests to u are allowed
+func (o FetchOptions) isAllowed(u *url.URL) bool {
+	if len(o.AllowedHosts) == 0 {
+		return true
+	}
+	for _, host := range o.AllowedHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchPolyfill registers a fetch global backed by net/http. goja has no event
+// loop, so requests are sent synchronously and fetch returns a settled promise.
+// Responses support ok, status, statusText, url, headers.get(), text() and json()
+func fetchPolyfill(options FetchOptions) GojaPolyfill {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+	if options.MaxResponseSize <= 0 {
+		options.MaxResponseSize = DefaultFetchMaxResponseSize
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		//the allowed hosts apply to every hop
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if !options.isAllowed(request.URL) {
+				return fmt.Errorf("redirect to host %s is not allowed", request.URL.Host)
+			}
+			return nil
+		},
+	}
+
+	return func(runtime *goja.Runtime) error {
+		return runtime.Set("fetch", func(call goja.FunctionCall) goja.Value {
+			promise, resolve, reject := runtime.NewPromise()
+			response, err := fetch(runtime, client, options, call)
+			if err != nil {
+				reject(runtime.NewTypeError("fetch failed: %s", err.Error()))
+			} else {
+				resolve(response)
+			}
+			return runtime.ToValue(promise)
+		})
+	}
+}
+
+// fetch sends the request described by the arguments of a fetch call
+func fetch(runtime *goja.Runtime, client *http.Client, options FetchOptions, call goja.FunctionCall) (*goja.Object, error) {
+	requestURL, err := url.Parse(call.Argument(0).String())
+	if err != nil {
+		return nil, err
+	}
+	if requestURL.Scheme != "http" && requestURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL %q, only absolute http and https URLs can be fetched", requestURL.String())
+	}
+	if !options.isAllowed(requestURL) {
+		return nil, fmt.Errorf("host %s is not allowed", requestURL.Host)
+	}
+
+	method := http.MethodGet
+	var body io.Reader
+	headers := map[string]string{}
+	if init, ok := call.Argument(1).Export().(map[string]interface{}); ok {
+		if initMethod, ok := init["method"].(string); ok {
+			method = strings.ToUpper(initMethod)
+		}
+		if initBody, ok := init["body"].(string); ok {
+			body = strings.NewReader(initBody)
+		}
+		if initHeaders, ok := init["headers"].(map[string]interface{}); ok {
+			for name, value := range initHeaders {
+				headers[name] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	request, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(response.Body, options.MaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > options.MaxResponseSize {
+		return nil, fmt.Errorf("response body is larger than %d bytes", options.MaxResponseSize)
+	}
+
+	return newFetchResponse(runtime, response, string(content))
+}
+
+// newFetchResponse creates the JS Response object of response
+func newFetchResponse(runtime *goja.Runtime, response *http.Response, content string) (*goja.Object, error) {
+	jsonParse, ok := goja.AssertFunction(runtime.Get("JSON").ToObject(runtime).Get("parse"))
+	if !ok {
+		return nil, errors.New("JSON.parse is not a function")
+	}
+
+	headers := runtime.NewObject()
+	err := headers.Set("get", func(name string) goja.Value {
+		if values, ok := response.Header[http.CanonicalHeaderKey(name)]; ok {
+			return runtime.ToValue(strings.Join(values, ", "))
+		}
+		return goja.Null()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	settled := func(value goja.Value, err error) goja.Value {
+		promise, resolve, reject := runtime.NewPromise()
+		var exception *goja.Exception
+		if errors.As(err, &exception) {
+			reject(exception.Value())
+		} else if err != nil {
+			reject(err)
+		} else {
+			resolve(value)
+		}
+		return runtime.ToValue(promise)
+	}
+
+	jsResponse := runtime.NewObject()
+	for name, value := range map[string]interface{}{
+		"ok":         response.StatusCode >= 200 && response.StatusCode < 300,
+		"status":     response.StatusCode,
+		"statusText": http.StatusText(response.StatusCode),
+		"url":        response.Request.URL.String(),
+		"headers":    headers,
+		"text": func() goja.Value {
+			return settled(runtime.ToValue(content), nil)
+		},
+		"json": func() goja.Value {
+			return settled(jsonParse(goja.Undefined(), runtime.ToValue(content)))
+		},
+	} {
+		err = jsResponse.Set(name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jsResponse, nil
+}
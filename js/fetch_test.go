@@ -0,0 +1,138 @@
+package js
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVM_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte(`{"name": "Ada", "auth": "` + r.Header.Get("Authorization") + `", "body": "` + string(body) + `"}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	vm, err := newGojaVM(VMOptions{Fetch: FetchOptions{Enabled: true, AllowedHosts: []string{serverURL.Hostname()}}})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "get.js", `fetch("`+server.URL+`/user").then(function (res) {
+		return res.json()
+	}).then(function (user) {
+		return user.name
+	})`)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ada", out)
+
+	out, err = vm.Eval(context.Background(), "post.js", `fetch("`+server.URL+`/user", {
+		method: "post",
+		headers: {"Authorization": "token"},
+		body: "hello",
+	}).then(function (res) {
+		return res.headers.get("x-method") + " " + res.status + " " + res.ok
+	})`)
+	assert.NoError(t, err)
+	assert.Equal(t, "POST 200 true", out)
+
+	out, err = vm.Eval(context.Background(), "text.js", `fetch("`+server.URL+`/missing", {
+		headers: {"Authorization": "token"},
+	}).then(function (res) {
+		return res.text().then(function (text) {
+			return res.status + " " + res.ok + " " + JSON.parse(text).auth
+		})
+	})`)
+	assert.NoError(t, err)
+	assert.Equal(t, "404 false token", out)
+
+	//hosts that aren't allowed are rejected without sending anything
+	_, err = vm.Eval(context.Background(), "denied.js", `fetch("http://example.com/")`)
+	assert.ErrorContains(t, err, "example.com is not allowed")
+}
+
+func TestGojaVM_Fetch_Redirect(t *testing.T) {
+	internalHits := 0
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits++
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+	internalURL, err := url.Parse(internal.URL)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal":
+			//same address, but a host that isn't allowed
+			http.Redirect(w, r, "http://localhost:"+internalURL.Port()+"/", http.StatusFound)
+		case "/moved":
+			http.Redirect(w, r, "/user", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("user"))
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	vm, err := newGojaVM(VMOptions{Fetch: FetchOptions{Enabled: true, AllowedHosts: []string{serverURL.Hostname()}}})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "moved.js", `fetch("`+server.URL+`/moved").then(function (res) {
+		return res.text()
+	})`)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", out)
+
+	_, err = vm.Eval(context.Background(), "internal.js", `fetch("`+server.URL+`/internal")`)
+	assert.ErrorContains(t, err, "is not allowed")
+	assert.Equal(t, 0, internalHits)
+}
+
+func TestGojaVM_Fetch_MaxResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 11)))
+	}))
+	defer server.Close()
+
+	vm, err := newGojaVM(VMOptions{Fetch: FetchOptions{Enabled: true, MaxResponseSize: 10}})
+	assert.NoError(t, err)
+
+	_, err = vm.Eval(context.Background(), "large.js", `fetch("`+server.URL+`")`)
+	assert.ErrorContains(t, err, "larger than 10 bytes")
+}
+
+func TestGojaVM_Fetch_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	vm, err := newGojaVM(VMOptions{Fetch: FetchOptions{Enabled: true, Timeout: 10 * time.Millisecond}})
+	assert.NoError(t, err)
+
+	_, err = vm.Eval(context.Background(), "timeout.js", `fetch("`+server.URL+`")`)
+	assert.ErrorContains(t, err, "fetch failed")
+}
+
+func TestGojaVM_Fetch_DisabledByDefault(t *testing.T) {
+	//fetch is opt-in
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "disabled.js", `typeof fetch`)
+	assert.NoError(t, err)
+	assert.Equal(t, "undefined", out)
+}
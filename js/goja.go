@@ -1,7 +1,10 @@
 package js
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/dop251/goja"
 	"github.com/dop251/goja_nodejs/console"
@@ -12,18 +15,61 @@ type gojaVM struct {
 	runtime *goja.Runtime
 	//pool     *puddle.Pool
 	preCompiled map[string]*goja.Program
+	//programs are the sources run with EvalProgram, compiled once
+	programs map[string]*goja.Program
+	timers   *timers
+
+	//consoleLabel prefixes the console output of the script being evaluated
+	consoleLabel string
 }
 
 //var _ VM = &gojaVM{}
 
-func newGojaVM() (*gojaVM, error) {
+func newGojaVM(options VMOptions) (*gojaVM, error) {
 	runtime := goja.New()
-	new(require.Registry).Enable(runtime)
-	console.Enable(runtime)
-	return &gojaVM{
+	vm := &gojaVM{
 		runtime:     runtime,
 		preCompiled: make(map[string]*goja.Program),
-	}, nil
+		programs:    make(map[string]*goja.Program),
+	}
+
+	registry := new(require.Registry)
+	if options.Logger != nil {
+		registry.RegisterNativeModule(console.ModuleName, console.RequireWithPrinter(&consolePrinter{
+			logger: options.Logger,
+			vm:     vm,
+		}))
+	}
+	registry.Enable(runtime)
+	console.Enable(runtime)
+	//the console module only has log, warn and error
+	jsConsole := runtime.Get("console").ToObject(runtime)
+	for _, name := range []string{"info", "debug"} {
+		err := jsConsole.Set(name, jsConsole.Get("log"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := vm.enableTimers()
+	if err != nil {
+		return nil, err
+	}
+
+	polyfills := []GojaPolyfill{enableTextEncoding}
+	if options.Fetch.Enabled {
+		polyfills = append(polyfills, fetchPolyfill(options.Fetch))
+	}
+	//user polyfills come last, so they can replace the built-in ones
+	polyfills = append(polyfills, options.Polyfills...)
+	for _, polyfill := range polyfills {
+		err = polyfill(runtime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return vm, nil
 }
 
 func (g *gojaVM) PreCompile(uniqueName string, source string) error {
@@ -45,26 +91,141 @@ func (g *gojaVM) RunScript(uniqueName string) (string, error) {
 
 	outputVal, err := g.runtime.RunProgram(prog)
 	if err != nil {
-		return "", err
+		return "", newGojaJSError(err)
 	}
 
 	return outputVal.String(), nil
 }
 
-func (g *gojaVM) Eval(path, source string) (string, error) {
-	val, err := g.runtime.RunScript(path, source)
+func (g *gojaVM) SetGlobal(name string, value interface{}) error {
+	return g.runtime.Set(name, value)
+}
+
+// Eval runs source and then its setTimeout callbacks, interrupting them when
+// ctx is done. ctx.Err() is returned for interrupted scripts and a *JSError
+// for exceptions thrown by the script. If source
+// evaluates to a Promise, its resolved value is returned and a rejection is
+// returned as an error
+func (g *gojaVM) Eval(ctx context.Context, path, source string) (string, error) {
+	return g.run(ctx, path, func() (goja.Value, error) {
+		return g.runtime.RunScript(path, source)
+	})
+}
+
+// EvalProgram is Eval for scripts run over and over, i.e. the render of every
+// request. source is compiled on its first run only, what changes between runs
+// is passed as globals instead
+func (g *gojaVM) EvalProgram(ctx context.Context, path, source string, globals map[string]interface{}) (string, error) {
+	program, ok := g.programs[source]
+	if !ok {
+		var err error
+		program, err = goja.Compile(path, source, false)
+		if err != nil {
+			return "", fmt.Errorf("failed to compile %s: %w", path, err)
+		}
+		g.programs[source] = program
+	}
+
+	//the globals, i.e. request props, must not outlive the run
+	defer func() {
+		for name := range globals {
+			_ = g.runtime.Set(name, goja.Undefined())
+		}
+	}()
+
+	for name, value := range globals {
+		err := g.runtime.Set(name, value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return g.run(ctx, path, func() (goja.Value, error) {
+		return g.runtime.RunProgram(program)
+	})
+}
+
+// run calls script, which runs the evaluated code, and then the timers it set
+func (g *gojaVM) run(ctx context.Context, path string, script func() (goja.Value, error)) (string, error) {
+	if ctx.Done() != nil {
+		stop := g.interruptWhenDone(ctx)
+		defer stop()
+	}
+
+	g.consoleLabel = consoleLabel(ctx, path)
+	defer func() {
+		g.consoleLabel = ""
+	}()
+
+	val, err := script()
+	if err == nil {
+		//callbacks deferred with setTimeout run before the result is read
+		err = g.runTimers()
+	}
+	if err != nil {
+		g.timers.clear()
+	}
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
 	if err != nil {
-		return "", err
+		return "", newGojaJSError(err)
 	}
 
 	if val == nil {
 		return "", nil
 	}
 
+	if promise, ok := val.Export().(*goja.Promise); ok {
+		return settledValue(promise)
+	}
+
 	return val.String(), nil
 
 }
 
+// settledValue returns the value a promise resolved to. goja runs the promise
+// jobs before RunScript returns, so a promise that's still pending waits on
+// something that can never happen without an event loop
+func settledValue(promise *goja.Promise) (string, error) {
+	switch promise.State() {
+	case goja.PromiseStateFulfilled:
+		if promise.Result() == nil {
+			return "", nil
+		}
+		return promise.Result().String(), nil
+	case goja.PromiseStateRejected:
+		return "", fmt.Errorf("promise rejected: %s", promise.Result().String())
+	}
+
+	return "", errors.New("promise is still pending after the script finished")
+}
+
+// interruptWhenDone interrupts the runtime when ctx is done. The returned func
+// stops watching ctx and must be called once the script finished. It clears an
+// interrupt that arrived too late to stop the script, so it can't abort the
+// next one
+func (g *gojaVM) interruptWhenDone(ctx context.Context) func() {
+	finished := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			g.runtime.Interrupt(ctx.Err())
+		case <-finished:
+		}
+	}()
+
+	return func() {
+		close(finished)
+		wg.Wait()
+		g.runtime.ClearInterrupt()
+	}
+}
+
 /*
 func (g *gojaVM) InitializationScript(path, script string) error {
 	return nil
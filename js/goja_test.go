@@ -0,0 +1,129 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVM_Eval_Promise(t *testing.T) {
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "resolved.js", `Promise.resolve(JSON.stringify({a:1}))`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, out)
+
+	out, err = vm.Eval(context.Background(), "chained.js", `Promise.resolve(2).then(function (value) {
+		return JSON.stringify({b: value})
+	})`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"b":2}`, out)
+
+	_, err = vm.Eval(context.Background(), "rejected.js", `Promise.reject(new Error("render failed"))`)
+	assert.ErrorContains(t, err, "render failed")
+
+	_, err = vm.Eval(context.Background(), "pending.js", `new Promise(function () {})`)
+	assert.ErrorContains(t, err, "pending")
+}
+
+func TestGojaVM_EvalProgram(t *testing.T) {
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	source := `greeting + " " + JSON.parse(props).name`
+	out, err := vm.EvalProgram(context.Background(), "program.js", source, map[string]interface{}{
+		"greeting": "Hello",
+		"props":    `{"name": "Ada"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello Ada", out)
+	assert.Len(t, vm.programs, 1)
+
+	//later runs reuse the compiled program with new globals
+	out, err = vm.EvalProgram(context.Background(), "program.js", source, map[string]interface{}{
+		"greeting": "Bye",
+		"props":    `{"name": "Grace"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bye Grace", out)
+	assert.Len(t, vm.programs, 1)
+
+	//the globals are cleared once the program ran
+	assert.True(t, goja.IsUndefined(vm.runtime.Get("props")))
+	assert.True(t, goja.IsUndefined(vm.runtime.Get("greeting")))
+
+	_, err = vm.EvalProgram(context.Background(), "throw.js", `throw new Error(message)`, map[string]interface{}{
+		"message": "render failed",
+	})
+	var jsErr *JSError
+	assert.ErrorAs(t, err, &jsErr)
+	assert.Equal(t, "Error: render failed", jsErr.Message)
+
+	_, err = vm.EvalProgram(context.Background(), "invalid.js", `var = 1`, nil)
+	assert.ErrorContains(t, err, "failed to compile invalid.js")
+	var syntaxErr *goja.CompilerSyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+}
+
+// benchmarkRenderBundle renders a list from the props, like a small SSR bundle
+const benchmarkRenderBundle = `var __aviator__ = {
+	render: function (name, props, context) {
+		var body = "<ul>"
+		for (var i = 0; i < props.items.length; i++) {
+			body += "<li>" + props.items[i].title + "</li>"
+		}
+		return JSON.stringify({ head: "<title>" + name + "</title>", body: body + "</ul>" })
+	}
+}`
+
+// BenchmarkGojaVM_Render compares rendering with the props inlined in a script
+// parsed on every render to a precompiled program reading them from globals
+func BenchmarkGojaVM_Render(b *testing.B) {
+	props := `{"items": [`
+	for i := 0; i < 50; i++ {
+		if i > 0 {
+			props += ","
+		}
+		props += fmt.Sprintf(`{"id": %d, "title": "Item %d", "tags": ["a", "b", "c"]}`, i, i)
+	}
+	props += `]}`
+
+	vm, err := newGojaVM(VMOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = vm.Eval(context.Background(), "bundle.js", benchmarkRenderBundle)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Eval", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			expr := fmt.Sprintf("; __aviator__.render(%q, %s, %s)", "Index", props, "{}")
+			_, err := vm.Eval(context.Background(), "runtime_renderer", expr)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("EvalProgram", func(b *testing.B) {
+		b.ReportAllocs()
+		source := "; __aviator__.render(view, JSON.parse(props), JSON.parse(context))"
+		for i := 0; i < b.N; i++ {
+			_, err := vm.EvalProgram(context.Background(), "runtime_renderer", source, map[string]interface{}{
+				"view":    "Index",
+				"props":   props,
+				"context": "{}",
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package js
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// GojaPolyfill installs globals on a goja runtime, i.e. browser APIs that
+// components or libraries expect to exist during SSR
+type GojaPolyfill func(runtime *goja.Runtime) error
+
+// enableTextEncoding registers UTF-8 only TextEncoder and TextDecoder classes
+func enableTextEncoding(runtime *goja.Runtime) error {
+	err := runtime.Set("TextEncoder", func(call goja.ConstructorCall) *goja.Object {
+		_ = call.This.Set("encoding", "utf-8")
+		_ = call.This.Set("encode", func(input goja.Value) goja.Value {
+			var text string
+			if !goja.IsUndefined(input) {
+				text = input.String()
+			}
+			buffer := runtime.NewArrayBuffer([]byte(text))
+			array, err := runtime.New(runtime.Get("Uint8Array"), runtime.ToValue(buffer))
+			if err != nil {
+				panic(err)
+			}
+			return array
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return runtime.Set("TextDecoder", func(call goja.ConstructorCall) *goja.Object {
+		label := "utf-8"
+		if !goja.IsUndefined(call.Argument(0)) {
+			label = strings.ToLower(strings.TrimSpace(call.Argument(0).String()))
+		}
+		if label != "utf-8" && label != "utf8" && label != "unicode-1-1-utf-8" {
+			panic(runtime.NewTypeError("TextDecoder only supports utf-8, not %s", label))
+		}
+
+		_ = call.This.Set("encoding", "utf-8")
+		_ = call.This.Set("decode", func(input goja.Value) string {
+			if input == nil || goja.IsUndefined(input) {
+				return ""
+			}
+			if buffer, ok := input.Export().(goja.ArrayBuffer); ok {
+				return decodeUTF8(buffer.Bytes())
+			}
+			var content []byte
+			err := runtime.ExportTo(input, &content)
+			if err != nil {
+				panic(runtime.NewTypeError("TextDecoder.decode expects an ArrayBuffer or a typed array"))
+			}
+			return decodeUTF8(content)
+		})
+		return nil
+	})
+}
+
+// decodeUTF8 decodes content like TextDecoder: a leading byte order mark is
+// dropped and invalid sequences are replaced by U+FFFD
+func decodeUTF8(content []byte) string {
+	if len(content) >= 3 && content[0] == 0xEF && content[1] == 0xBB && content[2] == 0xBF {
+		content = content[3:]
+	}
+	if utf8.Valid(content) {
+		return string(content)
+	}
+
+	var b strings.Builder
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		b.WriteRune(r)
+		content = content[size:]
+	}
+	return b.String()
+}
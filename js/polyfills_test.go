@@ -0,0 +1,52 @@
+package js
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVM_TextEncoding(t *testing.T) {
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "encode.js", `Array.from(new TextEncoder().encode("hé€")).join(",")`)
+	assert.NoError(t, err)
+	assert.Equal(t, "104,195,169,226,130,172", out)
+
+	out, err = vm.Eval(context.Background(), "roundtrip.js", `
+		var encoded = new TextEncoder().encode("Grüße 👋")
+		new TextDecoder().decode(encoded) + " " + new TextDecoder("utf-8").decode(encoded.buffer)
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, "Grüße 👋 Grüße 👋", out)
+
+	//invalid sequences are replaced and the byte order mark is dropped
+	out, err = vm.Eval(context.Background(), "invalid.js", `new TextDecoder().decode(new Uint8Array([0xEF, 0xBB, 0xBF, 0x61, 0xFF, 0x62]))`)
+	assert.NoError(t, err)
+	assert.Equal(t, "a�b", out)
+
+	_, err = vm.Eval(context.Background(), "latin1.js", `new TextDecoder("latin1")`)
+	assert.ErrorContains(t, err, "only supports utf-8")
+}
+
+func TestGojaVM_Polyfills(t *testing.T) {
+	greet := func(runtime *goja.Runtime) error {
+		return runtime.Set("greet", func(name string) string {
+			return "Hello " + name
+		})
+	}
+	//user polyfills replace the built-in ones
+	noFetch := func(runtime *goja.Runtime) error {
+		return runtime.Set("fetch", "replaced")
+	}
+
+	vm, err := newGojaVM(VMOptions{Polyfills: []GojaPolyfill{greet, noFetch}})
+	assert.NoError(t, err)
+
+	out, err := vm.Eval(context.Background(), "polyfill.js", `greet("Ada") + " " + fetch`)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello Ada replaced", out)
+}
@@ -0,0 +1,101 @@
+package js
+
+import (
+	"errors"
+
+	"github.com/dop251/goja"
+)
+
+// maxTimerCallbacks bounds the timer callbacks run after a script, so a timer
+// that keeps scheduling itself can't block Eval forever
+const maxTimerCallbacks = 10000
+
+type timer struct {
+	id   int64
+	due  int64
+	fn   goja.Callable
+	args []goja.Value
+}
+
+// timers are the pending setTimeout callbacks of a VM. Time is virtual, in
+// milliseconds: the clock jumps to the next timer instead of waiting for it
+type timers struct {
+	now     int64
+	nextID  int64
+	pending map[int64]*timer
+}
+
+// next returns the timer due first, the one scheduled first on ties
+func (t *timers) next() *timer {
+	var next *timer
+	for _, candidate := range t.pending {
+		if next == nil || candidate.due < next.due || (candidate.due == next.due && candidate.id < next.id) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+func (t *timers) clear() {
+	t.pending = map[int64]*timer{}
+}
+
+// enableTimers registers setTimeout and clearTimeout. Callbacks are run by
+// runTimers once the script finished
+func (g *gojaVM) enableTimers() error {
+	g.timers = &timers{pending: map[int64]*timer{}}
+
+	err := g.runtime.Set("setTimeout", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(g.runtime.NewTypeError("setTimeout callback is not a function"))
+		}
+		delay := call.Argument(1).ToInteger()
+		if delay < 0 {
+			delay = 0
+		}
+
+		g.timers.nextID++
+		t := &timer{
+			id:  g.timers.nextID,
+			due: g.timers.now + delay,
+			fn:  fn,
+		}
+		//the arguments are on the VM stack, which is reused once setTimeout returns
+		if len(call.Arguments) > 2 {
+			t.args = append([]goja.Value(nil), call.Arguments[2:]...)
+		}
+		g.timers.pending[t.id] = t
+
+		return g.runtime.ToValue(t.id)
+	})
+	if err != nil {
+		return err
+	}
+
+	return g.runtime.Set("clearTimeout", func(call goja.FunctionCall) goja.Value {
+		delete(g.timers.pending, call.Argument(0).ToInteger())
+		return goja.Undefined()
+	})
+}
+
+// runTimers runs the pending timer callbacks in the order they're due, so work
+// deferred by the script is done before Eval returns without slowing it down
+func (g *gojaVM) runTimers() error {
+	for i := 0; len(g.timers.pending) > 0; i++ {
+		if i == maxTimerCallbacks {
+			return errors.New("too many setTimeout callbacks, a timer may be scheduling itself forever")
+		}
+
+		next := g.timers.next()
+		delete(g.timers.pending, next.id)
+		g.timers.now = next.due
+
+		_, err := next.fn(goja.Undefined(), next.args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
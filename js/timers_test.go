@@ -0,0 +1,60 @@
+package js
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVM_SetTimeout(t *testing.T) {
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	//callbacks run in the order they're due, before Eval returns
+	out, err := vm.Eval(context.Background(), "order.js", `
+		var calls = []
+		setTimeout(function (name) { calls.push(name) }, 20, "slow")
+		setTimeout(function () {
+			calls.push("fast")
+			setTimeout(function () { calls.push("nested") }, 5)
+		}, 10)
+		var cancelled = setTimeout(function () { calls.push("cancelled") })
+		clearTimeout(cancelled)
+		setTimeout(function () { calls.push("now") })
+		new Promise(function (resolve) {
+			setTimeout(function () { resolve(calls.join(",")) }, 100)
+		})
+	`)
+	assert.NoError(t, err)
+	assert.Equal(t, "now,fast,nested,slow", out)
+
+	//the delay isn't actually waited for
+	start := time.Now()
+	_, err = vm.Eval(context.Background(), "long.js", `setTimeout(function () {}, 60000)`)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestGojaVM_SetTimeout_Errors(t *testing.T) {
+	vm, err := newGojaVM(VMOptions{})
+	assert.NoError(t, err)
+
+	_, err = vm.Eval(context.Background(), "throws.js", `
+		setTimeout(function () { throw new Error("deferred failure") })
+		setTimeout(function () { globalThis.leaked = true })
+	`)
+	assert.ErrorContains(t, err, "deferred failure")
+
+	//the timers of a failed script don't run in the next one
+	out, err := vm.Eval(context.Background(), "next.js", `typeof leaked`)
+	assert.NoError(t, err)
+	assert.Equal(t, "undefined", out)
+
+	_, err = vm.Eval(context.Background(), "forever.js", `
+		function tick() { setTimeout(tick, 1) }
+		tick()
+	`)
+	assert.ErrorContains(t, err, "too many setTimeout callbacks")
+}
@@ -1,29 +1,51 @@
+//go:build v8
+// +build v8
+
 package js
 
 /*
+V8 support needs CGO and the v8go modules, which aren't required by default:
+
+	go get rogchap.com/v8go go.kuoruan.net/v8go-polyfills
+	go build -tags v8
+*/
+
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/puddle"
+	"github.com/mansoor-s/aviator/utils"
 	"go.kuoruan.net/v8go-polyfills/base64"
 	"go.kuoruan.net/v8go-polyfills/console"
 	"go.kuoruan.net/v8go-polyfills/fetch"
 	"go.kuoruan.net/v8go-polyfills/url"
-	"os"
 	"rogchap.com/v8go"
 )
 
 type V8VM struct {
 	context *v8go.Context
+	//programs are the sources run with EvalProgram, compiled once
+	programs map[string]*v8go.UnboundScript
 }
 
-type v8Error v8go.JSError
-
-func (e v8Error) Error() string {
-	return e.Message
-}
-
-func (e v8Error) ErrorStackTrace() string {
-	return e.StackTrace
+// newV8JSError converts JS errors to a *JSError. V8 doesn't read inline source
+// maps, so the frames point at the bundled script
+func newV8JSError(err error) error {
+	var jsErr *v8go.JSError
+	if errors.As(err, &jsErr) {
+		return &JSError{
+			Message: jsErr.Message,
+			Stack:   parseStackFrames(jsErr.StackTrace),
+			err:     err,
+		}
+	}
+	return err
 }
 
 func newV8VM() (*V8VM, error) {
@@ -39,14 +61,14 @@ func newV8VM() (*V8VM, error) {
 		if err != nil {
 			//clean up on error
 			isolate.TerminateExecution()
-			isolate.Dispose()
 			if v8Ctx != nil {
 				v8Ctx.Close()
 			}
+			isolate.Dispose()
 		}
 	}()
 
-	//TODO: why is this needed?
+	//the polyfills are installed on the global object template
 	global := v8go.NewObjectTemplate(isolate)
 
 	err = base64.InjectTo(isolate, global)
@@ -62,7 +84,8 @@ func newV8VM() (*V8VM, error) {
 
 	v8Ctx = v8go.NewContext(isolate, global)
 	if v8Ctx == nil {
-		return nil, errors.New("unable to create a new V8 context")
+		err = errors.New("unable to create a new V8 context")
+		return nil, err
 	}
 
 	// URL support
@@ -82,14 +105,13 @@ func newV8VM() (*V8VM, error) {
 	}
 
 	return &V8VM{
-		context: v8Ctx,
+		context:  v8Ctx,
+		programs: map[string]*v8go.UnboundScript{},
 	}, nil
 }
 
-//var _ VM = (*V8VM)(nil)
-
-//InitializationScript compiles and runs a script into the context's isolate
-func (vm *V8VM) InitializationScript(_ context.Context, path, code string) error {
+// InitializationScript compiles and runs a script into the context's isolate
+func (vm *V8VM) InitializationScript(path, code string) error {
 	script, err := vm.context.Isolate().CompileUnboundScript(code, path, v8go.CompileOptions{})
 	if err != nil {
 		return err
@@ -101,11 +123,52 @@ func (vm *V8VM) InitializationScript(_ context.Context, path, code string) error
 	return nil
 }
 
-//Eval runs the specified script. The script output MUST be a string.
+// Eval runs the specified script. The script output MUST be a string.
 // if the return value is a JS object, it should be return with the output of JSON.stringify()
-func (vm *V8VM) Eval(_ context.Context, path, expr string) (string, error) {
-	value, err := vm.context.RunScript(expr, path)
+// The script is terminated when ctx is done, in which case ctx.Err() is returned
+func (vm *V8VM) Eval(ctx context.Context, path, expr string) (string, error) {
+	return vm.run(ctx, func() (*v8go.Value, error) {
+		return vm.context.RunScript(expr, path)
+	})
+}
+
+// EvalProgram is Eval for scripts run over and over. source is compiled on its
+// first run only, what changes between runs is passed as globals instead
+func (vm *V8VM) EvalProgram(ctx context.Context, path, source string, globals map[string]interface{}) (string, error) {
+	script, ok := vm.programs[source]
+	if !ok {
+		var err error
+		script, err = vm.context.Isolate().CompileUnboundScript(source, path, v8go.CompileOptions{})
+		if err != nil {
+			return "", err
+		}
+		vm.programs[source] = script
+	}
+
+	for name, value := range globals {
+		err := vm.SetGlobal(name, value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return vm.run(ctx, func() (*v8go.Value, error) {
+		return script.Run(vm.context)
+	})
+}
+
+// run calls script, which runs the evaluated code, and reads its result
+func (vm *V8VM) run(ctx context.Context, script func() (*v8go.Value, error)) (string, error) {
+	if ctx.Done() != nil {
+		stop := vm.terminateWhenDone(ctx)
+		defer stop()
+	}
+
+	value, err := script()
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 		return "", err
 	}
 	// Handle promises
@@ -114,18 +177,228 @@ func (vm *V8VM) Eval(_ context.Context, path, expr string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		// TODO: this could run forever
 		for prom.State() == v8go.Pending {
-			continue
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			vm.context.PerformMicrotaskCheckpoint()
+		}
+		if prom.State() == v8go.Rejected {
+			return "", errors.New(prom.Result().DetailString())
 		}
 		return prom.Result().String(), nil
 	}
 	return value.String(), nil
 }
 
+// terminateWhenDone terminates the running script when ctx is done. The
+// returned func stops watching ctx and must be called once the script finished
+func (vm *V8VM) terminateWhenDone(ctx context.Context) func() {
+	finished := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			vm.context.Isolate().TerminateExecution()
+		case <-finished:
+		}
+	}()
+
+	return func() {
+		close(finished)
+		wg.Wait()
+	}
+}
+
+// SetGlobal sets a global variable. Values are copied into the VM as JSON, except
+// for SSR bridge functions, which are callable from JS
+func (vm *V8VM) SetGlobal(name string, value interface{}) error {
+	if fn, ok := value.(func(args ...interface{}) (interface{}, error)); ok {
+		return vm.setGlobalFunc(name, fn)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("the v8 engine can only set JSON serializable globals: %w", err)
+	}
+	jsValue, err := v8go.JSONParse(vm.context, string(encoded))
+	if err != nil {
+		return err
+	}
+
+	return vm.context.Global().Set(name, jsValue)
+}
+
+// setGlobalFunc exposes fn to JS. Arguments and the result are passed as JSON,
+// an error is thrown as a JS exception
+func (vm *V8VM) setGlobalFunc(name string, fn func(args ...interface{}) (interface{}, error)) error {
+	isolate := vm.context.Isolate()
+	throw := func(err error) *v8go.Value {
+		message, _ := v8go.NewValue(isolate, err.Error())
+		return isolate.ThrowException(message)
+	}
+
+	template := v8go.NewFunctionTemplate(isolate, func(info *v8go.FunctionCallbackInfo) *v8go.Value {
+		var args []interface{}
+		for _, arg := range info.Args() {
+			encoded, err := arg.MarshalJSON()
+			if err != nil {
+				return throw(err)
+			}
+			var decoded interface{}
+			err = json.Unmarshal(encoded, &decoded)
+			if err != nil {
+				return throw(err)
+			}
+			args = append(args, decoded)
+		}
+
+		result, err := fn(args...)
+		if err != nil {
+			return throw(err)
+		}
+
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return throw(err)
+		}
+		jsValue, err := v8go.JSONParse(info.Context(), string(encoded))
+		if err != nil {
+			return throw(err)
+		}
+		return jsValue
+	})
+
+	return vm.context.Global().Set(name, template.GetFunction(vm.context))
+}
+
 func (vm *V8VM) Close() {
-	vm.context.Close()
 	vm.context.Isolate().TerminateExecution()
+	vm.context.Close()
 	vm.context.Isolate().Dispose()
 }
-*/
+
+type v8VMPool struct {
+	poolSize int
+	pool     *puddle.Pool
+
+	acquireTimeout time.Duration
+	logger         utils.Logger
+}
+
+var _ VM = &v8VMPool{}
+
+// NewV8VMPool creates a pool of poolSize V8 VMs. Each VM has its own isolate.
+// Only the acquire timeout and logger of options apply to V8
+func NewV8VMPool(poolSize int, options VMOptions) (VM, error) {
+	constructorFn := func(ctx context.Context) (interface{}, error) {
+		return newV8VM()
+	}
+
+	destructorFn := func(res interface{}) {
+		res.(*V8VM).Close()
+	}
+
+	return &v8VMPool{
+		poolSize:       poolSize,
+		pool:           puddle.NewPool(constructorFn, destructorFn, int32(poolSize)),
+		acquireTimeout: options.AcquireTimeout,
+		logger:         options.Logger,
+	}, nil
+}
+
+func (p *v8VMPool) RunScript(_ string) (string, error) {
+	return "", errors.New("precompiled scripts are not supported by the v8 engine")
+}
+
+func (p *v8VMPool) Eval(ctx context.Context, path, expression string) (string, error) {
+	res, err := acquireVM(ctx, p.pool, p.acquireTimeout, p.logger)
+	if err != nil {
+		return "", err
+	}
+	defer res.Release()
+
+	//the context may have been done while waiting for a VM
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	vm := res.Value().(*V8VM)
+
+	val, err := vm.Eval(ctx, path, expression)
+	if err != nil {
+		return "", newV8JSError(err)
+	}
+
+	return val, nil
+}
+
+func (p *v8VMPool) EvalProgram(ctx context.Context, path, source string, globals map[string]interface{}) (string, error) {
+	res, err := acquireVM(ctx, p.pool, p.acquireTimeout, p.logger)
+	if err != nil {
+		return "", err
+	}
+	defer res.Release()
+
+	//the context may have been done while waiting for a VM
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	vm := res.Value().(*V8VM)
+
+	val, err := vm.EvalProgram(ctx, path, source, globals)
+	if err != nil {
+		return "", newV8JSError(err)
+	}
+
+	return val, nil
+}
+
+// InitializationScript runs an initialization script on all VM instances
+func (p *v8VMPool) InitializationScript(path, source string) error {
+	return p.forAll(func(vm *V8VM) error {
+		return newV8JSError(vm.InitializationScript(path, source))
+	})
+}
+
+// SetGlobal sets a global variable on all VM instances
+func (p *v8VMPool) SetGlobal(name string, value interface{}) error {
+	return p.forAll(func(vm *V8VM) error {
+		return vm.SetGlobal(name, value)
+	})
+}
+
+// forAll acquires every VM, so none is used before fn ran on all of them
+func (p *v8VMPool) forAll(fn func(vm *V8VM) error) error {
+	var allVMResources []*puddle.Resource
+	defer func() {
+		for _, res := range allVMResources {
+			res.Release()
+		}
+	}()
+
+	for i := 0; i < p.poolSize; i++ {
+		res, err := p.pool.Acquire(context.Background())
+		if err != nil {
+			return err
+		}
+		allVMResources = append(allVMResources, res)
+	}
+
+	for _, res := range allVMResources {
+		err := fn(res.Value().(*V8VM))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close waits for the VMs in use to be released and destroys all of them
+func (p *v8VMPool) Close() {
+	p.pool.Close()
+}
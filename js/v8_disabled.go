@@ -0,0 +1,9 @@
+//go:build !v8
+// +build !v8
+
+package js
+
+// NewV8VMPool always fails without the v8 build tag
+func NewV8VMPool(_ int, _ VMOptions) (VM, error) {
+	return nil, ErrV8Unavailable
+}
@@ -2,7 +2,10 @@ package js
 
 import (
 	"context"
+	"time"
+
 	"github.com/jackc/puddle"
+	"github.com/mansoor-s/aviator/utils"
 )
 
 // VM for evaluating javascript
@@ -10,126 +13,33 @@ type VM interface {
 	//PreCompile(uniqueName string, source string) error
 	RunScript(uniqueName string) (string, error)
 	InitializationScript(path, source string) error
-	Eval(path, expression string) (string, error)
+	//SetGlobal sets a global variable on all VM instances. Go functions are
+	//callable from JS
+	SetGlobal(name string, value interface{}) error
+	//Eval evaluates expression on one of the VM instances. Waiting for an
+	//instance and the evaluation itself are aborted when ctx is done
+	Eval(ctx context.Context, path, expression string) (string, error)
+	//EvalProgram is Eval for a source evaluated over and over with different
+	//globals, which are set on the instance first. Each instance compiles
+	//source once
+	EvalProgram(ctx context.Context, path, source string, globals map[string]interface{}) (string, error)
 	//Close()
 }
 
-/*
-type v8VMPool struct {
-	//v8   *V8VM
-	poolSize int
-	pool     *puddle.Pool
-}
-
-//var _ VM = &v8VMPool{}
-
-// NewV8VMPool creates a new VM pool. it will run initScript script on every newly created script
-// for our use-case, that means compiling an instance of the svelte compiler so that doesn't have
-// to happen for every request
-func NewV8VMPool(poolSize int, initScript string) (*v8VMPool, error) {
-	constructorFn := func(ctx context.Context) (interface{}, error) {
-		vm, err := newV8VM()
-		if err != nil {
-			return nil, err
-		}
-
-		return vm, nil
-	}
-
-	destructorFn := func(res interface{}) {
-		res.(*V8VM).Close()
-	}
-
-	pool := puddle.NewPool(constructorFn, destructorFn, int32(poolSize))
-
-	//allocate full pool size
-	for i := 0; i < poolSize; i++ {
-		err := pool.CreateResource(context.Background())
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	v8Pool := &v8VMPool{
-		pool:     pool,
-		poolSize: poolSize,
-	}
-
-	err := v8Pool.InitializationScript(
-		context.Background(),
-		"__aviator_vm_init_script",
-		initScript,
-	)
-	if err != nil {
-		return nil, newV8JSError(err)
-	}
-
-	return v8Pool, nil
-}
-
-//InitializationScript runs an initialization script on all VM instances
-func (p *v8VMPool) InitializationScript(ctx context.Context, path, script string) error {
-	//acquire all VMs, so they aren't released before initialization is completed
-	var allVMResources []*puddle.Resource
-
-	for i := 0; i < p.poolSize; i++ {
-		res, err := p.pool.Acquire(ctx)
-		if err != nil {
-			return err
-		}
-		allVMResources = append(allVMResources, res)
-	}
-
-	for i := 0; i < p.poolSize; i++ {
-		res := allVMResources[i]
-		vm := res.Value().(*V8VM)
-		err := vm.InitializationScript(ctx, path, script)
-		if err != nil {
-			return err
-		}
-	}
-
-	for i := 0; i < p.poolSize; i++ {
-		allVMResources[i].Release()
-	}
-
-	return nil
-}
-
-func (p *v8VMPool) Eval(ctx context.Context, path, expression string) (string, error) {
-	res, err := p.pool.Acquire(ctx)
-	defer res.Release()
-	if err != nil {
-		return "", err
-	}
-
-	vm := res.Value().(*V8VM)
-
-	val, err := vm.Eval(ctx, path, expression)
-	if err != nil {
-		return "", newV8JSError(err)
-	}
-
-	return val, nil
-}
-
-func (p *v8VMPool) Close() {
-	if p.pool != nil {
-		p.pool.Close()
-	}
-}
-*/
 type gojaVMPool struct {
 	poolSize int
 
 	pool *puddle.Pool
+
+	acquireTimeout time.Duration
+	logger         utils.Logger
 }
 
 var _ VM = &gojaVMPool{}
 
-func NewGojaVMPool(poolSize int) (*gojaVMPool, error) {
+func NewGojaVMPool(poolSize int, options VMOptions) (*gojaVMPool, error) {
 	constructorFn := func(ctx context.Context) (interface{}, error) {
-		vm, err := newGojaVM()
+		vm, err := newGojaVM(options)
 		if err != nil {
 			return nil, err
 		}
@@ -144,39 +54,68 @@ func NewGojaVMPool(poolSize int) (*gojaVMPool, error) {
 	pool := puddle.NewPool(constructorFn, destructorFn, int32(poolSize))
 
 	return &gojaVMPool{
-		poolSize: poolSize,
-		pool:     pool,
+		poolSize:       poolSize,
+		pool:           pool,
+		acquireTimeout: options.AcquireTimeout,
+		logger:         options.Logger,
 	}, nil
 }
 
 func (g *gojaVMPool) RunScript(uniqueName string) (string, error) {
-	res, err := g.pool.Acquire(context.Background())
-	defer res.Release()
+	res, err := acquireVM(context.Background(), g.pool, g.acquireTimeout, g.logger)
 	if err != nil {
 		return "", err
 	}
+	defer res.Release()
 
 	vm := res.Value().(*gojaVM)
 
 	return vm.RunScript(uniqueName)
 }
 
-func (g *gojaVMPool) Eval(path, source string) (string, error) {
-	res, err := g.pool.Acquire(context.Background())
+func (g *gojaVMPool) Eval(ctx context.Context, path, source string) (string, error) {
+	res, err := acquireVM(ctx, g.pool, g.acquireTimeout, g.logger)
+	if err != nil {
+		return "", err
+	}
 	defer res.Release()
+
+	//the context may have been done while waiting for a VM
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	vm := res.Value().(*gojaVM)
+
+	return vm.Eval(ctx, path, source)
+}
+
+func (g *gojaVMPool) EvalProgram(ctx context.Context, path, source string, globals map[string]interface{}) (string, error) {
+	res, err := acquireVM(ctx, g.pool, g.acquireTimeout, g.logger)
 	if err != nil {
 		return "", err
 	}
+	defer res.Release()
+
+	//the context may have been done while waiting for a VM
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
 	vm := res.Value().(*gojaVM)
 
-	return vm.Eval(path, source)
+	return vm.EvalProgram(ctx, path, source, globals)
 }
 
 //InitializationScript runs an initialization script on all VM instances
 func (g *gojaVMPool) InitializationScript(path, source string) error {
 	//acquire all VMs, so they aren't released before initialization is completed
 	var allVMResources []*puddle.Resource
+	defer func() {
+		for _, res := range allVMResources {
+			res.Release()
+		}
+	}()
 
 	for i := 0; i < g.poolSize; i++ {
 		res, err := g.pool.Acquire(context.Background())
@@ -189,20 +128,47 @@ func (g *gojaVMPool) InitializationScript(path, source string) error {
 	for i := 0; i < g.poolSize; i++ {
 		res := allVMResources[i]
 		vm := res.Value().(*gojaVM)
-		_, err := vm.Eval(path, source)
+		_, err := vm.Eval(context.Background(), path, source)
 
 		if err != nil {
 			return err
 		}
 	}
 
+	return nil
+}
+
+// SetGlobal sets a global variable on all VM instances
+func (g *gojaVMPool) SetGlobal(name string, value interface{}) error {
+	//acquire all VMs, so every instance is set
+	var allVMResources []*puddle.Resource
+	defer func() {
+		for _, res := range allVMResources {
+			res.Release()
+		}
+	}()
+
 	for i := 0; i < g.poolSize; i++ {
-		allVMResources[i].Release()
+		res, err := g.pool.Acquire(context.Background())
+		if err != nil {
+			return err
+		}
+		allVMResources = append(allVMResources, res)
+	}
+
+	for _, res := range allVMResources {
+		vm := res.Value().(*gojaVM)
+		err := vm.SetGlobal(name, value)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Close waits for the VMs in use to be released and destroys all of them.
+// Acquiring a VM fails afterwards
 func (g *gojaVMPool) Close() {
-	//noop
+	g.pool.Close()
 }
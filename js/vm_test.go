@@ -0,0 +1,38 @@
+package js
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGojaVMPool_AcquireTimeout(t *testing.T) {
+	logger := &recordingLogger{}
+	pool, err := NewGojaVMPool(1, VMOptions{Logger: logger, AcquireTimeout: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	//keep the only VM busy
+	res, err := pool.pool.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	_, err = pool.Eval(context.Background(), "busy.js", "1 + 1")
+	assert.ErrorIs(t, err, ErrVMPoolExhausted)
+	assert.Len(t, logger.errors, 1)
+	assert.Contains(t, logger.errors[0], "all 1 VMs were busy")
+
+	//a caller giving up first gets its own error and isn't logged
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.Eval(ctx, "cancelled.js", "1 + 1")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, logger.errors, 1)
+
+	res.Release()
+	out, err := pool.Eval(context.Background(), "free.js", "1 + 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", out)
+}
@@ -58,18 +58,19 @@ func New(intervalBatcher time.Duration) (*Batcher, error) {
 }
 
 func (b *Batcher) run() {
-	tick := time.Tick(b.interval)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
 	evs := make([]fsnotify.Event, 0)
 OuterLoop:
 	for {
 		select {
 		case ev := <-b.FileWatcher.Events():
 			evs = append(evs, ev)
-		case <-tick:
+		case <-ticker.C:
 			if len(evs) == 0 {
 				continue
 			}
-			b.Events <- evs
+			b.Events <- dedupEvents(evs)
 			evs = make([]fsnotify.Event, 0)
 		case <-b.done:
 			break OuterLoop
@@ -79,7 +80,29 @@ OuterLoop:
 }
 
 // Close stops the watching of the files.
-func (b *Batcher) Close() {
+func (b *Batcher) Close() error {
 	b.done <- struct{}{}
-	b.FileWatcher.Close()
+	return b.FileWatcher.Close()
+}
+
+// dedupEvents collapses all events for the same file into a single event.
+// The ops of the collapsed events are combined so a Create followed by a Write
+// is still handled as a Create, and a Create followed by a Remove carries both
+// ops so the file is rescanned after it's gone. Events keep the order their file was first seen in
+func dedupEvents(evs []fsnotify.Event) []fsnotify.Event {
+	deduped := make([]fsnotify.Event, 0, len(evs))
+	indexByName := make(map[string]int, len(evs))
+
+	for _, ev := range evs {
+		i, ok := indexByName[ev.Name]
+		if ok {
+			deduped[i].Op |= ev.Op
+			continue
+		}
+
+		indexByName[ev.Name] = len(deduped)
+		deduped = append(deduped, ev)
+	}
+
+	return deduped
 }
@@ -0,0 +1,30 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupEvents(t *testing.T) {
+	evs := []fsnotify.Event{
+		{Name: "/views/index.svelte", Op: fsnotify.Write},
+		{Name: "/views/users", Op: fsnotify.Create},
+		{Name: "/views/index.svelte", Op: fsnotify.Write},
+		{Name: "/views/index.svelte", Op: fsnotify.Write},
+		{Name: "/views/users/list.svelte", Op: fsnotify.Create},
+		{Name: "/views/users/list.svelte", Op: fsnotify.Write},
+		{Name: "/views/users/draft.svelte", Op: fsnotify.Create},
+		{Name: "/views/users/draft.svelte", Op: fsnotify.Remove},
+	}
+
+	deduped := dedupEvents(evs)
+
+	assert.Equal(t, []fsnotify.Event{
+		{Name: "/views/index.svelte", Op: fsnotify.Write},
+		{Name: "/views/users", Op: fsnotify.Create},
+		{Name: "/views/users/list.svelte", Op: fsnotify.Create | fsnotify.Write},
+		{Name: "/views/users/draft.svelte", Op: fsnotify.Create | fsnotify.Remove},
+	}, deduped)
+}